@@ -0,0 +1,297 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: exchanger/v1/exchanger.proto
+
+package exchangerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ExchangerService_GetRate_FullMethodName     = "/exchanger.v1.ExchangerService/GetRate"
+	ExchangerService_Convert_FullMethodName     = "/exchanger.v1.ExchangerService/Convert"
+	ExchangerService_Subscribe_FullMethodName   = "/exchanger.v1.ExchangerService/Subscribe"
+	ExchangerService_Unsubscribe_FullMethodName = "/exchanger.v1.ExchangerService/Unsubscribe"
+	ExchangerService_WatchRates_FullMethodName  = "/exchanger.v1.ExchangerService/WatchRates"
+)
+
+// ExchangerServiceClient is the client API for ExchangerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExchangerServiceClient interface {
+	// GetRate returns the current rate of the pair, USD-UAH official rate by default.
+	GetRate(ctx context.Context, in *GetRateRequest, opts ...grpc.CallOption) (*Rate, error)
+	// Convert converts amount using the current rates, or rates known by the end of the date.
+	Convert(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (*Conversion, error)
+	// Subscribe creates pending subscription and sends confirmation email.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error)
+	// Unsubscribe deletes subscription of the signed unsubscribe link token.
+	Unsubscribe(ctx context.Context, in *UnsubscribeRequest, opts ...grpc.CallOption) (*UnsubscribeResponse, error)
+	// WatchRates streams rate changes starting with the latest known rates of filtered pairs,
+	// or with events missed since last_event_id if they are still kept.
+	WatchRates(ctx context.Context, in *WatchRatesRequest, opts ...grpc.CallOption) (ExchangerService_WatchRatesClient, error)
+}
+
+type exchangerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExchangerServiceClient(cc grpc.ClientConnInterface) ExchangerServiceClient {
+	return &exchangerServiceClient{cc}
+}
+
+func (c *exchangerServiceClient) GetRate(ctx context.Context, in *GetRateRequest, opts ...grpc.CallOption) (*Rate, error) {
+	out := new(Rate)
+	err := c.cc.Invoke(ctx, ExchangerService_GetRate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangerServiceClient) Convert(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (*Conversion, error) {
+	out := new(Conversion)
+	err := c.cc.Invoke(ctx, ExchangerService_Convert_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangerServiceClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error) {
+	out := new(SubscribeResponse)
+	err := c.cc.Invoke(ctx, ExchangerService_Subscribe_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangerServiceClient) Unsubscribe(ctx context.Context, in *UnsubscribeRequest, opts ...grpc.CallOption) (*UnsubscribeResponse, error) {
+	out := new(UnsubscribeResponse)
+	err := c.cc.Invoke(ctx, ExchangerService_Unsubscribe_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *exchangerServiceClient) WatchRates(ctx context.Context, in *WatchRatesRequest, opts ...grpc.CallOption) (ExchangerService_WatchRatesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ExchangerService_ServiceDesc.Streams[0], ExchangerService_WatchRates_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &exchangerServiceWatchRatesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ExchangerService_WatchRatesClient interface {
+	Recv() (*RateEvent, error)
+	grpc.ClientStream
+}
+
+type exchangerServiceWatchRatesClient struct {
+	grpc.ClientStream
+}
+
+func (x *exchangerServiceWatchRatesClient) Recv() (*RateEvent, error) {
+	m := new(RateEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExchangerServiceServer is the server API for ExchangerService service.
+// All implementations must embed UnimplementedExchangerServiceServer
+// for forward compatibility
+type ExchangerServiceServer interface {
+	// GetRate returns the current rate of the pair, USD-UAH official rate by default.
+	GetRate(context.Context, *GetRateRequest) (*Rate, error)
+	// Convert converts amount using the current rates, or rates known by the end of the date.
+	Convert(context.Context, *ConvertRequest) (*Conversion, error)
+	// Subscribe creates pending subscription and sends confirmation email.
+	Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error)
+	// Unsubscribe deletes subscription of the signed unsubscribe link token.
+	Unsubscribe(context.Context, *UnsubscribeRequest) (*UnsubscribeResponse, error)
+	// WatchRates streams rate changes starting with the latest known rates of filtered pairs,
+	// or with events missed since last_event_id if they are still kept.
+	WatchRates(*WatchRatesRequest, ExchangerService_WatchRatesServer) error
+	mustEmbedUnimplementedExchangerServiceServer()
+}
+
+// UnimplementedExchangerServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedExchangerServiceServer struct {
+}
+
+func (UnimplementedExchangerServiceServer) GetRate(context.Context, *GetRateRequest) (*Rate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRate not implemented")
+}
+func (UnimplementedExchangerServiceServer) Convert(context.Context, *ConvertRequest) (*Conversion, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Convert not implemented")
+}
+func (UnimplementedExchangerServiceServer) Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedExchangerServiceServer) Unsubscribe(context.Context, *UnsubscribeRequest) (*UnsubscribeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unsubscribe not implemented")
+}
+func (UnimplementedExchangerServiceServer) WatchRates(*WatchRatesRequest, ExchangerService_WatchRatesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchRates not implemented")
+}
+func (UnimplementedExchangerServiceServer) mustEmbedUnimplementedExchangerServiceServer() {}
+
+// UnsafeExchangerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExchangerServiceServer will
+// result in compilation errors.
+type UnsafeExchangerServiceServer interface {
+	mustEmbedUnimplementedExchangerServiceServer()
+}
+
+func RegisterExchangerServiceServer(s grpc.ServiceRegistrar, srv ExchangerServiceServer) {
+	s.RegisterService(&ExchangerService_ServiceDesc, srv)
+}
+
+func _ExchangerService_GetRate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangerServiceServer).GetRate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExchangerService_GetRate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangerServiceServer).GetRate(ctx, req.(*GetRateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExchangerService_Convert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConvertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangerServiceServer).Convert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExchangerService_Convert_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangerServiceServer).Convert(ctx, req.(*ConvertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExchangerService_Subscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubscribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangerServiceServer).Subscribe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExchangerService_Subscribe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangerServiceServer).Subscribe(ctx, req.(*SubscribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExchangerService_Unsubscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnsubscribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExchangerServiceServer).Unsubscribe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ExchangerService_Unsubscribe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExchangerServiceServer).Unsubscribe(ctx, req.(*UnsubscribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExchangerService_WatchRates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRatesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExchangerServiceServer).WatchRates(m, &exchangerServiceWatchRatesServer{stream})
+}
+
+type ExchangerService_WatchRatesServer interface {
+	Send(*RateEvent) error
+	grpc.ServerStream
+}
+
+type exchangerServiceWatchRatesServer struct {
+	grpc.ServerStream
+}
+
+func (x *exchangerServiceWatchRatesServer) Send(m *RateEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ExchangerService_ServiceDesc is the grpc.ServiceDesc for ExchangerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ExchangerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "exchanger.v1.ExchangerService",
+	HandlerType: (*ExchangerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRate",
+			Handler:    _ExchangerService_GetRate_Handler,
+		},
+		{
+			MethodName: "Convert",
+			Handler:    _ExchangerService_Convert_Handler,
+		},
+		{
+			MethodName: "Subscribe",
+			Handler:    _ExchangerService_Subscribe_Handler,
+		},
+		{
+			MethodName: "Unsubscribe",
+			Handler:    _ExchangerService_Unsubscribe_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRates",
+			Handler:       _ExchangerService_WatchRates_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "exchanger/v1/exchanger.proto",
+}
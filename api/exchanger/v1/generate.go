@@ -0,0 +1,4 @@
+// Package exchangerv1 holds gRPC API of the exchanger generated from exchanger.proto.
+package exchangerv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative exchanger/v1/exchanger.proto
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/database"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services"
+	_ "github.com/lib/pq"
+)
+
+const (
+	MaxDBConnections = 1
+	CommandTimeout   = 10 * time.Second
+)
+
+const usage = `Manages API keys of the exchanger API.
+
+Usage:
+  apikeys [-db-dsn DSN] issue -name NAME [-limit REQUESTS_PER_MINUTE]
+  apikeys [-db-dsn DSN] revoke -id ID
+  apikeys [-db-dsn DSN] list
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("apikeys", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	dsn := flags.String("db-dsn", os.Getenv("EXCHANGER_DSN"), "Data source name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("command is required")
+	}
+
+	db, err := database.OpenDB(*dsn, database.Options{MaxOpenConnections: MaxDBConnections})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	service := services.NewAPIKeyService(&repositories.PostgresAPIKeyRepository{DB: db}, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "issue":
+		return issue(ctx, service, commandArgs)
+	case "revoke":
+		return revoke(ctx, service, commandArgs)
+	case "list":
+		return list(ctx, service)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func issue(ctx context.Context, service *services.APIKeyService, args []string) error {
+	flags := flag.NewFlagSet("issue", flag.ContinueOnError)
+	name := flags.String("name", "", "Name of the key owner")
+	limit := flags.Int("limit", 0, "Requests per minute, default quota of keys if not set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("name is required")
+	}
+
+	key, id, err := service.Issue(ctx, *name, *limit)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Issued key %d for %s, it is not shown again:\n%s\n", id, *name, key)
+	return nil
+}
+
+func revoke(ctx context.Context, service *services.APIKeyService, args []string) error {
+	flags := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	id := flags.Int("id", 0, "ID of the key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	err := service.Revoke(ctx, *id)
+	if errors.Is(err, repositories.ErrAPIKeyDoesNotExist) {
+		return fmt.Errorf("active key %d is not found", *id)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Revoked key %d, servers stop accepting it within %s\n", *id, services.DefaultAPIKeyCacheTTL)
+	return nil
+}
+
+func list(ctx context.Context, service *services.APIKeyService) error {
+	keys, err := service.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tLIMIT\tCREATED\tREVOKED")
+	for _, key := range keys {
+		limit, revoked := "default", "-"
+		if key.RateLimit.Valid {
+			limit = strconv.Itoa(int(key.RateLimit.Int32))
+		}
+		if key.RevokedAt.Valid {
+			revoked = key.RevokedAt.Time.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", key.ID, key.Name, limit, key.CreatedAt.Format(time.RFC3339), revoked)
+	}
+	return w.Flush()
+}
@@ -2,18 +2,38 @@ package data
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/lib/pq"
 )
 
+// ErrCustomerRejected is returned when database rejects customer, so retrying does not help.
+var ErrCustomerRejected = errors.New("customer is rejected")
+
+// transientErrorClasses are classes of errors, that go away once database is available again:
+// connection exception, insufficient resources and operator intervention.
+var transientErrorClasses = []pq.ErrorClass{"08", "53", "57"}
+
 type CustomerPostgreSQLRepository struct {
 	DB *sql.DB
 }
 
+// Insert creates customer or links existing customer of the email to subscription, so requests,
+// which are delivered more than once, do not fail.
 func (ctr *CustomerPostgreSQLRepository) Insert(email string, subscriptionID int) (int, error) {
-	query := `INSERT INTO customers (email, subscription_id) VALUES ($1, $2) RETURNING id`
+	query := `INSERT INTO customers (email, subscription_id) VALUES ($1, $2)
+	ON CONFLICT (email) DO UPDATE SET subscription_id = EXCLUDED.subscription_id
+	RETURNING id`
 
 	var id int
 	row := ctr.DB.QueryRow(query, email, subscriptionID)
 	err := row.Scan(&id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && !slices.Contains(transientErrorClasses, pqErr.Code.Class()) {
+		return 0, fmt.Errorf("%w: %w", ErrCustomerRejected, err)
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -1,7 +1,8 @@
 package messaging
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,76 +11,36 @@ import (
 	"github.com/fdemchenko/exchanger/internal/communication"
 	"github.com/fdemchenko/exchanger/internal/communication/customers"
 	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
-	amqp "github.com/rabbitmq/amqp091-go"
-	"github.com/rs/zerolog/log"
 )
 
-type customerCreationConsumer struct {
-	channel       *amqp.Channel
+type customerCreationHandler struct {
 	customersRepo *data.CustomerPostgreSQLRepository
 	producer      *rabbitmq.GenericProducer
 }
 
+// NewCustomerCreationConsumer creates customers and replies with result of creation.
 func NewCustomerCreationConsumer(
-	channel *amqp.Channel,
+	channel *rabbitmq.Channel,
 	customersRepo *data.CustomerPostgreSQLRepository,
 	producer *rabbitmq.GenericProducer,
-) *customerCreationConsumer {
-	return &customerCreationConsumer{
-		channel:       channel,
-		customersRepo: customersRepo,
-		producer:      producer,
-	}
-}
-
-func (ccc *customerCreationConsumer) StartListening() error {
-	var startingError error
-	go func() {
-		deliveries, err := ccc.channel.Consume(
-			customers.CreateCustomerRequestQueue,
-			"",
-			false, false, false, false, nil,
-		)
-		if err != nil {
-			startingError = err
-			return
-		}
-
-		for delivery := range deliveries {
-			if err := ccc.handleDelivery(delivery); err != nil {
-				log.Error().Err(err).Send()
-			}
-			if err := delivery.Ack(false); err != nil {
-				log.Error().Err(err).Send()
-			}
-		}
-	}()
-
-	return startingError
+	opts ...communication.ConsumerOption,
+) *communication.Consumer {
+	handler := &customerCreationHandler{customersRepo: customersRepo, producer: producer}
+	consumer := communication.NewConsumer(channel, opts...)
+	communication.Handle(consumer, customers.CreateCustomerRequest, handler.handleCustomerCreation)
+	return consumer
 }
 
-func (ccc *customerCreationConsumer) handleDelivery(delivery amqp.Delivery) error {
-	msg := communication.Message[json.RawMessage]{}
-	err := json.Unmarshal(delivery.Body, &msg)
-	if err != nil {
+func (cch *customerCreationHandler) handleCustomerCreation(
+	_ context.Context,
+	msg communication.Message[customers.CreateCustomerRequestPayload],
+) error {
+	request := msg.Payload
+	id, err := cch.customersRepo.Insert(request.Email, request.SubscriptionID)
+	if err != nil && !errors.Is(err, data.ErrCustomerRejected) {
+		// request is retried, so subscription is not compensated while database is unavailable.
 		return err
 	}
-	switch msg.Type {
-	case customers.CreateCustomerRequest:
-		request := customers.CreateCustomerRequestPayload{}
-		err := json.Unmarshal(msg.Payload, &request)
-		if err != nil {
-			return err
-		}
-		return ccc.handleCustomerCreation(request)
-	default:
-		log.Error().Msg("Invalid message type in customer creation")
-	}
-	return nil
-}
-
-func (ccc *customerCreationConsumer) handleCustomerCreation(request customers.CreateCustomerRequestPayload) error {
-	id, err := ccc.customersRepo.Insert(request.Email, request.SubscriptionID)
 	s := fmt.Sprintf(`customers_created_total{success="%v"}`, err == nil)
 	metrics.GetOrCreateCounter(s).Inc()
 	var message any
@@ -94,5 +55,5 @@ func (ccc *customerCreationConsumer) handleCustomerCreation(request customers.Cr
 			Payload:       customers.CustomerCreatedPayload{ID: id},
 		}
 	}
-	return ccc.producer.SendMessage(message, customers.CreateCustomerResponseQueue)
+	return cch.producer.SendMessage(message, customers.CreateCustomerResponseQueue)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 	"os"
@@ -11,11 +12,12 @@ import (
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/fdemchenko/exchanger/cmd/customers/internal/data"
 	"github.com/fdemchenko/exchanger/cmd/customers/internal/messaging"
+	"github.com/fdemchenko/exchanger/internal/communication"
 	"github.com/fdemchenko/exchanger/internal/communication/customers"
 	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
 	"github.com/fdemchenko/exchanger/internal/database"
+	"github.com/fdemchenko/exchanger/internal/health"
 	"github.com/fdemchenko/exchanger/migrations"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -27,6 +29,10 @@ type config struct {
 	}
 	rabbitMQConnString string
 	addr               string
+	consumer           struct {
+		workers  int
+		prefetch int
+	}
 }
 
 const (
@@ -44,6 +50,17 @@ func main() {
 		os.Getenv("EXCHANGER_RABBITMQ_CONN_STRING"),
 		"RabbitMQ connection string",
 	)
+	flag.IntVar(&cfg.consumer.workers,
+		"consumer-workers",
+		communication.DefaultConsumerWorkers,
+		"Number of customer creation requests handled concurrently",
+	)
+	flag.IntVar(&cfg.consumer.prefetch,
+		"consumer-prefetch",
+		communication.DefaultConsumerPrefetch,
+		"Number of customer creation requests received before previous ones are handled",
+	)
+	flag.Parse()
 
 	zerolog.TimeFieldFormat = time.RFC3339
 	db, err := database.OpenDB(cfg.db.dsn, database.Options{MaxOpenConnections: cfg.db.maxOpenConnections})
@@ -58,28 +75,35 @@ func main() {
 	}
 	log.Info().Msg("Migrations successfully applied")
 
-	rabbitMQConn, err := amqp.Dial(cfg.rabbitMQConnString)
+	rabbitMQConn, err := rabbitmq.Dial(cfg.rabbitMQConnString, rabbitmq.WithQueues(customers.Queues))
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 	log.Info().Msg("Coonected to RabbitMQ successfully")
 
-	requestsChannel, err := rabbitmq.OpenWithQueueName(rabbitMQConn, customers.CreateCustomerRequestQueue)
+	requestsChannel, err := rabbitMQConn.Channel(customers.CreateCustomerRequestQueue)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
-	responcesChannel, err := rabbitmq.OpenWithQueueName(rabbitMQConn, customers.CreateCustomerResponseQueue)
+	responcesChannel, err := rabbitMQConn.Channel(customers.CreateCustomerResponseQueue)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
 	customersRepository := &data.CustomerPostgreSQLRepository{DB: db}
-	producer := rabbitmq.NewGenericProducer(responcesChannel)
-	consumer := messaging.NewCustomerCreationConsumer(requestsChannel, customersRepository, producer)
+	producer, err := rabbitmq.NewGenericProducer(responcesChannel)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	consumer := messaging.NewCustomerCreationConsumer(requestsChannel, customersRepository, producer,
+		communication.WithWorkers(cfg.consumer.workers),
+		communication.WithPrefetch(cfg.consumer.prefetch),
+	)
 
 	log.Info().Msg("Mialer service started")
-	err = consumer.StartListening()
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	err = consumer.Start(consumerCtx)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
@@ -88,6 +112,11 @@ func main() {
 	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
 		metrics.WritePrometheus(w, false)
 	})
+	checker := health.NewChecker(health.DefaultTimeout)
+	checker.Add("db", health.DB(db))
+	checker.Add("rabbitmq", health.RabbitMQ(rabbitMQConn, requestsChannel, responcesChannel))
+	checker.Add("customer_creation_consumer", consumer.Check)
+	checker.Register(mux)
 	s := http.Server{
 		Addr:              cfg.addr,
 		Handler:           mux,
@@ -103,6 +132,8 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	stopConsumer()
+	consumer.Wait()
 	if err := rabbitMQConn.Close(); err != nil {
 		log.Error().Err(err).Msg("Cannot close RabbitMQ connection")
 	}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/communication"
+	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const CommandTimeout = time.Minute
+
+const usage = `Inspects and replays dead-lettered messages of the queue.
+
+Usage:
+  dlq [-rabbitmq-conn-string URL] list -queue QUEUE [-limit N]
+  dlq [-rabbitmq-conn-string URL] replay -queue QUEUE [-limit N]
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("dlq", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	url := flags.String("rabbitmq-conn-string", os.Getenv("EXCHANGER_RABBITMQ_CONN_STRING"),
+		"RabbitMQ connection string")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("command is required")
+	}
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	if command != "list" && command != "replay" {
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+	commandFlags := flag.NewFlagSet(command, flag.ContinueOnError)
+	queue := commandFlags.String("queue", "", "Queue, which dead-lettered messages belong to")
+	limit := commandFlags.Int("limit", 0, "Maximum number of messages, all messages if not set")
+	if err := commandFlags.Parse(commandArgs); err != nil {
+		return err
+	}
+	if *queue == "" {
+		return errors.New("queue is required")
+	}
+
+	conn, err := amqp.Dial(*url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	// messages, which are not acknowledged, return to dead-letter queue once channel is closed.
+	defer ch.Close()
+
+	// passive declaration fails for unknown queue instead of creating it.
+	dlq, err := ch.QueueDeclarePassive(rabbitmq.DeadLetterQueue(*queue), false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	count := dlq.Messages
+	if *limit > 0 {
+		count = min(count, *limit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	if command == "list" {
+		return list(ch, dlq.Name, count)
+	}
+	return replay(ctx, ch, *queue, dlq.Name, count)
+}
+
+// list prints messages without acknowledging them, so they stay in dead-letter queue.
+func list(ch *amqp.Channel, dlq string, count int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tATTEMPTS\tERROR\tBODY")
+	for i := 1; i <= count; i++ {
+		delivery, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%v\t%v\t%s\n", i, delivery.Headers[communication.RetryCountHeader],
+			delivery.Headers[communication.ErrorHeader], delivery.Body)
+	}
+	return w.Flush()
+}
+
+// replay publishes messages to the queue with retry count reset and removes them from dead-letter queue
+// once broker confirms them.
+func replay(ctx context.Context, ch *amqp.Channel, queue, dlq string, count int) error {
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+	replayed := 0
+	defer func() {
+		fmt.Printf("Replayed %d messages to %s\n", replayed, queue)
+	}()
+	for range count {
+		delivery, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		headers := delivery.Headers
+		delete(headers, communication.RetryCountHeader)
+		delete(headers, communication.ErrorHeader)
+		delete(headers, "x-death")
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  delivery.ContentType,
+			DeliveryMode: delivery.DeliveryMode,
+			MessageId:    delivery.MessageId,
+			Timestamp:    delivery.Timestamp,
+			Body:         delivery.Body,
+		})
+		if err != nil {
+			return err
+		}
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			return rabbitmq.ErrNotConfirmed
+		}
+		if err := delivery.Ack(false); err != nil {
+			return err
+		}
+		replayed++
+	}
+	return nil
+}
@@ -4,12 +4,16 @@ import (
 	"flag"
 	"os"
 	"time"
+
+	"github.com/fdemchenko/exchanger/internal/money"
 )
 
 type Config struct {
 	SMTP               SMTPConfig
 	RabbitMQConnString string
 	HTTPAddr           string
+	// RatePrecision is a number of digits after decimal point rates are shown with in emails.
+	RatePrecision int
 }
 
 type SMTPConfig struct {
@@ -47,6 +51,11 @@ func LoadConfig() Config {
 		os.Getenv("EXCHANGER_RABBITMQ_CONN_STRING"),
 		"RabbitMQ connection string",
 	)
+	flag.IntVar(&cfg.RatePrecision,
+		"rate-precision",
+		money.DefaultDisplayPrecision,
+		"Number of digits after decimal point rates are shown with",
+	)
 	flag.Parse()
 	return cfg
 }
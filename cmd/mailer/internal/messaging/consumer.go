@@ -1,83 +1,46 @@
 package messaging
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 
 	"github.com/fdemchenko/exchanger/cmd/mailer/internal/services"
 	"github.com/fdemchenko/exchanger/internal/communication"
 	"github.com/fdemchenko/exchanger/internal/communication/mailer"
-	amqp "github.com/rabbitmq/amqp091-go"
-	"github.com/rs/zerolog/log"
+	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
 )
 
-type rateEmailsConsumer struct {
-	channel       *amqp.Channel
+type rateEmailsHandler struct {
 	mailerService *services.MailerService
 }
 
+// NewRateEmailsConsumer handles commands, that send rate, alert and confirmation emails.
+// Message is acknowledged once email is sent, emails, that cannot be sent, are retried.
 func NewRateEmailsConsumer(
-	channel *amqp.Channel,
+	channel *rabbitmq.Channel,
 	mailerService *services.MailerService,
-) *rateEmailsConsumer {
-	return &rateEmailsConsumer{
-		channel:       channel,
-		mailerService: mailerService,
-	}
+	options ...communication.ConsumerOption,
+) *communication.Consumer {
+	handler := &rateEmailsHandler{mailerService: mailerService}
+	consumer := communication.NewConsumer(channel, options...)
+	communication.Handle(consumer, mailer.SendEmailNotification, handler.handleEmailNotification)
+	communication.Handle(consumer, mailer.SendConfirmationEmail, handler.handleConfirmationEmail)
+	return consumer
 }
 
-func (rec *rateEmailsConsumer) handleDelivery(delivery amqp.Delivery) error {
-	message := communication.Message[json.RawMessage]{}
-	err := json.Unmarshal(delivery.Body, &message)
-	if err != nil {
-		return err
-	}
-	switch message.Type {
-	case mailer.ExchangeRateUpdated:
-		updateEvent := mailer.ExchangeRateUpdatedEvent{}
-		err := json.Unmarshal(message.Payload, &updateEvent)
-		if err != nil {
-			return err
-		}
-		err = rec.mailerService.UpdateCurrencyRateTemplates(updateEvent.Rate)
-		if err != nil {
-			return err
-		}
-	case mailer.SendEmailNotification:
-		sendCommand := mailer.SendEmailNotificationCommand{}
-		err := json.Unmarshal(message.Payload, &sendCommand)
-		if err != nil {
-			return err
-		}
-		rec.mailerService.SendEmail(sendCommand.Email)
-	default:
-		return errors.New("unknown message type")
+func (reh *rateEmailsHandler) handleEmailNotification(
+	_ context.Context,
+	msg communication.Message[mailer.SendEmailNotificationCommand],
+) error {
+	command := msg.Payload
+	if command.Alert != nil {
+		return reh.mailerService.SendAlert(command.Email, command.UnsubscribeURL, *command.Alert)
 	}
-	return nil
+	return reh.mailerService.SendEmail(command.Email, command.UnsubscribeURL, command.Rates)
 }
 
-func (rec *rateEmailsConsumer) StartListening() error {
-	var startingError error
-	go func() {
-		deliveries, err := rec.channel.Consume(
-			mailer.RateEmailsQueue,
-			"",
-			false, false, false, false, nil,
-		)
-		if err != nil {
-			startingError = err
-			return
-		}
-
-		for delivery := range deliveries {
-			if err := rec.handleDelivery(delivery); err != nil {
-				log.Error().Err(err).Send()
-			}
-			if err := delivery.Ack(false); err != nil {
-				log.Error().Err(err).Send()
-			}
-		}
-	}()
-
-	return startingError
+func (reh *rateEmailsHandler) handleConfirmationEmail(
+	_ context.Context,
+	msg communication.Message[mailer.SendConfirmationEmailCommand],
+) error {
+	return reh.mailerService.SendConfirmation(msg.Payload)
 }
@@ -2,78 +2,204 @@ package services
 
 import (
 	"bytes"
-	"math"
+	"strings"
 	"text/template"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/fdemchenko/exchanger/cmd/mailer/internal/config"
+	"github.com/fdemchenko/exchanger/internal/communication/mailer"
+	"github.com/fdemchenko/exchanger/internal/money"
 	"github.com/fdemchenko/exchanger/web/templates"
 	"github.com/go-mail/mail/v2"
-	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 )
 
+const ConfirmationExpiryLayout = "2006-01-02 15:04 MST"
+
 type MailerService struct {
-	dialer            *mail.Dialer
-	sender            string
-	currencyTemplates map[string]string
-	parsedTemplate    *template.Template
-	jobsChan          chan *mail.Message
-	errorsChan        chan error
+	dialer          *mail.Dialer
+	sender          string
+	parsedTemplate  *template.Template
+	alertTemplate   *template.Template
+	confirmTemplate *template.Template
+	jobsChan        chan emailJob
+	ratePrecision   int32
+}
+
+type rateTemplateData struct {
+	Rates          []pairRateTemplateData
+	UnsubscribeURL string
+}
+
+// pairRateTemplateData holds rates formatted for display, empty price means it is unknown.
+type pairRateTemplateData struct {
+	Base  string
+	Quote string
+	Rate  string
+	Buy   string
+	Sell  string
 }
 
 func NewMailerService(
 	cfg config.SMTPConfig,
+	ratePrecision int32,
 ) *MailerService {
 	dialer := mail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)
 
-	cfg.ConnectionPoolSize = int(math.Min(MaxConcurrentSMTPConn, float64(cfg.ConnectionPoolSize)))
+	return &MailerService{
+		dialer:          dialer,
+		sender:          cfg.Sender,
+		parsedTemplate:  template.Must(template.New("email").Parse(templates.MessageTemplate)),
+		alertTemplate:   template.Must(template.New("alert").Parse(templates.AlertTemplate)),
+		confirmTemplate: template.Must(template.New("confirmation").Parse(templates.ConfirmationTemplate)),
+		jobsChan:        make(chan emailJob),
+		ratePrecision:   ratePrecision,
+	}
+}
 
-	errorsChan := make(chan error)
+func (ms *MailerService) newPairRateTemplateData(pairRate mailer.PairRate) pairRateTemplateData {
+	base, quote, _ := strings.Cut(pairRate.Pair, "-")
+	return pairRateTemplateData{
+		Base:  base,
+		Quote: quote,
+		Rate:  money.Format(pairRate.Rate, ms.ratePrecision),
+		Buy:   ms.formatPrice(pairRate.Buy),
+		Sell:  ms.formatPrice(pairRate.Sell),
+	}
+}
 
-	go func() {
-		for err := range errorsChan {
-			log.Error().Err(err).Send()
-		}
-	}()
+func (ms *MailerService) formatPrice(price decimal.Decimal) string {
+	if price.IsZero() {
+		return ""
+	}
+	return money.Format(price, ms.ratePrecision)
+}
 
-	return &MailerService{
-		dialer:            dialer,
-		sender:            cfg.Sender,
-		currencyTemplates: make(map[string]string),
-		parsedTemplate:    template.Must(template.New("email").Parse(templates.MessageTemplate)),
-		jobsChan:          make(chan *mail.Message),
-		errorsChan:        errorsChan,
+// StartWorkers starts workers, which send emails through their own SMTP connections,
+// and returns their number, that is limited by MaxConcurrentSMTPConn.
+func (ms *MailerService) StartWorkers(connectionPoolSize int) int {
+	workers := min(connectionPoolSize, MaxConcurrentSMTPConn)
+	for i := 0; i < workers; i++ {
+		go emailWorker(ms.jobsChan, ms.dialer)
 	}
+	return workers
 }
 
-func (ms *MailerService) UpdateCurrencyRateTemplates(rate float32) error {
-	templateNames := []string{"subject", "plainBody", "htmlBody"}
-	templateBuffer := new(bytes.Buffer)
-	for _, templatesName := range templateNames {
-		err := ms.parsedTemplate.ExecuteTemplate(templateBuffer, templatesName, rate)
-		if err != nil {
-			return err
-		}
-		ms.currencyTemplates[templatesName] = templateBuffer.String()
-		templateBuffer.Reset()
+// send hands email to a worker and waits until it is sent, so failed emails are retried by consumer.
+func (ms *MailerService) send(message *mail.Message) error {
+	result := make(chan error, 1)
+	ms.jobsChan <- emailJob{message: message, result: result}
+	return <-result
+}
+
+// SendAlert sends email about matched alert rule to the subscriber, who set it.
+func (ms *MailerService) SendAlert(to, unsubscribeURL string, alert mailer.RateAlert) error {
+	threshold := ms.formatThreshold(alert.AlertRule)
+	data := struct {
+		Pair, Type, Kind, Threshold, Rate, PreviousRate, DeleteURL, UnsubscribeURL string
+	}{
+		Pair:           alert.Pair,
+		Type:           alert.Type,
+		Kind:           alert.Kind,
+		Threshold:      threshold,
+		Rate:           money.Format(alert.Rate, ms.ratePrecision),
+		PreviousRate:   money.Format(alert.PreviousRate, ms.ratePrecision),
+		DeleteURL:      alert.DeleteURL,
+		UnsubscribeURL: unsubscribeURL,
+	}
+
+	parts, err := renderTemplates(ms.alertTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	if err := ms.send(ms.newMessage(to, parts, unsubscribeURL)); err != nil {
+		return err
 	}
+	metrics.GetOrCreateCounter("total_alerts_send").Inc()
 	return nil
 }
 
-func (ms *MailerService) StartWorkers(connectionPoolSize int) {
-	for i := 0; i < connectionPoolSize; i++ {
-		go emailWorker(ms.jobsChan, ms.errorsChan, ms.dialer)
+// SendConfirmation sends link, that confirms subscription or alert rule, to the subscriber.
+func (ms *MailerService) SendConfirmation(command mailer.SendConfirmationEmailCommand) error {
+	data := struct {
+		ConfirmationURL string
+		ExpiresAt       string
+		Alert           *mailer.AlertRule
+		Threshold       string
+	}{
+		ConfirmationURL: command.ConfirmationURL,
+		ExpiresAt:       command.ExpiresAt.UTC().Format(ConfirmationExpiryLayout),
+		Alert:           command.Alert,
 	}
+	if command.Alert != nil {
+		data.Threshold = ms.formatThreshold(*command.Alert)
+	}
+	parts, err := renderTemplates(ms.confirmTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	if err := ms.send(ms.newMessage(command.Email, parts, "")); err != nil {
+		return err
+	}
+	metrics.GetOrCreateCounter("total_confirmations_send").Inc()
+	return nil
 }
 
-func (ms *MailerService) SendEmail(to string) {
-	metrics.GetOrCreateCounter("total_emails_send").Inc()
+// formatThreshold formats crossing threshold as rate, change threshold is percents.
+func (ms *MailerService) formatThreshold(rule mailer.AlertRule) string {
+	if rule.Kind == mailer.AlertKindCrossing {
+		return money.Format(rule.Threshold, ms.ratePrecision)
+	}
+	return rule.Threshold.String()
+}
+
+// renderTemplates executes subject, plain and html body templates.
+func renderTemplates(tmpl *template.Template, data any) (map[string]string, error) {
+	parts := make(map[string]string)
+	templateBuffer := new(bytes.Buffer)
+	for _, templatesName := range []string{"subject", "plainBody", "htmlBody"} {
+		err := tmpl.ExecuteTemplate(templateBuffer, templatesName, data)
+		if err != nil {
+			return nil, err
+		}
+		parts[templatesName] = templateBuffer.String()
+		templateBuffer.Reset()
+	}
+	return parts, nil
+}
+
+// newMessage builds email from rendered templates, messages with unsubscribe link
+// support one-click unsubscription as described in RFC 8058.
+func (ms *MailerService) newMessage(to string, parts map[string]string, unsubscribeURL string) *mail.Message {
 	message := mail.NewMessage()
 	message.SetHeader("From", ms.sender)
 	message.SetHeader("To", to)
-	message.SetHeader("Subject", ms.currencyTemplates["subject"])
-	message.SetBody("text/plain", ms.currencyTemplates["plainBody"])
-	message.AddAlternative("text/html", ms.currencyTemplates["htmlBody"])
+	if unsubscribeURL != "" {
+		message.SetHeader("List-Unsubscribe", "<"+unsubscribeURL+">")
+		message.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	message.SetHeader("Subject", parts["subject"])
+	message.SetBody("text/plain", parts["plainBody"])
+	message.AddAlternative("text/html", parts["htmlBody"])
+	return message
+}
 
-	ms.jobsChan <- message
+// SendEmail sends rates of pairs the subscriber chose.
+func (ms *MailerService) SendEmail(to, unsubscribeURL string, rates []mailer.PairRate) error {
+	data := rateTemplateData{UnsubscribeURL: unsubscribeURL}
+	for _, pairRate := range rates {
+		data.Rates = append(data.Rates, ms.newPairRateTemplateData(pairRate))
+	}
+	parts, err := renderTemplates(ms.parsedTemplate, data)
+	if err != nil {
+		return err
+	}
+
+	if err := ms.send(ms.newMessage(to, parts, unsubscribeURL)); err != nil {
+		return err
+	}
+	metrics.GetOrCreateCounter("total_emails_send").Inc()
+	return nil
 }
@@ -1,9 +1,14 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"net/textproto"
 	"time"
 
+	"github.com/fdemchenko/exchanger/internal/communication"
 	"github.com/go-mail/mail/v2"
+	"github.com/rs/zerolog/log"
 )
 
 const (
@@ -11,32 +16,57 @@ const (
 	UnusedConnectionTime  = 30 * time.Second
 )
 
-func emailWorker(jobs chan *mail.Message, errors chan error, dialer *mail.Dialer) {
+// emailJob is a message for worker to send, result of sending is reported back.
+type emailJob struct {
+	message *mail.Message
+	result  chan<- error
+}
+
+func emailWorker(jobs chan emailJob, dialer *mail.Dialer) {
 	var sender mail.SendCloser
 	var err error
 	open := false
 
 	for {
 		select {
-		case m := <-jobs:
+		case job := <-jobs:
 			if !open {
 				if sender, err = dialer.Dial(); err != nil {
-					errors <- err
+					job.result <- err
+					continue
 				}
 				open = true
 			}
-			if err := mail.Send(sender, m); err != nil {
-				errors <- err
+			err := mail.Send(sender, job.message)
+			if err != nil {
+				// connection may be broken, so the next email is sent through a new one.
+				closeSender(sender)
+				open = false
 			}
+			job.result <- permanentSMTPError(err)
 		// Close the connection to the SMTP server if no email was sent in
 		// the last 30 seconds.
 		case <-time.After(UnusedConnectionTime):
 			if open {
-				if err := sender.Close(); err != nil {
-					errors <- err
-				}
+				closeSender(sender)
 				open = false
 			}
 		}
 	}
 }
+
+func closeSender(sender mail.SendCloser) {
+	if err := sender.Close(); err != nil {
+		log.Error().Err(err).Msg("Cannot close SMTP connection")
+	}
+}
+
+// permanentSMTPError marks errors with 5xx reply code as permanent, as server rejects the email
+// every time it is sent, transient 4xx errors are retried.
+func permanentSMTPError(err error) error {
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) && smtpErr.Code >= 500 {
+		return fmt.Errorf("%w: %w", communication.ErrPermanent, err)
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,40 +15,43 @@ import (
 	"github.com/fdemchenko/exchanger/internal/communication"
 	"github.com/fdemchenko/exchanger/internal/communication/mailer"
 	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/fdemchenko/exchanger/internal/health"
 	"github.com/robfig/cron"
 	"github.com/rs/zerolog/log"
 )
 
 const (
 	DefaultMailerConnectionPoolSize = 3
-	EveryDayAt10AMCRON              = "0 0 10 * * *"
+	EveryHourCRON                   = "0 0 * * * *"
 	ReadHeaderTimeout               = 5 * time.Second
 )
 
 func main() {
 	cfg := config.LoadConfig()
-	rabbitMQConn, err := amqp.Dial(cfg.RabbitMQConnString)
+	rabbitMQConn, err := rabbitmq.Dial(cfg.RabbitMQConnString, rabbitmq.WithQueues(mailer.Queues))
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 	log.Info().Msg("Coonected to RabbitMQ successfully")
 
-	rateEmailsChannel, err := rabbitmq.OpenWithQueueName(rabbitMQConn, mailer.RateEmailsQueue)
+	rateEmailsChannel, err := rabbitMQConn.Channel(mailer.RateEmailsQueue)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
-	emailsTriggersChannel, err := rabbitmq.OpenWithQueueName(rabbitMQConn, mailer.TriggerEmailsSendingQueue)
+	emailsTriggersChannel, err := rabbitMQConn.Channel(mailer.TriggerEmailsSendingQueue)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
-	mailerService := services.NewMailerService(cfg.SMTP)
-	mailerService.StartWorkers(cfg.SMTP.ConnectionPoolSize)
+	mailerService := services.NewMailerService(cfg.SMTP, int32(cfg.RatePrecision))
+	workers := mailerService.StartWorkers(cfg.SMTP.ConnectionPoolSize)
 
-	producer := rabbitmq.NewGenericProducer(emailsTriggersChannel)
+	producer, err := rabbitmq.NewGenericProducer(emailsTriggersChannel)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
 	c := cron.New()
-	err = c.AddFunc(EveryDayAt10AMCRON, func() {
+	err = c.AddFunc(EveryHourCRON, func() {
 		msg := communication.Message[struct{}]{
 			MessageHeader: communication.MessageHeader{Type: mailer.StartEmailSending, Timestamp: time.Now()},
 		}
@@ -61,8 +65,10 @@ func main() {
 	}
 	c.Start()
 
-	consumer := messaging.NewRateEmailsConsumer(rateEmailsChannel, mailerService)
-	err = consumer.StartListening()
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	// emails are sent synchronously, so every SMTP connection gets its own consumer worker.
+	consumer := messaging.NewRateEmailsConsumer(rateEmailsChannel, mailerService, communication.WithWorkers(workers))
+	err = consumer.Start(consumerCtx)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
@@ -70,6 +76,10 @@ func main() {
 	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
 		metrics.WritePrometheus(w, false)
 	})
+	checker := health.NewChecker(health.DefaultTimeout)
+	checker.Add("rabbitmq", health.RabbitMQ(rabbitMQConn, rateEmailsChannel, emailsTriggersChannel))
+	checker.Add("rate_emails_consumer", consumer.Check)
+	checker.Register(mux)
 	s := http.Server{
 		Addr:              cfg.HTTPAddr,
 		Handler:           mux,
@@ -88,6 +98,8 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	stopConsumer()
+	consumer.Wait()
 	if err := rabbitMQConn.Close(); err != nil {
 		log.Error().Err(err).Msg("Cannot close RabbitMQ connection")
 	}
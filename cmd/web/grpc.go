@@ -0,0 +1,393 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/VictoriaMetrics/metrics"
+	exchangerv1 "github.com/fdemchenko/exchanger/api/exchanger/v1"
+	"github.com/fdemchenko/exchanger/internal/money"
+	"github.com/fdemchenko/exchanger/internal/ratelimit"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/validator"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var rateTypes = map[exchangerv1.RateType]rate.RateType{
+	exchangerv1.RateType_RATE_TYPE_UNSPECIFIED: rate.RateTypeOfficial,
+	exchangerv1.RateType_RATE_TYPE_OFFICIAL:    rate.RateTypeOfficial,
+	exchangerv1.RateType_RATE_TYPE_BUY:         rate.RateTypeBuy,
+	exchangerv1.RateType_RATE_TYPE_SELL:        rate.RateTypeSell,
+	exchangerv1.RateType_RATE_TYPE_MID:         rate.RateTypeMid,
+}
+
+// exchangerServer serves gRPC API using the same services as HTTP handlers.
+type exchangerServer struct {
+	exchangerv1.UnimplementedExchangerServiceServer
+	app *application
+}
+
+func (app *application) newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(app.unaryInterceptor, app.unaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(app.streamInterceptor, app.streamAuthInterceptor),
+	)
+	exchangerv1.RegisterExchangerServiceServer(server, &exchangerServer{app: app})
+	reflection.Register(server)
+	return server
+}
+
+// unaryInterceptor counts calls and turns panics into internal errors, as middlewares of HTTP server do.
+func (app *application) unaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (resp any, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = app.grpcServerError(fmt.Errorf("%v", recovered))
+		}
+		countGRPCRequest(info.FullMethod, err)
+	}()
+	return handler(ctx, req)
+}
+
+func (app *application) streamInterceptor(
+	srv any,
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = app.grpcServerError(fmt.Errorf("%v", recovered))
+		}
+		countGRPCRequest(info.FullMethod, err)
+	}()
+	return handler(srv, stream)
+}
+
+// unaryAuthInterceptor applies the same API keys and rate limits as HTTP middlewares do.
+func (app *application) unaryAuthInterceptor(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if err := app.authorizeGRPC(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (app *application) streamAuthInterceptor(
+	srv any,
+	stream grpc.ServerStream,
+	_ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := app.authorizeGRPC(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// authorizeGRPC charges call against quota of API key from x-api-key metadata, calls without key
+// are charged against quota of peer IP, as well as calls with invalid key.
+func (app *application) authorizeGRPC(ctx context.Context) error {
+	var apiKey *repositories.APIKey
+	ip := peerIP(ctx)
+	keys := metadata.ValueFromIncomingContext(ctx, strings.ToLower(APIKeyHeader))
+	if app.apiKeys != nil && len(keys) > 0 && keys[0] != "" {
+		authenticated, err := app.apiKeys.Authenticate(ctx, keys[0])
+		if err != nil {
+			if !errors.Is(err, services.ErrInvalidAPIKey) {
+				return app.grpcServerError(err)
+			}
+			if app.limiter != nil {
+				if result := app.allow(nil, ip); !result.Allowed {
+					return grpcRateLimited(result)
+				}
+			}
+			return status.Error(codes.Unauthenticated, "API key is invalid or revoked")
+		}
+		apiKey = &authenticated
+	}
+
+	if app.limiter != nil {
+		if result := app.allow(apiKey, ip); !result.Allowed {
+			return grpcRateLimited(result)
+		}
+	}
+	return nil
+}
+
+func grpcRateLimited(result ratelimit.Result) error {
+	return status.Errorf(codes.ResourceExhausted, "too many requests, try again in %d seconds",
+		ceilSeconds(result.RetryAfter))
+}
+
+// peerIP is taken from connection, as metadata can be set by clients to avoid limits.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func countGRPCRequest(method string, err error) {
+	s := fmt.Sprintf(`grpc_requests_total{method=%q, code=%q}`, method, status.Code(err).String())
+	metrics.GetOrCreateCounter(s).Inc()
+}
+
+func (es *exchangerServer) GetRate(ctx context.Context, req *exchangerv1.GetRateRequest) (*exchangerv1.Rate, error) {
+	base := cmp.Or(req.GetBase(), rate.DefaultBaseCurrency)
+	quote := cmp.Or(req.GetQuote(), rate.DefaultQuoteCurrency)
+	rateType, ok := rateTypes[req.GetType()]
+
+	v := validator.New()
+	v.Check(validator.IsValidCurrencyCode(base), "base", "invalid currency code")
+	v.Check(validator.IsValidCurrencyCode(quote), "quote", "invalid currency code")
+	v.Check(ok, "type", "must be one of buy, sell, official, mid")
+	if !v.IsValid() {
+		return nil, invalidArgument(v)
+	}
+
+	currentRate, err := es.app.rateService.GetRate(ctx, rate.NewCurrencyPair(base, quote), rateType)
+	if err != nil {
+		return nil, es.app.grpcRateError(err)
+	}
+	return es.app.newRateMessage(currentRate), nil
+}
+
+func (es *exchangerServer) Convert(
+	ctx context.Context,
+	req *exchangerv1.ConvertRequest,
+) (*exchangerv1.Conversion, error) {
+	v := validator.New()
+	pair, amount, at := parseConversion(req.GetFrom(), req.GetTo(), req.GetAmount(), req.GetDate(), v)
+	if !v.IsValid() {
+		return nil, invalidArgument(v)
+	}
+
+	conversion, err := es.app.converter.Convert(ctx, pair, amount, at)
+	if err != nil {
+		return nil, es.app.grpcRateError(err)
+	}
+
+	legs := make([]*exchangerv1.ConversionLeg, 0, len(conversion.Legs))
+	for _, leg := range conversion.Legs {
+		legs = append(legs, &exchangerv1.ConversionLeg{
+			Pair:      leg.Rate.Pair.String(),
+			Rate:      es.app.formatRate(leg.Rate.Value).String(),
+			Inverted:  leg.Inverted,
+			Providers: leg.Rate.Providers,
+			Timestamp: timestamppb.New(leg.Rate.FetchedAt),
+			Stale:     leg.Rate.Stale,
+		})
+	}
+	response := &exchangerv1.Conversion{
+		From:   conversion.Pair.Base,
+		To:     conversion.Pair.Quote,
+		Amount: conversion.Amount.String(),
+		Result: money.JSONNumber(conversion.Result, money.AmountPrecision).String(),
+		Rate:   es.app.formatRate(conversion.Rate).String(),
+		Via:    conversion.Via,
+		Legs:   legs,
+	}
+	if !at.IsZero() {
+		response.Date = req.GetDate()
+	}
+	return response, nil
+}
+
+func (es *exchangerServer) Subscribe(
+	_ context.Context,
+	req *exchangerv1.SubscribeRequest,
+) (*exchangerv1.SubscribeResponse, error) {
+	v := validator.New()
+	v.Check(validator.IsValidEmail(req.GetEmail()), "email", "invalid email")
+	form := url.Values{
+		"pairs":     req.GetPairs(),
+		"frequency": {req.GetFrequency()},
+		"time":      {req.GetTime()},
+		"timezone":  {req.GetTimezone()},
+	}
+	preferences := es.app.parsePreferences(form, v)
+	if !v.IsValid() {
+		return nil, invalidArgument(v)
+	}
+
+	err := es.app.createSubscription(req.GetEmail(), preferences)
+	if err != nil {
+		if errors.Is(err, repositories.ErrDuplicateEmail) {
+			return nil, status.Error(codes.AlreadyExists, "email is already subscribed")
+		}
+		if errors.Is(err, repositories.ErrRecentlyRequested) {
+			return nil, status.Error(codes.ResourceExhausted, "confirmation link is sent recently, try again later")
+		}
+		return nil, es.app.grpcServerError(err)
+	}
+	return &exchangerv1.SubscribeResponse{}, nil
+}
+
+func (es *exchangerServer) Unsubscribe(
+	_ context.Context,
+	req *exchangerv1.UnsubscribeRequest,
+) (*exchangerv1.UnsubscribeResponse, error) {
+	email, err := es.app.unsubscribeLinks.Email(req.GetToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid unsubscribe token")
+	}
+	if err := es.app.deleteSubscription(email); err != nil {
+		return nil, es.app.grpcServerError(err)
+	}
+	return &exchangerv1.UnsubscribeResponse{Email: email}, nil
+}
+
+// WatchRates sends rate changes until client cancels the call. Stream, that cannot keep up with changes,
+// ends with UNAVAILABLE status and is expected to be resumed from the last received event.
+func (es *exchangerServer) WatchRates(
+	req *exchangerv1.WatchRatesRequest,
+	stream exchangerv1.ExchangerService_WatchRatesServer,
+) error {
+	v := validator.New()
+	pairs := parsePairs(req.GetPairs(), v, MaxStreamPairs)
+	var types []rate.RateType
+	for _, protoType := range req.GetTypes() {
+		rateType, ok := rateTypes[protoType]
+		v.Check(ok, "types", "must be one of buy, sell, official, mid")
+		if !slices.Contains(types, rateType) {
+			types = append(types, rateType)
+		}
+	}
+	if !v.IsValid() {
+		return invalidArgument(v)
+	}
+
+	filter := services.RateFilter{Pairs: pairs, Types: types}
+	subscription, missed := es.app.rateStream.Subscribe(filter, req.GetLastEventId())
+	defer es.app.rateStream.Unsubscribe(subscription)
+	es.app.requestStreamedRates(filter, missed)
+
+	metrics.GetOrCreateGauge("rate_streams_active", nil).Inc()
+	defer metrics.GetOrCreateGauge("rate_streams_active", nil).Dec()
+
+	for _, event := range missed {
+		if err := stream.Send(es.app.newRateEventMessage(event)); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return status.Error(codes.Unavailable, "rate stream is closed, resume it from the last received event")
+			}
+			if err := stream.Send(es.app.newRateEventMessage(event)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (app *application) newRateMessage(r rate.Rate) *exchangerv1.Rate {
+	prices := &exchangerv1.Prices{Timestamp: timestamppb.New(r.Quote.Timestamp)}
+	if buy, ok := r.Quote.Value(rate.RateTypeBuy); ok {
+		prices.Buy = app.formatRate(buy).String()
+	}
+	if sell, ok := r.Quote.Value(rate.RateTypeSell); ok {
+		prices.Sell = app.formatRate(sell).String()
+	}
+	if official, ok := r.Quote.Value(rate.RateTypeOfficial); ok {
+		prices.Official = app.formatRate(official).String()
+	}
+	if mid, ok := r.Quote.Value(rate.RateTypeMid); ok {
+		prices.Mid = app.formatRate(mid).String()
+	}
+	return &exchangerv1.Rate{
+		Base:      r.Pair.Base,
+		Quote:     r.Pair.Quote,
+		Type:      toProtoRateType(r.Type),
+		Rate:      app.formatRate(r.Value).String(),
+		Prices:    prices,
+		Providers: r.Providers,
+		Stale:     r.Stale,
+		FetchedAt: timestamppb.New(r.FetchedAt),
+	}
+}
+
+func (app *application) newRateEventMessage(event services.RateEvent) *exchangerv1.RateEvent {
+	return &exchangerv1.RateEvent{Id: event.ID, Rate: app.newRateMessage(event.Rate)}
+}
+
+func toProtoRateType(rateType rate.RateType) exchangerv1.RateType {
+	for protoType, value := range rateTypes {
+		if value == rateType && protoType != exchangerv1.RateType_RATE_TYPE_UNSPECIFIED {
+			return protoType
+		}
+	}
+	return exchangerv1.RateType_RATE_TYPE_UNSPECIFIED
+}
+
+// invalidArgument lists invalid fields in details of the status, as HTTP API does in error body.
+func invalidArgument(v *validator.Validator) error {
+	badRequest := &errdetails.BadRequest{}
+	for field, description := range v.Errors {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: description,
+		})
+	}
+	slices.SortFunc(badRequest.FieldViolations, func(a, b *errdetails.BadRequest_FieldViolation) int {
+		return cmp.Compare(a.Field, b.Field)
+	})
+	st, err := status.New(codes.InvalidArgument, "request contains invalid fields").WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "request contains invalid fields")
+	}
+	return st.Err()
+}
+
+func (app *application) grpcRateError(err error) error {
+	switch {
+	case errors.Is(err, rate.ErrUnsupportedPair) || errors.Is(err, rate.ErrInvalidCurrencyCode):
+		return status.Error(codes.InvalidArgument, "currency pair is not supported")
+	case errors.Is(err, rate.ErrRateNotFound):
+		return status.Error(codes.NotFound, "no rate was recorded for the date")
+	case errors.Is(err, rate.ErrProviderUnavailable):
+		log.Error().Err(err).Send()
+		return status.Error(codes.Unavailable, "rate providers are unavailable, try again later")
+	default:
+		return app.grpcServerError(err)
+	}
+}
+
+func (app *application) grpcServerError(err error) error {
+	log.Error().Err(err).Send()
+	return status.Error(codes.Internal, "internal server error")
+}
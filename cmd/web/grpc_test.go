@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+	"time"
+
+	exchangerv1 "github.com/fdemchenko/exchanger/api/exchanger/v1"
+	"github.com/fdemchenko/exchanger/internal/ratelimit"
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/tokens"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves gRPC API of the app in memory and returns connection to it.
+func dialGRPC(t *testing.T, app *application) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := app.newGRPCServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPC_GetRate(t *testing.T) {
+	app := &application{rateService: &RateServiceStub{listener: func(rate.Rate) {}}}
+	client := exchangerv1.NewExchangerServiceClient(dialGRPC(t, app))
+
+	response, err := client.GetRate(context.Background(), &exchangerv1.GetRateRequest{
+		Type: exchangerv1.RateType_RATE_TYPE_SELL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "USD", response.GetBase())
+	assert.Equal(t, "UAH", response.GetQuote())
+	assert.Equal(t, exchangerv1.RateType_RATE_TYPE_SELL, response.GetType())
+	assert.Equal(t, "41", response.GetRate())
+
+	_, err = client.GetRate(context.Background(), &exchangerv1.GetRateRequest{Base: "US", Type: 42})
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	if assert.Len(t, st.Details(), 1) {
+		var fields []string
+		for _, violation := range st.Details()[0].(*errdetails.BadRequest).GetFieldViolations() {
+			fields = append(fields, violation.GetField())
+		}
+		assert.Equal(t, []string{"base", "type"}, fields)
+	}
+}
+
+func TestGRPC_RateLimit(t *testing.T) {
+	app := &application{
+		rateService: &RateServiceStub{listener: func(rate.Rate) {}},
+		apiKeys:     APIKeysStub{"limited": {ID: 1, RateLimit: sql.NullInt32{Int32: 1, Valid: true}}},
+		limiter:     ratelimit.New(),
+	}
+	app.cfg.rateLimit.anonymous = 2
+	client := exchangerv1.NewExchangerServiceClient(dialGRPC(t, app))
+	getRate := func(key string) codes.Code {
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+		}
+		_, err := client.GetRate(ctx, &exchangerv1.GetRateRequest{})
+		return status.Code(err)
+	}
+
+	// key from metadata uses quota of the key.
+	assert.Equal(t, codes.OK, getRate("limited"))
+	assert.Equal(t, codes.ResourceExhausted, getRate("limited"))
+
+	// failed authentication is charged against quota of peer IP, as well as anonymous calls.
+	assert.Equal(t, codes.Unauthenticated, getRate("unknown"))
+	assert.Equal(t, codes.OK, getRate(""))
+	assert.Equal(t, codes.ResourceExhausted, getRate("unknown"))
+	assert.Equal(t, codes.ResourceExhausted, getRate(""))
+}
+
+func TestGRPC_Unsubscribe_InvalidToken(t *testing.T) {
+	app := &application{unsubscribeLinks: services.NewUnsubscribeLinks(tokens.NewSigner([]byte("secret")), APIPrefix)}
+	client := exchangerv1.NewExchangerServiceClient(dialGRPC(t, app))
+
+	_, err := client.Unsubscribe(context.Background(), &exchangerv1.UnsubscribeRequest{Token: "forged"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPC_WatchRates(t *testing.T) {
+	broadcaster := services.NewRateBroadcaster(services.DefaultBroadcastHistorySize)
+	app := &application{
+		rateService:   &RateServiceStub{listener: broadcaster.OnRateFetched},
+		rateStream:    broadcaster,
+		streamFetches: make(chan struct{}, MaxStreamFetches),
+	}
+	client := exchangerv1.NewExchangerServiceClient(dialGRPC(t, app))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.WatchRates(ctx, &exchangerv1.WatchRatesRequest{Pairs: []string{"usd-uah"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown rate of the filtered pair is requested once stream starts.
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "41", first.GetRate().GetRate())
+
+	usd, eur := rate.NewCurrencyPair("USD", "UAH"), rate.NewCurrencyPair("EUR", "UAH")
+	broadcaster.OnRateFetched(rate.Rate{Pair: eur, Type: rate.RateTypeOfficial, Value: decimal.NewFromInt(44)})
+	broadcaster.OnRateFetched(rate.Rate{Pair: usd, Type: rate.RateTypeOfficial, Value: decimal.NewFromInt(42)})
+	second, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "42", second.GetRate().GetRate())
+	assert.Greater(t, second.GetId(), first.GetId())
+
+	broadcaster.Close()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGRPC_Reflection(t *testing.T) {
+	client := reflectionpb.NewServerReflectionClient(dialGRPC(t, &application{}))
+
+	stream, err := client.ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, service := range response.GetListServicesResponse().GetService() {
+		names = append(names, service.GetName())
+	}
+	assert.Contains(t, names, "exchanger.v1.ExchangerService")
+}
@@ -1,14 +1,231 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/fdemchenko/exchanger/internal/money"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/validator"
+	"github.com/fdemchenko/exchanger/web/templates"
 	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 )
 
 type envelope map[string]interface{}
 
+var (
+	unsubscribePage = template.Must(template.New("unsubscribe").Parse(templates.UnsubscribePage))
+	deleteAlertPage = template.Must(template.New("delete_alert").Parse(templates.DeleteAlertPage))
+)
+
+type quoteResponse struct {
+	Buy       json.Number `json:"buy,omitempty"`
+	Sell      json.Number `json:"sell,omitempty"`
+	Official  json.Number `json:"official,omitempty"`
+	Mid       json.Number `json:"mid,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// newQuoteResponse omits prices, that provider does not publish.
+func (app *application) newQuoteResponse(quote rate.Quote) quoteResponse {
+	response := quoteResponse{Timestamp: quote.Timestamp}
+	if buy, ok := quote.Value(rate.RateTypeBuy); ok {
+		response.Buy = app.formatRate(buy)
+	}
+	if sell, ok := quote.Value(rate.RateTypeSell); ok {
+		response.Sell = app.formatRate(sell)
+	}
+	if official, ok := quote.Value(rate.RateTypeOfficial); ok {
+		response.Official = app.formatRate(official)
+	}
+	if mid, ok := quote.Value(rate.RateTypeMid); ok {
+		response.Mid = app.formatRate(mid)
+	}
+	return response
+}
+
+func (app *application) newRateResponse(r rate.Rate) envelope {
+	return envelope{
+		"base":      r.Pair.Base,
+		"quote":     r.Pair.Quote,
+		"type":      r.Type,
+		"rate":      app.formatRate(r.Value),
+		"prices":    app.newQuoteResponse(r.Quote),
+		"providers": r.Providers,
+		"stale":     r.Stale,
+		"age":       int(r.Age().Seconds()),
+	}
+}
+
+// formatRateEvent formats rate event as Server-Sent Event, data is the same as response of GET /rate.
+func (app *application) formatRateEvent(event services.RateEvent) (string, error) {
+	data, err := json.Marshal(app.newRateResponse(event.Rate))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("id: %d\nevent: rate\ndata: %s\n\n", event.ID, data), nil
+}
+
+// parseLastEventID reads ID of the last received event, browsers send it in header when they reconnect,
+// query parameter is used to resume stream manually.
+func parseLastEventID(r *http.Request) (uint64, error) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	if lastEventID == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(lastEventID, 10, 64)
+}
+
+type ratePointResponse struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Average   json.Number `json:"average"`
+	Min       json.Number `json:"min"`
+	Max       json.Number `json:"max"`
+	Samples   int         `json:"samples"`
+}
+
+func (app *application) newRatePointsResponse(points []repositories.RatePoint) []ratePointResponse {
+	response := make([]ratePointResponse, 0, len(points))
+	for _, point := range points {
+		response = append(response, ratePointResponse{
+			Timestamp: point.Timestamp,
+			Average:   app.formatRate(point.Average),
+			Min:       app.formatRate(point.Min),
+			Max:       app.formatRate(point.Max),
+			Samples:   point.Samples,
+		})
+	}
+	return response
+}
+
+type conversionLegResponse struct {
+	Pair      string      `json:"pair"`
+	Rate      json.Number `json:"rate"`
+	Inverted  bool        `json:"inverted"`
+	Providers []string    `json:"providers"`
+	Timestamp time.Time   `json:"timestamp"`
+	Stale     bool        `json:"stale"`
+}
+
+func (app *application) newConversionLegsResponse(legs []rate.ConversionLeg) []conversionLegResponse {
+	response := make([]conversionLegResponse, 0, len(legs))
+	for _, leg := range legs {
+		response = append(response, conversionLegResponse{
+			Pair:      leg.Rate.Pair.String(),
+			Rate:      app.formatRate(leg.Rate.Value),
+			Inverted:  leg.Inverted,
+			Providers: leg.Rate.Providers,
+			Timestamp: leg.Rate.FetchedAt,
+			Stale:     leg.Rate.Stale,
+		})
+	}
+	return response
+}
+
+// formatRate rounds rate to configured display precision, keeping it a JSON number.
+func (app *application) formatRate(value decimal.Decimal) json.Number {
+	return money.JSONNumber(value, int32(app.cfg.ratePrecision))
+}
+
+// parseRateType returns official rate type if it is not specified.
+func parseRateType(s string) (rate.RateType, error) {
+	if s == "" {
+		return rate.RateTypeOfficial, nil
+	}
+	return rate.ParseRateType(s)
+}
+
+// parsePairs parses distinct pairs passed as repeated values or separated by comma, errors are added to pairs key.
+func parsePairs(values []string, v *validator.Validator, maxPairs int) []rate.CurrencyPair {
+	var pairs []rate.CurrencyPair
+	for _, value := range values {
+		for _, pairParam := range strings.Split(value, ",") {
+			pair, err := rate.ParseCurrencyPair(strings.TrimSpace(pairParam))
+			v.Check(err == nil && validator.IsValidCurrencyCode(pair.Base) && validator.IsValidCurrencyCode(pair.Quote),
+				"pairs", "must be in BASE-QUOTE format")
+			if !slices.Contains(pairs, pair) {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	v.Check(len(pairs) <= maxPairs, "pairs", "too many pairs")
+	return pairs
+}
+
+// parseConversion validates conversion parameters. Historical conversion uses the latest rates known
+// by the end of the date, so returned time is the start of the next day, zero time means current rates.
+func parseConversion(from, to, amountParam, dateParam string, v *validator.Validator) (
+	rate.CurrencyPair,
+	decimal.Decimal,
+	time.Time,
+) {
+	v.Check(validator.IsValidCurrencyCode(from), "from", "invalid currency code")
+	v.Check(validator.IsValidCurrencyCode(to), "to", "invalid currency code")
+	amount, err := decimal.NewFromString(amountParam)
+	v.Check(err == nil && amount.IsPositive(), "amount", "must be positive number")
+
+	var at time.Time
+	if dateParam != "" {
+		date, err := time.Parse(ConvertDateLayout, dateParam)
+		v.Check(err == nil, "date", "must be in YYYY-MM-DD format")
+		v.Check(err != nil || date.Before(time.Now()), "date", "must not be in future")
+		at = date.AddDate(0, 0, 1)
+	}
+	return rate.NewCurrencyPair(from, to), amount, at
+}
+
+// parsePreferences reads subscription preferences from the form, missing values are defaulted.
+// Pairs may be passed as repeated values or separated by comma, time is a full hour in HH:MM format.
+func (app *application) parsePreferences(form url.Values, v *validator.Validator) repositories.Preferences {
+	preferences := services.DefaultPreferences()
+
+	pairs := parsePairs(form["pairs"], v, MaxSubscriptionPairs)
+	if len(pairs) > 0 {
+		preferences.Pairs = make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			// emails carry official or middle market rates, so pair nobody provides them for is useless.
+			supported := app.rateService.Supports(pair, rate.RateTypeOfficial) ||
+				app.rateService.Supports(pair, rate.RateTypeMid)
+			v.Check(supported, "pairs", pair.String()+" is not supported")
+			preferences.Pairs = append(preferences.Pairs, pair.String())
+		}
+	}
+
+	if frequency := form.Get("frequency"); frequency != "" {
+		v.Check(services.IsValidFrequency(frequency), "frequency", "must be hourly, daily or weekly")
+		preferences.Frequency = frequency
+	}
+	if deliveryTime := form.Get("time"); deliveryTime != "" {
+		parsed, err := time.Parse(DeliveryTimeLayout, deliveryTime)
+		v.Check(err == nil && parsed.Minute() == 0, "time", "must be a full hour in HH:MM format")
+		preferences.DeliveryHour = parsed.Hour()
+	}
+	if timezone := form.Get("timezone"); timezone != "" {
+		_, err := time.LoadLocation(timezone)
+		v.Check(err == nil, "timezone", "must be IANA timezone name")
+		preferences.Timezone = timezone
+	}
+	return preferences
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, data envelope, statusCode int) error {
 	jsBytes, err := json.Marshal(data)
 	if err != nil {
@@ -23,11 +240,171 @@ func (app *application) writeJSON(w http.ResponseWriter, data envelope, statusCo
 	return err
 }
 
+func (app *application) renderUnsubscribePage(w http.ResponseWriter, email string, unsubscribed bool) {
+	data := struct {
+		Email        string
+		Unsubscribed bool
+	}{Email: email, Unsubscribed: unsubscribed}
+	app.renderPage(w, unsubscribePage, data)
+}
+
+func (app *application) renderDeleteAlertPage(w http.ResponseWriter, deleted bool) {
+	app.renderPage(w, deleteAlertPage, struct{ Deleted bool }{Deleted: deleted})
+}
+
+// authorizeAlert returns id of alert from the path, if token parameter is issued for that alert.
+func (app *application) authorizeAlert(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return 0, false
+	}
+	tokenID, err := app.alertLinks.ID(r.URL.Query().Get("token"))
+	if err != nil || tokenID != id {
+		app.errorResponse(w, http.StatusBadRequest, "invalid_token", "invalid alert token", nil)
+		return 0, false
+	}
+	return id, true
+}
+
+func (app *application) renderPage(w http.ResponseWriter, page *template.Template, data any) {
+	buffer := new(bytes.Buffer)
+	if err := page.Execute(buffer, data); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := buffer.WriteTo(w); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
+
+// readForm returns fields of form or JSON object body. JSON values are converted to strings,
+// so both bodies are validated the same way.
+func readForm(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		return r.PostForm, nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	var body map[string]any
+	if err := decoder.Decode(&body); err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return nil, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return nil, errors.New("body must be JSON object")
+	}
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return nil, errors.New("body must contain single JSON object")
+	}
+
+	form := make(url.Values, len(body))
+	for key, value := range body {
+		values, ok := formValues(value)
+		if !ok {
+			return nil, fmt.Errorf("field %q must be string, number, boolean or array of them", key)
+		}
+		// null is the same as missing field.
+		if values != nil {
+			form[key] = values
+		}
+	}
+	return form, nil
+}
+
+func formValues(value any) ([]string, bool) {
+	switch value := value.(type) {
+	case nil:
+		return nil, true
+	case string:
+		return []string{value}, true
+	case json.Number:
+		return []string{value.String()}, true
+	case bool:
+		return []string{strconv.FormatBool(value)}, true
+	case []any:
+		var values []string
+		for _, element := range value {
+			if _, isArray := element.([]any); isArray {
+				return nil, false
+			}
+			elementValues, ok := formValues(element)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, elementValues...)
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// errorBody is the body of every error response, fields hold messages of invalid request fields.
+type errorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (app *application) errorResponse(
+	w http.ResponseWriter,
+	status int,
+	code, message string,
+	fields map[string]string,
+) {
+	body := envelope{"error": errorBody{Code: code, Message: message, Fields: fields}}
+	if err := app.writeJSON(w, body, status); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
+
 func (app *application) serverError(w http.ResponseWriter, err error) {
 	log.Error().Err(err).Send()
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	app.errorResponse(w, http.StatusInternalServerError, "internal_error",
+		"the server encountered a problem and could not process the request", nil)
 }
 
+// clientError responds with generic error of the status, code is snake cased status text, e.g. not_found.
 func (app *application) clientError(w http.ResponseWriter, status int) {
-	http.Error(w, http.StatusText(status), status)
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	app.errorResponse(w, status, code, strings.ToLower(http.StatusText(status)), nil)
+}
+
+func (app *application) badRequest(w http.ResponseWriter, err error) {
+	app.errorResponse(w, http.StatusBadRequest, "invalid_body", err.Error(), nil)
+}
+
+func (app *application) unsupportedPair(w http.ResponseWriter) {
+	app.errorResponse(w, http.StatusBadRequest, "unsupported_pair", "currency pair is not supported", nil)
+}
+
+func (app *application) providerUnavailable(w http.ResponseWriter) {
+	app.errorResponse(w, http.StatusServiceUnavailable, "provider_unavailable",
+		"rate providers are unavailable, try again later", nil)
+}
+
+func (app *application) failedValidation(w http.ResponseWriter, v *validator.Validator) {
+	app.errorResponse(w, http.StatusBadRequest, "validation_failed", "request contains invalid fields", v.Errors)
+}
+
+// checkRateProviders reports whether at least one rate provider is not skipped by its circuit breaker.
+func (app *application) checkRateProviders(context.Context) error {
+	providers := app.rateService.Providers()
+	for _, provider := range providers {
+		if provider.State != rate.BreakerOpen.String() {
+			return nil
+		}
+	}
+	if len(providers) == 0 {
+		return nil
+	}
+	return rate.ErrProviderUnavailable
 }
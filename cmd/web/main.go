@@ -4,49 +4,133 @@ import (
 	"context"
 	"flag"
 	"os"
+	"sync/atomic"
 	"time"
+	// subscribers' timezones are validated and resolved without relying on system zoneinfo.
+	_ "time/tzdata"
 
 	"github.com/fdemchenko/exchanger/cmd/web/internal/messaging"
 	"github.com/fdemchenko/exchanger/internal/communication/customers"
 	"github.com/fdemchenko/exchanger/internal/communication/mailer"
 	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
 	"github.com/fdemchenko/exchanger/internal/database"
+	"github.com/fdemchenko/exchanger/internal/health"
+	"github.com/fdemchenko/exchanger/internal/money"
+	"github.com/fdemchenko/exchanger/internal/ratelimit"
 	"github.com/fdemchenko/exchanger/internal/repositories"
 	"github.com/fdemchenko/exchanger/internal/services"
 	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/tokens"
 	"github.com/fdemchenko/exchanger/migrations"
 	_ "github.com/lib/pq"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 )
 
 type config struct {
-	addr string
-	db   struct {
+	addr         string
+	grpcAddr     string
+	internalAddr string
+	db           struct {
 		dsn            string
 		maxConnections int
 	}
 	mailerUpdateInterval time.Duration
 	rabbitMQConnString   string
+	ratePrecision        int
+	baseURL              string
+	tokenSecret          string
+	confirmationTTL      time.Duration
+	wsMaxConnections     int
+	rateLimit            struct {
+		anonymous int
+		apiKey    int
+	}
+	rate struct {
+		consensus       bool
+		maxDeviation    float64
+		breakerFailures int
+		breakerCoolDown time.Duration
+		maxStaleness    time.Duration
+	}
 }
 
 type RateService interface {
-	GetRate(context.Context, string) (float32, error)
+	GetRate(context.Context, rate.CurrencyPair, rate.RateType) (rate.Rate, error)
+	Supports(rate.CurrencyPair, rate.RateType) bool
+	Providers() []rate.ProviderStatus
 }
 
 type EmailService interface {
-	Create(email string) (int, error)
+	Create(email string, preferences repositories.Preferences, newMessage repositories.OutboxMessageFunc) (int, error)
+	Confirm(id int, newMessage repositories.OutboxMessageFunc) (string, error)
 	GetAll() ([]string, error)
 	DeleteByEmail(email string) error
 	DeleteByID(id int) error
 }
 
+// OutboxRelay publishes messages saved along with data changes.
+type OutboxRelay interface {
+	Notify()
+}
+
+type CurrencyConverter interface {
+	Convert(ctx context.Context, pair rate.CurrencyPair, amount decimal.Decimal, at time.Time) (rate.Conversion, error)
+}
+
+type AlertService interface {
+	Create(
+		ctx context.Context,
+		email string,
+		pair rate.CurrencyPair,
+		rateType rate.RateType,
+		kind string,
+		threshold decimal.Decimal,
+		newMessage repositories.OutboxMessageFunc,
+	) (int, error)
+	Confirm(ctx context.Context, id int) error
+	Delete(ctx context.Context, id int) error
+}
+
+type RateStream interface {
+	Subscribe(filter services.RateFilter, lastEventID uint64) (*services.RateSubscription, []services.RateEvent)
+	UpdateFilter(subscription *services.RateSubscription, filter services.RateFilter) []services.RateEvent
+	Unsubscribe(subscription *services.RateSubscription)
+	Close()
+}
+
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (repositories.APIKey, error)
+}
+
+type RateHistoryRepository interface {
+	GetHistory(
+		ctx context.Context,
+		base, quote, rateType string,
+		from, to time.Time,
+		interval time.Duration,
+	) ([]repositories.RatePoint, error)
+}
+
 type application struct {
 	cfg              config
 	rateService      RateService
 	emailService     EmailService
-	customerProducer *rabbitmq.GenericProducer
+	rateHistory      RateHistoryRepository
+	converter        CurrencyConverter
+	alertService     AlertService
+	rateStream       RateStream
+	outbox           OutboxRelay
+	tokens           *tokens.Signer
+	unsubscribeLinks *services.UnsubscribeLinks
+	alertLinks       *services.AlertLinks
+	apiKeys          APIKeyAuthenticator
+	limiter          *ratelimit.Limiter
+	health           *health.Checker
+	wsConnections    atomic.Int64
+	// streamFetches limits number of concurrent fetches of rates, that streams are waiting for.
+	streamFetches chan struct{}
 }
 
 const (
@@ -54,6 +138,28 @@ const (
 	DefaultMaxDBConnections = 25
 	DefaultMailerInterval   = 24 * time.Hour
 	RateCachingDuration     = 15 * time.Minute
+	DefaultHistoryPeriod    = 24 * time.Hour
+	DefaultHistoryInterval  = time.Hour
+	MaxHistoryPoints        = 1000
+	DeliveryTimeLayout      = "15:04"
+	MaxSubscriptionPairs    = 10
+	MaxRequestBodySize      = 1 << 20
+	ConvertDateLayout       = time.DateOnly
+	DefaultConfirmationTTL  = 24 * time.Hour
+	ConfirmationResendDelay = 5 * time.Minute
+	CleanupInterval         = time.Hour
+	StreamHeartbeatInterval = 15 * time.Second
+	StreamRetryInterval     = 3 * time.Second
+	MaxStreamPairs          = 20
+	MaxStreamFetches        = 4
+	DefaultWSMaxConnections = 1000
+	// rate limits are numbers of requests per minute.
+	DefaultAnonymousRateLimit = 60
+	DefaultAPIKeyRateLimit    = 600
+	APIKeyHeader              = "X-API-Key"
+
+	ConfirmationTokenPurpose      tokens.Purpose = "subscription-confirmation"
+	AlertConfirmationTokenPurpose tokens.Purpose = "alert-confirmation"
 )
 
 func main() {
@@ -73,19 +179,53 @@ func main() {
 	}
 	log.Info().Msg("Migrations successfully applied")
 
-	rabbitMQConn, err := amqp.Dial(cfg.rabbitMQConnString)
+	rabbitMQConn, err := rabbitmq.Dial(cfg.rabbitMQConnString,
+		rabbitmq.WithQueues(customers.Queues),
+		rabbitmq.WithQueues(mailer.Queues),
+	)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 	log.Info().Msg("Coonected to RabbitMQ successfully")
 
-	createCustomersChannel, err := rabbitmq.OpenWithQueueName(rabbitMQConn, customers.CreateCustomerRequestQueue)
+	outboxChannel, err := rabbitMQConn.Channel(customers.CreateCustomerRequestQueue)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	outboxProducer, err := rabbitmq.NewConfirmingProducer(outboxChannel)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
-	customersProducer := rabbitmq.NewGenericProducer(createCustomersChannel)
-	subscriptionRepository := &repositories.PostgresSubscriptionRepository{DB: db}
+	subscriptionRepository := &repositories.PostgresSubscriptionRepository{
+		DB:             db,
+		ResendInterval: ConfirmationResendDelay,
+	}
 	emailService := services.NewSubscriptionService(subscriptionRepository)
+	rateRepository := &repositories.PostgresRateRepository{DB: db}
+
+	rateEmailsChannel, err := rabbitMQConn.Channel(mailer.RateEmailsQueue)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	signer := tokens.NewSigner([]byte(cfg.tokenSecret))
+	unsubscribeLinks := services.NewUnsubscribeLinks(signer, cfg.baseURL+APIPrefix)
+	alertLinks := services.NewAlertLinks(signer, cfg.baseURL+APIPrefix)
+	mailerProducer, err := rabbitmq.NewGenericProducer(rateEmailsChannel)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	outboxRelay := services.NewOutboxRelay(&repositories.PostgresOutboxRepository{DB: db}, outboxProducer)
+	alertService := services.NewAlertService(
+		&repositories.PostgresAlertRepository{DB: db},
+		outboxRelay,
+		unsubscribeLinks,
+		alertLinks,
+	)
+	rateBroadcaster := services.NewRateBroadcaster(services.DefaultBroadcastHistorySize)
+	rateStrategy := rate.FallbackStrategy
+	if cfg.rate.consensus {
+		rateStrategy = rate.ConsensusStrategy
+	}
 	rateService := rate.NewRateService(
 		rate.WithFetchers(
 			rate.NewNBURateFetcher("nbu fetcher"),
@@ -93,12 +233,23 @@ func main() {
 			rate.NewPrivatRateFetcher("privat fetcher"),
 		),
 		rate.WithUpdateInterval(RateCachingDuration),
+		rate.WithMaxStaleness(cfg.rate.maxStaleness),
+		rate.WithHistoryRecorder(rateRepository),
+		rate.WithStrategy(rateStrategy),
+		rate.WithMaxDeviation(cfg.rate.maxDeviation),
+		rate.WithCircuitBreaker(cfg.rate.breakerFailures, cfg.rate.breakerCoolDown),
+		rate.WithListeners(alertService.OnRateFetched, rateBroadcaster.OnRateFetched),
 	)
 
-	checkCustomersCreationChannel, err := rabbitmq.OpenWithQueueName(
-		rabbitMQConn,
-		customers.CreateCustomerResponseQueue,
-	)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	alertService.Start(backgroundCtx)
+	alertService.WatchAlertedPairs(backgroundCtx, rateService, RateCachingDuration)
+	rateBroadcaster.WatchStreamedPairs(backgroundCtx, rateService, RateCachingDuration)
+	emailService.StartCleanup(backgroundCtx, CleanupInterval, cfg.confirmationTTL)
+	alertService.StartCleanup(backgroundCtx, CleanupInterval, cfg.confirmationTTL)
+	outboxRelay.Start(backgroundCtx, services.DefaultOutboxPollInterval)
+
+	checkCustomersCreationChannel, err := rabbitMQConn.Channel(customers.CreateCustomerResponseQueue)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
@@ -108,18 +259,18 @@ func main() {
 		subscriptionRepository,
 	)
 
-	err = customersSAGAConsumer.StartListening()
+	err = customersSAGAConsumer.Start(backgroundCtx)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
 
-	rateEmailsChannel, err := rabbitmq.OpenWithQueueName(rabbitMQConn, mailer.RateEmailsQueue)
+	emailTriggersChannel, err := rabbitMQConn.Channel(mailer.TriggerEmailsSendingQueue)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
-	emailsSender := services.NewRabbitMQEmailSender(emailService, rateService, rateEmailsChannel)
-	triggerConsumer := messaging.NewEmailTriggerConsumer(rateEmailsChannel, emailsSender)
-	err = triggerConsumer.StartListening()
+	emailsSender := services.NewRabbitMQEmailSender(emailService, rateService, mailerProducer, unsubscribeLinks)
+	triggerConsumer := messaging.NewEmailTriggerConsumer(emailTriggersChannel, emailsSender)
+	err = triggerConsumer.Start(backgroundCtx)
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
@@ -128,14 +279,42 @@ func main() {
 		cfg:              cfg,
 		rateService:      rateService,
 		emailService:     emailService,
-		customerProducer: customersProducer,
+		rateHistory:      rateRepository,
+		converter:        rate.NewConverter(rateService, rateRepository),
+		alertService:     alertService,
+		rateStream:       rateBroadcaster,
+		outbox:           outboxRelay,
+		tokens:           signer,
+		unsubscribeLinks: unsubscribeLinks,
+		alertLinks:       alertLinks,
+		apiKeys: services.NewAPIKeyService(
+			&repositories.PostgresAPIKeyRepository{DB: db},
+			services.DefaultAPIKeyCacheTTL,
+		),
+		limiter:       ratelimit.New(),
+		health:        health.NewChecker(health.DefaultTimeout),
+		streamFetches: make(chan struct{}, MaxStreamFetches),
 	}
+	app.health.Add("db", health.DB(db))
+	app.health.Add("rabbitmq", health.RabbitMQ(
+		rabbitMQConn,
+		outboxChannel,
+		rateEmailsChannel,
+		checkCustomersCreationChannel,
+		emailTriggersChannel,
+	))
+	app.health.Add("email_trigger_consumer", triggerConsumer.Check)
+	app.health.Add("customer_saga_consumer", customersSAGAConsumer.Check)
+	app.health.Add("rate_providers", app.checkRateProviders)
 
 	log.Info().Str("address", app.cfg.addr).Msg("Web server started")
-	err = app.serveHTTP()
+	err = app.serve()
 	if err != nil {
 		log.Fatal().Err(err).Send()
 	}
+	stopBackground()
+	customersSAGAConsumer.Wait()
+	triggerConsumer.Wait()
 
 	if err := rabbitMQConn.Close(); err != nil {
 		log.Error().Err(err).Msg("Cannot close RabbitMQ connection")
@@ -150,6 +329,8 @@ func initConfig() config {
 	var cfg config
 	cfg.mailerUpdateInterval = DefaultMailerInterval
 	flag.StringVar(&cfg.addr, "addr", ":8080", "http listen address")
+	flag.StringVar(&cfg.grpcAddr, "grpc-addr", ":9090", "gRPC listen address")
+	flag.StringVar(&cfg.internalAddr, "internal-addr", ":8081", "internal http listen address of admin endpoints")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("EXCHANGER_DSN"), "Data source name")
 	flag.IntVar(&cfg.db.maxConnections, "db-max-conn", DefaultMaxDBConnections, "Database max connection")
 	flag.StringVar(&cfg.rabbitMQConnString,
@@ -157,6 +338,65 @@ func initConfig() config {
 		os.Getenv("EXCHANGER_RABBITMQ_CONN_STRING"),
 		"RabbitMQ connection string",
 	)
+	flag.BoolVar(&cfg.rate.consensus,
+		"rate-consensus",
+		false,
+		"Query all rate providers concurrently and return median instead of the first available rate",
+	)
+	flag.Float64Var(&cfg.rate.maxDeviation,
+		"rate-max-deviation",
+		rate.DefaultMaxDeviation,
+		"Relative deviation from median after which provider rate is discarded in consensus mode",
+	)
+	flag.IntVar(&cfg.rate.breakerFailures,
+		"rate-breaker-failures",
+		rate.DefaultBreakerFailureThreshold,
+		"Consecutive failures after which rate provider is skipped",
+	)
+	flag.DurationVar(&cfg.rate.breakerCoolDown,
+		"rate-breaker-cooldown",
+		rate.DefaultBreakerCoolDown,
+		"Time after which skipped rate provider is tried again",
+	)
+	flag.DurationVar(&cfg.rate.maxStaleness,
+		"rate-max-staleness",
+		rate.DefaultMaxStaleness,
+		"How long expired rate is served if it cannot be refreshed",
+	)
+	flag.IntVar(&cfg.ratePrecision,
+		"rate-precision",
+		money.DefaultDisplayPrecision,
+		"Number of digits after decimal point rates are returned with",
+	)
+	flag.StringVar(&cfg.baseURL, "base-url", "http://localhost:8080", "Public URL of the service used in email links")
+	flag.StringVar(&cfg.tokenSecret,
+		"token-secret",
+		os.Getenv("EXCHANGER_TOKEN_SECRET"),
+		"Secret key signing tokens in email links",
+	)
+	flag.DurationVar(&cfg.confirmationTTL,
+		"confirmation-ttl",
+		DefaultConfirmationTTL,
+		"How long subscription can be confirmed before it is deleted",
+	)
+	flag.IntVar(&cfg.wsMaxConnections,
+		"ws-max-connections",
+		DefaultWSMaxConnections,
+		"Maximum number of concurrent WebSocket connections",
+	)
+	flag.IntVar(&cfg.rateLimit.anonymous,
+		"rate-limit-anonymous",
+		DefaultAnonymousRateLimit,
+		"Requests per minute allowed from a single IP without API key",
+	)
+	flag.IntVar(&cfg.rateLimit.apiKey,
+		"rate-limit-key",
+		DefaultAPIKeyRateLimit,
+		"Requests per minute allowed with API key, that has no own limit",
+	)
 	flag.Parse()
+	if cfg.tokenSecret == "" {
+		log.Fatal().Msg("Token secret must be set")
+	}
 	return cfg
 }
@@ -1,13 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/ratelimit"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/justinas/alice"
 	"github.com/rs/zerolog/log"
 )
 
+type contextKey string
+
+const apiKeyContextKey = contextKey("apiKey")
+
 type StatusCodeRecorder struct {
 	StatusCode int
 	http.ResponseWriter
@@ -18,6 +33,20 @@ func (scr *StatusCodeRecorder) WriteHeader(status int) {
 	scr.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap lets http.ResponseController flush and set deadlines of the wrapped writer.
+func (scr *StatusCodeRecorder) Unwrap() http.ResponseWriter {
+	return scr.ResponseWriter
+}
+
+// Hijack lets WebSocket connections take over the wrapped writer, status is recorded as protocol switch.
+func (scr *StatusCodeRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(scr.ResponseWriter).Hijack()
+	if err == nil {
+		scr.StatusCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
 var secureHeaders = map[string]string{
 	"Cache-Control":           "no-store",
 	"Content-Security-Policy": "frame-ancestors 'none'",
@@ -32,13 +61,21 @@ func (app *application) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) RequestCounterMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		recorder := &StatusCodeRecorder{ResponseWriter: w, StatusCode: http.StatusOK}
-		next.ServeHTTP(recorder, r)
-		s := fmt.Sprintf(`requests_total{method="%s", path=%q, status="%d"}`, r.Method, r.URL.Path, recorder.StatusCode)
-		metrics.GetOrCreateCounter(s).Inc()
-	})
+// RequestCounterMiddleware counts requests by route pattern of mux instead of request path,
+// so tokens in paths do not end up in labels.
+func (app *application) RequestCounterMiddleware(mux *http.ServeMux) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := mux.Handler(r)
+			if pattern == "" {
+				pattern = "unmatched"
+			}
+			recorder := &StatusCodeRecorder{ResponseWriter: w, StatusCode: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+			s := fmt.Sprintf(`requests_total{method="%s", pattern=%q, status="%d"}`, r.Method, pattern, recorder.StatusCode)
+			metrics.GetOrCreateCounter(s).Inc()
+		})
+	}
 }
 
 func (app *application) recoveryMiddleware(next http.Handler) http.Handler {
@@ -53,6 +90,17 @@ func (app *application) recoveryMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// deprecatedMiddleware marks responses of unversioned paths as deprecated and points to their successors.
+// Route pattern is used in metric instead of request path, so tokens in paths do not end up in labels.
+func (app *application) deprecatedMiddleware(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", APIPrefix, r.URL.Path))
+		metrics.GetOrCreateCounter(fmt.Sprintf(`deprecated_requests_total{pattern=%q}`, pattern)).Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) secureHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		for headerKey, headerValue := range secureHeaders {
@@ -61,3 +109,98 @@ func (app *application) secureHeadersMiddleware(next http.Handler) http.Handler
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authMiddleware resolves API key of the request, requests without key are served anonymously.
+func (app *application) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, err := app.apiKeys.Authenticate(r.Context(), key)
+		if err != nil {
+			if !errors.Is(err, services.ErrInvalidAPIKey) {
+				app.serverError(w, err)
+				return
+			}
+			// failed attempts are charged against quota of IP, so keys cannot be guessed faster
+			// than anonymous requests are served.
+			if app.limiter != nil {
+				result := app.allow(nil, clientIP(r))
+				writeRateLimitHeaders(w, result)
+				if !result.Allowed {
+					app.rateLimited(w, result)
+					return
+				}
+			}
+			app.errorResponse(w, http.StatusUnauthorized, "invalid_api_key", "API key is invalid or revoked", nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, apiKey)))
+	})
+}
+
+// rateLimitMiddleware applies quota of API key to authenticated requests and lower quota of client IP
+// to anonymous ones.
+func (app *application) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result ratelimit.Result
+		if apiKey, ok := r.Context().Value(apiKeyContextKey).(repositories.APIKey); ok {
+			result = app.allow(&apiKey, "")
+		} else {
+			result = app.allow(nil, clientIP(r))
+		}
+		writeRateLimitHeaders(w, result)
+		if !result.Allowed {
+			app.rateLimited(w, result)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow charges request against quota of API key or, if key is nil, against quota of client IP.
+// HTTP and gRPC requests share quotas.
+func (app *application) allow(apiKey *repositories.APIKey, ip string) ratelimit.Result {
+	client, clientKey := "anonymous", "ip:"+ip
+	quota := ratelimit.PerMinute(app.cfg.rateLimit.anonymous)
+	if apiKey != nil {
+		client, clientKey = "key", "key:"+strconv.Itoa(apiKey.ID)
+		quota = ratelimit.PerMinute(app.cfg.rateLimit.apiKey)
+		if apiKey.RateLimit.Valid {
+			quota = ratelimit.PerMinute(int(apiKey.RateLimit.Int32))
+		}
+	}
+
+	result := app.limiter.Allow(clientKey, quota)
+	if !result.Allowed {
+		metrics.GetOrCreateCounter(fmt.Sprintf(`rate_limited_requests_total{client=%q}`, client)).Inc()
+	}
+	return result
+}
+
+func writeRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+func (app *application) rateLimited(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	app.errorResponse(w, http.StatusTooManyRequests, "rate_limited", "too many requests, try again later", nil)
+}
+
+// clientIP is taken from connection, as forwarded headers can be set by clients to avoid limits.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
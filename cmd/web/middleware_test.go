@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/fdemchenko/exchanger/internal/ratelimit"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,3 +42,63 @@ func TestSecureHeaders(t *testing.T) {
 	}
 	assert.Equal(t, string(body), "OK")
 }
+
+type APIKeysStub map[string]repositories.APIKey
+
+func (aks APIKeysStub) Authenticate(_ context.Context, key string) (repositories.APIKey, error) {
+	apiKey, ok := aks[key]
+	if !ok {
+		return repositories.APIKey{}, services.ErrInvalidAPIKey
+	}
+	return apiKey, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	app := &application{
+		apiKeys: APIKeysStub{
+			"default": {ID: 1},
+			"limited": {ID: 2, RateLimit: sql.NullInt32{Int32: 1, Valid: true}},
+		},
+		limiter: ratelimit.New(),
+	}
+	app.cfg.rateLimit.anonymous = 2
+	app.cfg.rateLimit.apiKey = 3
+	handler := app.routes()
+
+	request := func(remoteAddr, key string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, APIPrefix+"/openapi.json", nil)
+		request.RemoteAddr = remoteAddr
+		if key != "" {
+			request.Header.Set(APIKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	first := request("10.0.0.1:1000", "")
+	assert.Equal(t, http.StatusOK, first.StatusCode)
+	assert.Equal(t, "2", first.Header.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header.Get("X-RateLimit-Remaining"))
+	// quota of IP is shared by connections from different ports.
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:2000", "").StatusCode)
+	limited := request("10.0.0.1:1000", "")
+	assert.Equal(t, http.StatusTooManyRequests, limited.StatusCode)
+	assert.Equal(t, "30", limited.Header.Get("Retry-After"))
+	assert.Equal(t, "0", limited.Header.Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "60", limited.Header.Get("X-RateLimit-Reset"))
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1000", "").StatusCode)
+
+	// requests with key use quota of the key instead of quota of IP.
+	withKey := request("10.0.0.1:1000", "default")
+	assert.Equal(t, http.StatusOK, withKey.StatusCode)
+	assert.Equal(t, "3", withKey.Header.Get("X-RateLimit-Limit"))
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1000", "limited").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.3:1000", "limited").StatusCode)
+
+	// failed authentication is charged against quota of IP.
+	assert.Equal(t, http.StatusUnauthorized, request("10.0.0.4:1000", "unknown").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, request("10.0.0.4:1000", "unknown").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.4:1000", "unknown").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.4:1000", "").StatusCode)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/fdemchenko/exchanger/internal/tokens"
+	"github.com/fdemchenko/exchanger/web/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type openAPIResponse struct {
+	Ref     string                     `json:"$ref"`
+	Content map[string]json.RawMessage `json:"content"`
+}
+
+type openAPIOperation struct {
+	Responses   map[string]openAPIResponse `json:"responses"`
+	RequestBody json.RawMessage            `json:"requestBody"`
+}
+
+type openAPISpec struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse `json:"responses"`
+	} `json:"components"`
+}
+
+var pathParameterRX = regexp.MustCompile(`\{[^}]+\}`)
+
+func loadOpenAPISpec(t *testing.T) openAPISpec {
+	var spec openAPISpec
+	if err := json.Unmarshal(api.OpenAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// operation returns documented operation of the method and path, path is relative to APIPrefix.
+func (spec openAPISpec) operation(t *testing.T, method, path string) (openAPIOperation, bool) {
+	rawOperation, ok := spec.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return openAPIOperation{}, false
+	}
+	var operation openAPIOperation
+	if err := json.Unmarshal(rawOperation, &operation); err != nil {
+		t.Fatal(err)
+	}
+	return operation, true
+}
+
+func (spec openAPISpec) response(operation openAPIOperation, status string) (openAPIResponse, bool) {
+	response, ok := operation.Responses[status]
+	if ok && response.Ref != "" {
+		response, ok = spec.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	return response, ok
+}
+
+func TestOpenAPISpec_MatchesRoutes(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."))
+	app := &application{}
+	mux := app.newMux()
+
+	documented := 0
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			documented++
+			requestPath := APIPrefix + pathParameterRX.ReplaceAllString(path, "1")
+			request := httptest.NewRequest(strings.ToUpper(method), requestPath, nil)
+			_, pattern := mux.Handler(request)
+			assert.Equal(t, strings.ToUpper(method)+" "+APIPrefix+path, pattern, "%s %s is not routed", method, path)
+		}
+	}
+
+	for _, route := range app.apiRoutes() {
+		_, ok := spec.operation(t, route.method, route.path)
+		assert.True(t, ok, "%s %s is not documented", route.method, route.path)
+	}
+	assert.Equal(t, len(app.apiRoutes()), documented)
+}
+
+// TestOpenAPISpec_Responses checks responses of real handlers, that do not reach services, are documented.
+func TestOpenAPISpec_Responses(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	signer := tokens.NewSigner([]byte("secret"))
+	alertLinks := services.NewAlertLinks(signer, "")
+	app := &application{
+		tokens:           signer,
+		unsubscribeLinks: services.NewUnsubscribeLinks(signer, APIPrefix),
+		alertLinks:       alertLinks,
+		alertService:     &AlertServiceStub{},
+	}
+	handler := app.routes()
+
+	testCases := []struct {
+		method string
+		path   string
+		target string
+		body   string
+		status int
+	}{
+		{method: http.MethodGet, path: "/rate", target: "/rate?base=US", status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/rate/stream", target: "/rate/stream?pairs=USD", status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/ws", target: "/ws", status: http.StatusUpgradeRequired},
+		{method: http.MethodGet, path: "/rates/history", target: "/rates/history?interval=1x",
+			status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/convert", target: "/convert?from=USD", status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/subscribe", target: "/subscribe", body: `{"email": "invalid"}`,
+			status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/subscribe/confirm", target: "/subscribe/confirm?token=forged",
+			status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/subscribe/confirm",
+			target: "/subscribe/confirm?token=" + signer.Sign(ConfirmationTokenPurpose, "1", time.Now().Add(-time.Minute)),
+			status: http.StatusGone},
+		{method: http.MethodGet, path: "/unsubscribe/{token}",
+			target: strings.TrimPrefix(app.unsubscribeLinks.URL("someone@mail.com"), APIPrefix), status: http.StatusOK},
+		{method: http.MethodPost, path: "/unsubscribe/{token}", target: "/unsubscribe/forged",
+			status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/alerts", target: "/alerts", body: `{"email": "a@mail.com"}`,
+			status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/alerts/confirm", target: "/alerts/confirm?token=forged",
+			status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/alerts/confirm",
+			target: "/alerts/confirm?token=" + signer.Sign(AlertConfirmationTokenPurpose, "1", time.Now().Add(-time.Minute)),
+			status: http.StatusGone},
+		{method: http.MethodDelete, path: "/alerts/{id}", target: "/alerts/0", status: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/alerts/{id}", target: "/alerts/1?token=forged",
+			status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/alerts/{id}/delete", target: alertLinks.DeleteURL(1), status: http.StatusOK},
+		{method: http.MethodPost, path: "/alerts/{id}/delete", target: "/alerts/1/delete?token=forged",
+			status: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/openapi.json", target: "/openapi.json", status: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.target, func(t *testing.T) {
+			operation, ok := spec.operation(t, tc.method, tc.path)
+			if !ok {
+				t.Fatalf("%s %s is not documented", tc.method, tc.path)
+			}
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tc.method, APIPrefix+tc.target, strings.NewReader(tc.body))
+			request.Header.Set("Content-Type", "application/json")
+			handler.ServeHTTP(recorder, request)
+			assert.Equal(t, tc.status, recorder.Code)
+
+			response, ok := spec.response(operation, strconv.Itoa(recorder.Code))
+			if !ok {
+				t.Fatalf("status %d is not documented", recorder.Code)
+			}
+			mediaType, _, _ := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+			assert.Contains(t, response.Content, mediaType)
+			if recorder.Code >= http.StatusBadRequest {
+				var body struct {
+					Error errorBody `json:"error"`
+				}
+				assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+				assert.NotEmpty(t, body.Error.Code)
+				assert.NotEmpty(t, body.Error.Message)
+			}
+		})
+	}
+}
+
+func TestDeprecatedAliases(t *testing.T) {
+	handler := (&application{}).routes()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/convert?from=USD", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "true", recorder.Header().Get("Deprecation"))
+	assert.Equal(t, `</api/v1/convert>; rel="successor-version"`, recorder.Header().Get("Link"))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/convert?from=USD", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Deprecation"))
+}
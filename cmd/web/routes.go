@@ -1,104 +1,700 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/fdemchenko/exchanger/internal/communication"
 	"github.com/fdemchenko/exchanger/internal/communication/customers"
+	"github.com/fdemchenko/exchanger/internal/communication/mailer"
+	"github.com/fdemchenko/exchanger/internal/money"
 	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/tokens"
 	"github.com/fdemchenko/exchanger/internal/validator"
+	"github.com/fdemchenko/exchanger/web/api"
 	"github.com/justinas/alice"
 	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 )
 
-func (app *application) routes() http.Handler {
+// APIPrefix is a path prefix of the current API version.
+const APIPrefix = "/api/v1"
+
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	// legacy routes existed before versioning and are served on unversioned paths as well.
+	legacy bool
+}
+
+// apiRoutes are routes of the current API version, paths are relative to APIPrefix.
+func (app *application) apiRoutes() []route {
+	return []route{
+		{method: http.MethodGet, path: "/rate", handler: app.getRate, legacy: true},
+		{method: http.MethodGet, path: "/rate/stream", handler: app.streamRates},
+		{method: http.MethodGet, path: "/ws", handler: app.serveWebSocket},
+		{method: http.MethodGet, path: "/rates/history", handler: app.getRateHistory, legacy: true},
+		{method: http.MethodGet, path: "/convert", handler: app.convert, legacy: true},
+		{method: http.MethodPost, path: "/subscribe", handler: app.subscribe, legacy: true},
+		{method: http.MethodGet, path: "/subscribe/confirm", handler: app.confirmSubscription, legacy: true},
+		{method: http.MethodGet, path: "/unsubscribe/{token}", handler: app.unsubscribePage, legacy: true},
+		{method: http.MethodPost, path: "/unsubscribe/{token}", handler: app.unsubscribe, legacy: true},
+		{method: http.MethodPost, path: "/alerts", handler: app.createAlert, legacy: true},
+		{method: http.MethodGet, path: "/alerts/confirm", handler: app.confirmAlert},
+		{method: http.MethodDelete, path: "/alerts/{id}", handler: app.deleteAlert, legacy: true},
+		{method: http.MethodGet, path: "/alerts/{id}/delete", handler: app.deleteAlertPage},
+		{method: http.MethodPost, path: "/alerts/{id}/delete", handler: app.deleteAlertForm},
+		{method: http.MethodGet, path: "/openapi.json", handler: app.openAPISpec},
+	}
+}
+
+func (app *application) newMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /rate", app.getRate)
-	mux.HandleFunc("POST /subscribe", app.subscribe)
-	mux.HandleFunc("POST /unsubscribe", app.unsubscribe)
+	for _, route := range app.apiRoutes() {
+		mux.HandleFunc(route.method+" "+APIPrefix+route.path, route.handler)
+		// unversioned paths are kept for clients, that are not migrated yet, and for links in sent emails.
+		if route.legacy {
+			mux.Handle(route.method+" "+route.path, app.deprecatedMiddleware(route.method+" "+route.path, route.handler))
+		}
+	}
+	return mux
+}
+
+// internalRoutes are served on internal address, which is not exposed to API clients.
+func (app *application) internalRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/providers", app.getProviders)
 	mux.HandleFunc("GET /metrics", app.metrics)
+	return alice.New(app.recoveryMiddleware, app.loggingMiddleware).Then(mux)
+}
+
+func (app *application) routes() http.Handler {
+	mux := app.newMux()
 
 	middlewares := alice.New(
 		app.recoveryMiddleware,
 		app.loggingMiddleware,
 		app.secureHeadersMiddleware,
-		app.RequestCounterMiddleware,
+		app.RequestCounterMiddleware(mux),
 	)
-	return middlewares.Then(mux)
+	// keys and limits are optional, tests build app without them.
+	if app.apiKeys != nil {
+		middlewares = middlewares.Append(app.authMiddleware)
+	}
+	if app.limiter != nil {
+		middlewares = middlewares.Append(app.rateLimitMiddleware)
+	}
+	if app.health == nil {
+		return middlewares.Then(mux)
+	}
+
+	// probes bypass API middlewares, so they are neither limited nor counted as API requests.
+	root := http.NewServeMux()
+	app.health.Register(root)
+	root.Handle("/", middlewares.Then(mux))
+	return root
 }
 
 func (app *application) getRate(w http.ResponseWriter, r *http.Request) {
-	rate, err := app.rateService.GetRate(r.Context(), "usd")
+	query := r.URL.Query()
+	base := query.Get("base")
+	if base == "" {
+		base = rate.DefaultBaseCurrency
+	}
+	quote := query.Get("quote")
+	if quote == "" {
+		quote = rate.DefaultQuoteCurrency
+	}
+
+	rateType, err := parseRateType(query.Get("type"))
+
+	v := validator.New()
+	v.Check(validator.IsValidCurrencyCode(base), "base", "invalid currency code")
+	v.Check(validator.IsValidCurrencyCode(quote), "quote", "invalid currency code")
+	v.Check(err == nil, "type", "must be one of buy, sell, official, mid")
+	if !v.IsValid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	pair := rate.NewCurrencyPair(base, quote)
+	currentRate, err := app.rateService.GetRate(r.Context(), pair, rateType)
 	if err != nil {
+		if errors.Is(err, rate.ErrUnsupportedPair) || errors.Is(err, rate.ErrInvalidCurrencyCode) {
+			app.unsupportedPair(w)
+			return
+		}
+		if errors.Is(err, rate.ErrProviderUnavailable) {
+			log.Error().Err(err).Send()
+			app.providerUnavailable(w)
+			return
+		}
 		app.serverError(w, err)
 		return
 	}
-	err = app.writeJSON(w, envelope{"rate": rate}, http.StatusOK)
+	err = app.writeJSON(w, app.newRateResponse(currentRate), http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+	}
+}
+
+// streamRates pushes rate changes as Server-Sent Events until client disconnects.
+// Stream starts with the latest known rates or with events missed since Last-Event-ID.
+func (app *application) streamRates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := validator.New()
+	filter := services.RateFilter{Pairs: parsePairs(query["pairs"], v, MaxStreamPairs)}
+	for _, value := range query["type"] {
+		for _, typeParam := range strings.Split(value, ",") {
+			rateType, err := rate.ParseRateType(strings.TrimSpace(typeParam))
+			v.Check(err == nil, "type", "must be one of buy, sell, official, mid")
+			filter.Types = append(filter.Types, rateType)
+		}
+	}
+	lastEventID, err := parseLastEventID(r)
+	v.Check(err == nil, "lastEventId", "must be event ID")
+	if !v.IsValid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	subscription, missed := app.rateStream.Subscribe(filter, lastEventID)
+	defer app.rateStream.Unsubscribe(subscription)
+	app.requestStreamedRates(filter, missed)
+
+	metrics.GetOrCreateGauge("rate_streams_active", nil).Inc()
+	defer metrics.GetOrCreateGauge("rate_streams_active", nil).Dec()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// stream lives longer than server write timeout, so timeout is applied to every write instead.
+	controller := http.NewResponseController(w)
+	write := func(message string) error {
+		err := controller.SetWriteDeadline(time.Now().Add(ServerTimeout))
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, message); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+
+	message := fmt.Sprintf("retry: %d\n\n", StreamRetryInterval.Milliseconds())
+	for _, event := range missed {
+		formatted, err := app.formatRateEvent(event)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		message += formatted
+	}
+	if err := write(message); err != nil {
+		log.Debug().Err(err).Msg("Rate stream is closed")
+		return
+	}
+
+	heartbeat := time.NewTicker(StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			var formatted string
+			formatted, err = app.formatRateEvent(event)
+			if err == nil {
+				err = write(formatted)
+			}
+		case <-heartbeat.C:
+			err = write(": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("Rate stream is closed")
+			return
+		}
+	}
+}
+
+// requestStreamedRates requests filtered rates, that are not known yet, their events are streamed once fetched.
+// Rates are fetched one by one in background, number of fetches running for all streams is limited.
+func (app *application) requestStreamedRates(filter services.RateFilter, known []services.RateEvent) {
+	types := filter.Types
+	if len(types) == 0 {
+		types = []rate.RateType{rate.RateTypeOfficial}
+	}
+	type streamedRate struct {
+		pair     rate.CurrencyPair
+		rateType rate.RateType
+	}
+	var unknown []streamedRate
+	for _, pair := range filter.Pairs {
+		for _, rateType := range types {
+			isKnown := slices.ContainsFunc(known, func(event services.RateEvent) bool {
+				return event.Rate.Pair == pair && event.Rate.Type == rateType
+			})
+			// unsupported rates are never fetched, stream just does not receive them.
+			if !isKnown && app.rateService.Supports(pair, rateType) {
+				unknown = append(unknown, streamedRate{pair: pair, rateType: rateType})
+			}
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+
+	go func() {
+		for _, streamed := range unknown {
+			app.streamFetches <- struct{}{}
+			ctx, cancel := context.WithTimeout(context.Background(), ServerTimeout)
+			_, err := app.rateService.GetRate(ctx, streamed.pair, streamed.rateType)
+			cancel()
+			<-app.streamFetches
+			if err != nil {
+				log.Warn().Err(err).Stringer("pair", streamed.pair).Msg("Cannot get streamed rate")
+			}
+		}
+	}()
+}
+
+func (app *application) getRateHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := validator.New()
+
+	pairParam := query.Get("pair")
+	if pairParam == "" {
+		pairParam = rate.NewCurrencyPair(rate.DefaultBaseCurrency, rate.DefaultQuoteCurrency).String()
+	}
+	pair, err := rate.ParseCurrencyPair(pairParam)
+	v.Check(err == nil && validator.IsValidCurrencyCode(pair.Base) && validator.IsValidCurrencyCode(pair.Quote),
+		"pair", "must be in BASE-QUOTE format")
+
+	to := time.Now()
+	if toParam := query.Get("to"); toParam != "" {
+		to, err = time.Parse(time.RFC3339, toParam)
+		v.Check(err == nil, "to", "must be RFC 3339 timestamp")
+	}
+	from := to.Add(-DefaultHistoryPeriod)
+	if fromParam := query.Get("from"); fromParam != "" {
+		from, err = time.Parse(time.RFC3339, fromParam)
+		v.Check(err == nil, "from", "must be RFC 3339 timestamp")
+	}
+	rateType, err := parseRateType(query.Get("type"))
+	v.Check(err == nil, "type", "must be one of buy, sell, official, mid")
+
+	interval := DefaultHistoryInterval
+	if intervalParam := query.Get("interval"); intervalParam != "" {
+		interval, err = time.ParseDuration(intervalParam)
+		v.Check(err == nil && interval >= time.Second, "interval", "must be duration of at least one second")
+	}
+	if !v.IsValid() {
+		app.failedValidation(w, v)
+		return
+	}
+	v.Check(from.Before(to), "from", "must be before to")
+	v.Check(to.Sub(from)/interval <= MaxHistoryPoints, "interval", "too many points requested")
+	if !v.IsValid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	points, err := app.rateHistory.GetHistory(r.Context(), pair.Base, pair.Quote, string(rateType), from, to, interval)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.writeJSON(w, envelope{
+		"pair":     pair.String(),
+		"type":     rateType,
+		"from":     from,
+		"to":       to,
+		"interval": interval.String(),
+		"points":   app.newRatePointsResponse(points),
+	}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+	}
+}
+
+func (app *application) convert(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := validator.New()
+	pair, amount, at := parseConversion(query.Get("from"), query.Get("to"), query.Get("amount"), query.Get("date"), v)
+	if !v.IsValid() {
+		app.failedValidation(w, v)
+		return
+	}
+
+	conversion, err := app.converter.Convert(r.Context(), pair, amount, at)
+	if err != nil {
+		switch {
+		case errors.Is(err, rate.ErrUnsupportedPair) || errors.Is(err, rate.ErrInvalidCurrencyCode):
+			app.unsupportedPair(w)
+		case errors.Is(err, rate.ErrRateNotFound):
+			app.errorResponse(w, http.StatusNotFound, "rate_not_found", "no rate was recorded for the date", nil)
+		case errors.Is(err, rate.ErrProviderUnavailable):
+			log.Error().Err(err).Send()
+			app.providerUnavailable(w)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	response := envelope{
+		"from":   conversion.Pair.Base,
+		"to":     conversion.Pair.Quote,
+		"amount": json.Number(conversion.Amount.String()),
+		"result": money.JSONNumber(conversion.Result, money.AmountPrecision),
+		"rate":   app.formatRate(conversion.Rate),
+		"legs":   app.newConversionLegsResponse(conversion.Legs),
+	}
+	if conversion.Via != "" {
+		response["via"] = conversion.Via
+	}
+	if !at.IsZero() {
+		response["date"] = query.Get("date")
+	}
+	err = app.writeJSON(w, response, http.StatusOK)
 	if err != nil {
 		app.serverError(w, err)
 	}
 }
 
 func (app *application) subscribe(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	form, err := readForm(w, r)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.badRequest(w, err)
 		return
 	}
 
-	newEmail := r.PostForm.Get("email")
+	newEmail := form.Get("email")
 	v := validator.New()
 	v.Check(validator.IsValidEmail(newEmail), "email", "invalid email")
+	preferences := app.parsePreferences(form, v)
 	if !v.IsValid() {
-		app.clientError(w, http.StatusBadRequest)
+		app.failedValidation(w, v)
 		return
 	}
 
-	id, err := app.emailService.Create(newEmail)
+	err = app.createSubscription(newEmail, preferences)
 	if err != nil {
 		if errors.Is(err, repositories.ErrDuplicateEmail) {
-			app.clientError(w, http.StatusConflict)
+			app.errorResponse(w, http.StatusConflict, "duplicate_email", "email is already subscribed", nil)
+			return
+		}
+		if errors.Is(err, repositories.ErrRecentlyRequested) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(ConfirmationResendDelay.Seconds())))
+			app.errorResponse(w, http.StatusTooManyRequests, "confirmation_requested",
+				"confirmation link is sent recently, try again later", nil)
 			return
 		}
 		app.serverError(w, err)
-		return
 	}
-	s := fmt.Sprintf(`total_subscribers{success="%v"}`, err == nil)
-	metrics.GetOrCreateCounter(s).Inc()
+}
 
+// createSubscription creates pending subscription and sends confirmation email to its owner.
+func (app *application) createSubscription(email string, preferences repositories.Preferences) error {
+	// confirmation email is saved to outbox along with subscription, so it is sent even if broker is down now.
+	_, err := app.emailService.Create(email, preferences, app.newConfirmationMessage)
+	if err != nil {
+		return err
+	}
+	app.outbox.Notify()
+	return nil
+}
+
+// newConfirmationMessage asks mailer to send confirmation link, subscription stays pending
+// until owner of the email follows the link.
+func (app *application) newConfirmationMessage(id int, email string) (repositories.OutboxMessage, error) {
+	expiresAt := time.Now().Add(app.cfg.confirmationTTL)
+	token := app.tokens.Sign(ConfirmationTokenPurpose, strconv.Itoa(id), expiresAt)
+	msg := communication.Message[mailer.SendConfirmationEmailCommand]{
+		MessageHeader: communication.MessageHeader{Type: mailer.SendConfirmationEmail, Timestamp: time.Now()},
+		Payload: mailer.SendConfirmationEmailCommand{
+			Email:           email,
+			ConfirmationURL: app.cfg.baseURL + APIPrefix + "/subscribe/confirm?token=" + url.QueryEscape(token),
+			ExpiresAt:       expiresAt,
+		},
+	}
+	return repositories.NewOutboxMessage(mailer.RateEmailsQueue, msg)
+}
+
+// newCustomerMessage asks customers service to create customer of confirmed subscription.
+func newCustomerMessage(id int, email string) (repositories.OutboxMessage, error) {
 	msg := communication.Message[customers.CreateCustomerRequestPayload]{
 		MessageHeader: communication.MessageHeader{Type: customers.CreateCustomerRequest, Timestamp: time.Now()},
-		Payload:       customers.CreateCustomerRequestPayload{Email: newEmail, SubscriptionID: id},
+		Payload:       customers.CreateCustomerRequestPayload{Email: email, SubscriptionID: id},
 	}
-	err = app.customerProducer.SendMessage(msg, customers.CreateCustomerRequestQueue)
+	return repositories.NewOutboxMessage(customers.CreateCustomerRequestQueue, msg)
+}
+
+func (app *application) confirmSubscription(w http.ResponseWriter, r *http.Request) {
+	subject, err := app.tokens.Verify(ConfirmationTokenPurpose, r.URL.Query().Get("token"))
 	if err != nil {
-		log.Error().Err(err).Send()
+		if errors.Is(err, tokens.ErrExpiredToken) {
+			app.errorResponse(w, http.StatusGone, "expired_token", "confirmation link has expired", nil)
+			return
+		}
+		app.errorResponse(w, http.StatusBadRequest, "invalid_token", "invalid confirmation link", nil)
+		return
+	}
+	id, err := strconv.Atoi(subject)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	email, err := app.emailService.Confirm(id, newCustomerMessage)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrAlreadyConfirmed):
+			err = app.writeJSON(w, envelope{"status": "active"}, http.StatusOK)
+			if err != nil {
+				app.serverError(w, err)
+			}
+		case errors.Is(err, repositories.ErrEmailDoesNotExist):
+			// unconfirmed subscription may be already deleted by cleanup.
+			app.clientError(w, http.StatusNotFound)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+	metrics.GetOrCreateCounter(`total_subscribers{success="true"}`).Inc()
+	app.outbox.Notify()
+
+	err = app.writeJSON(w, envelope{"email": email, "status": "active"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
 	}
 }
 
+// unsubscribePage asks for confirmation, so links opened by email scanners do not unsubscribe anybody.
+func (app *application) unsubscribePage(w http.ResponseWriter, r *http.Request) {
+	email, err := app.unsubscribeLinks.Email(r.PathValue("token"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.renderUnsubscribePage(w, email, false)
+}
+
+// unsubscribe handles both unsubscribe page form and one-click unsubscription (RFC 8058) by mail clients.
 func (app *application) unsubscribe(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	email, err := app.unsubscribeLinks.Email(r.PathValue("token"))
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	email := r.PostForm.Get("email")
-	err = app.emailService.DeleteByEmail(email)
+	err = app.deleteSubscription(email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.renderUnsubscribePage(w, email, true)
+}
+
+// deleteSubscription deletes subscription of the email, repeated unsubscription is successful as well.
+func (app *application) deleteSubscription(email string) error {
+	err := app.emailService.DeleteByEmail(email)
+	if err != nil && !errors.Is(err, repositories.ErrEmailDoesNotExist) {
+		metrics.GetOrCreateCounter(`total_unsubscribers{success="false"}`).Inc()
+		return err
+	}
+	if err == nil {
+		metrics.GetOrCreateCounter(`total_unsubscribers{success="true"}`).Inc()
+	}
+	return nil
+}
+
+func (app *application) createAlert(w http.ResponseWriter, r *http.Request) {
+	form, err := readForm(w, r)
+	if err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	email := form.Get("email")
+	v := validator.New()
+	v.Check(validator.IsValidEmail(email), "email", "invalid email")
+
+	pairParam := form.Get("pair")
+	if pairParam == "" {
+		pairParam = rate.NewCurrencyPair(rate.DefaultBaseCurrency, rate.DefaultQuoteCurrency).String()
+	}
+	pair, err := rate.ParseCurrencyPair(pairParam)
+	v.Check(err == nil && validator.IsValidCurrencyCode(pair.Base) && validator.IsValidCurrencyCode(pair.Quote),
+		"pair", "must be in BASE-QUOTE format")
+	rateType, err := parseRateType(form.Get("type"))
+	v.Check(err == nil, "type", "must be one of buy, sell, official, mid")
+
+	// alert either on relative change in percents or on crossing of the level.
+	changeParam, levelParam := form.Get("change"), form.Get("level")
+	v.Check((changeParam == "") != (levelParam == ""), "change", "exactly one of change and level is required")
+	kind, thresholdParam := services.AlertKindChange, changeParam
+	if levelParam != "" {
+		kind, thresholdParam = services.AlertKindCrossing, levelParam
+	}
+	threshold, err := decimal.NewFromString(thresholdParam)
+	v.Check(err == nil && threshold.IsPositive(), kind, "must be positive number")
+	if !v.IsValid() {
+		app.failedValidation(w, v)
+		return
+	}
+	if !app.rateService.Supports(pair, rateType) {
+		app.unsupportedPair(w)
+		return
+	}
+
+	// alert stays pending until owner of the email confirms it, so nobody can make the service mail others.
+	rule := mailer.AlertRule{Pair: pair.String(), Type: string(rateType), Kind: kind, Threshold: threshold}
+	newMessage := func(id int, email string) (repositories.OutboxMessage, error) {
+		return app.newAlertConfirmationMessage(id, email, rule)
+	}
+	id, err := app.alertService.Create(r.Context(), email, pair, rateType, kind, threshold, newMessage)
 	if err != nil {
 		if errors.Is(err, repositories.ErrEmailDoesNotExist) {
+			app.errorResponse(w, http.StatusNotFound, "subscription_not_found", "email has no active subscription", nil)
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+	app.outbox.Notify()
+
+	// token is the only way to delete alert, it is sent in alert emails as well.
+	err = app.writeJSON(w, envelope{"id": id, "token": app.alertLinks.Token(id), "status": "pending"},
+		http.StatusCreated)
+	if err != nil {
+		app.serverError(w, err)
+	}
+}
+
+// newAlertConfirmationMessage asks mailer to send link, that confirms alert rule, to the subscriber.
+func (app *application) newAlertConfirmationMessage(
+	id int,
+	email string,
+	rule mailer.AlertRule,
+) (repositories.OutboxMessage, error) {
+	expiresAt := time.Now().Add(app.cfg.confirmationTTL)
+	token := app.tokens.Sign(AlertConfirmationTokenPurpose, strconv.Itoa(id), expiresAt)
+	msg := communication.Message[mailer.SendConfirmationEmailCommand]{
+		MessageHeader: communication.MessageHeader{Type: mailer.SendConfirmationEmail, Timestamp: time.Now()},
+		Payload: mailer.SendConfirmationEmailCommand{
+			Email:           email,
+			ConfirmationURL: app.cfg.baseURL + APIPrefix + "/alerts/confirm?token=" + url.QueryEscape(token),
+			ExpiresAt:       expiresAt,
+			Alert:           &rule,
+		},
+	}
+	return repositories.NewOutboxMessage(mailer.RateEmailsQueue, msg)
+}
+
+func (app *application) confirmAlert(w http.ResponseWriter, r *http.Request) {
+	subject, err := app.tokens.Verify(AlertConfirmationTokenPurpose, r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, tokens.ErrExpiredToken) {
+			app.errorResponse(w, http.StatusGone, "expired_token", "confirmation link has expired", nil)
+			return
+		}
+		app.errorResponse(w, http.StatusBadRequest, "invalid_token", "invalid confirmation link", nil)
+		return
+	}
+	id, err := strconv.Atoi(subject)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.alertService.Confirm(r.Context(), id)
+	if err != nil && !errors.Is(err, repositories.ErrAlertConfirmed) {
+		if errors.Is(err, repositories.ErrAlertDoesNotExist) {
+			// unconfirmed alert may be already deleted by cleanup.
 			app.clientError(w, http.StatusNotFound)
 			return
 		}
 		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, envelope{"id": id, "status": "active"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+	}
+}
+
+func (app *application) deleteAlert(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.authorizeAlert(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.alertService.Delete(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrAlertDoesNotExist) {
+			app.clientError(w, http.StatusNotFound)
+			return
+		}
+		app.serverError(w, err)
+	}
+}
+
+// deleteAlertPage asks for confirmation, so links opened by email scanners do not delete alerts.
+func (app *application) deleteAlertPage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.authorizeAlert(w, r); !ok {
+		return
+	}
+	app.renderDeleteAlertPage(w, false)
+}
+
+// deleteAlertForm handles delete alert page form, repeated deletion is successful as well.
+func (app *application) deleteAlertForm(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.authorizeAlert(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.alertService.Delete(r.Context(), id)
+	if err != nil && !errors.Is(err, repositories.ErrAlertDoesNotExist) {
+		app.serverError(w, err)
+		return
+	}
+	app.renderDeleteAlertPage(w, true)
+}
+
+func (app *application) getProviders(w http.ResponseWriter, _ *http.Request) {
+	err := app.writeJSON(w, envelope{"providers": app.rateService.Providers()}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+	}
+}
+
+func (app *application) openAPISpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(api.OpenAPISpec); err != nil {
+		log.Error().Err(err).Send()
 	}
-	s := fmt.Sprintf(`total_unsubscribers{success="%v"}`, err == nil)
-	metrics.GetOrCreateCounter(s).Inc()
 }
 
 func (app *application) metrics(w http.ResponseWriter, _ *http.Request) {
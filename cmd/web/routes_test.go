@@ -1,23 +1,37 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/fdemchenko/exchanger/internal/communication"
+	"github.com/fdemchenko/exchanger/internal/communication/customers"
+	"github.com/fdemchenko/exchanger/internal/communication/mailer"
+	"github.com/fdemchenko/exchanger/internal/health"
 	"github.com/fdemchenko/exchanger/internal/integration"
+	"github.com/fdemchenko/exchanger/internal/ratelimit"
 	"github.com/fdemchenko/exchanger/internal/repositories"
 	"github.com/fdemchenko/exchanger/internal/services"
 	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/tokens"
+	"github.com/fdemchenko/exchanger/internal/validator"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"golang.org/x/net/websocket"
 )
 
 type RateResponse struct {
@@ -26,6 +40,57 @@ type RateResponse struct {
 
 const EmailContentType = "application/x-www-form-urlencoded"
 
+// PublisherStub keeps published messages of every queue instead of publishing them.
+type PublisherStub struct {
+	mu       sync.Mutex
+	messages map[string][][]byte
+}
+
+func (ps *PublisherStub) Publish(_ context.Context, queue string, body []byte) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.messages == nil {
+		ps.messages = make(map[string][][]byte)
+	}
+	ps.messages[queue] = append(ps.messages[queue], body)
+	return nil
+}
+
+func (ps *PublisherStub) last(queue string, msg any) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	published := ps.messages[queue]
+	if len(published) == 0 {
+		return errors.New("nothing is published to " + queue)
+	}
+	return json.Unmarshal(published[len(published)-1], msg)
+}
+
+// RateServiceStub publishes requested rates to listener, as rate service does on fresh fetch.
+type RateServiceStub struct {
+	listener    rate.Listener
+	providers   []rate.ProviderStatus
+	unsupported []rate.CurrencyPair
+}
+
+func (rss *RateServiceStub) GetRate(
+	_ context.Context,
+	pair rate.CurrencyPair,
+	rateType rate.RateType,
+) (rate.Rate, error) {
+	fetched := rate.Rate{Pair: pair, Type: rateType, Value: decimal.NewFromInt(41), FetchedAt: time.Now()}
+	rss.listener(fetched)
+	return fetched, nil
+}
+
+func (rss *RateServiceStub) Supports(pair rate.CurrencyPair, _ rate.RateType) bool {
+	return !slices.Contains(rss.unsupported, pair)
+}
+
+func (rss *RateServiceStub) Providers() []rate.ProviderStatus {
+	return rss.providers
+}
+
 func TestRateEndpointIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test")
@@ -46,7 +111,7 @@ func TestRateEndpointIntegration(t *testing.T) {
 	ts := httptest.NewServer(app.routes())
 	defer ts.Close()
 
-	rs, err := ts.Client().Get(ts.URL + "/rate")
+	rs, err := ts.Client().Get(ts.URL + APIPrefix + "/rate")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,10 +133,487 @@ func TestRateEndpointIntegration(t *testing.T) {
 	assert.Greater(t, rateResponse.Rate, float32(0))
 }
 
+func TestUnsubscribe_ForgedToken(t *testing.T) {
+	signer := tokens.NewSigner([]byte("secret"))
+	app := application{unsubscribeLinks: services.NewUnsubscribeLinks(signer, APIPrefix)}
+	// token of another purpose must not unsubscribe anybody.
+	token := signer.Sign(ConfirmationTokenPurpose, "victim@mail.com", time.Time{})
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, APIPrefix+"/unsubscribe/"+token, nil)
+		app.routes().ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestParsePreferences(t *testing.T) {
+	form := url.Values{
+		"pairs":     {"usd-uah,EUR-UAH", "USD-UAH"},
+		"frequency": {services.FrequencyWeekly},
+		"time":      {"08:00"},
+		"timezone":  {"Europe/Kyiv"},
+	}
+	app := &application{
+		rateService: &RateServiceStub{unsupported: []rate.CurrencyPair{rate.NewCurrencyPair("USD", "XAU")}},
+	}
+	v := validator.New()
+	preferences := app.parsePreferences(form, v)
+	assert.True(t, v.IsValid())
+	assert.Equal(t, []string{"USD-UAH", "EUR-UAH"}, preferences.Pairs)
+	assert.Equal(t, services.FrequencyWeekly, preferences.Frequency)
+	assert.Equal(t, 8, preferences.DeliveryHour)
+	assert.Equal(t, "Europe/Kyiv", preferences.Timezone)
+
+	v = validator.New()
+	assert.Equal(t, services.DefaultPreferences(), app.parsePreferences(url.Values{}, v))
+	assert.True(t, v.IsValid())
+
+	for _, tc := range []struct{ field, value string }{
+		{field: "pairs", value: "USDUAH"},
+		{field: "frequency", value: "monthly"},
+		{field: "time", value: "08:30"},
+		{field: "timezone", value: "Europe/Atlantis"},
+	} {
+		v = validator.New()
+		app.parsePreferences(url.Values{tc.field: {tc.value}}, v)
+		assert.Contains(t, v.Errors, tc.field, tc.value)
+	}
+
+	// pairs are checked against rate service, so subscribers do not get emails without rates.
+	v = validator.New()
+	app.parsePreferences(url.Values{"pairs": {"EUR-UAH,USD-XAU"}}, v)
+	assert.Equal(t, map[string]string{"pairs": "USD-XAU is not supported"}, v.Errors)
+}
+
+func TestReadForm(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    url.Values
+		isValid     bool
+	}{
+		{name: "Form", contentType: EmailContentType, body: "email=a%40mail.com&pairs=USD-UAH&pairs=EUR-UAH",
+			expected: url.Values{"email": {"a@mail.com"}, "pairs": {"USD-UAH", "EUR-UAH"}}, isValid: true},
+		{name: "JSON", contentType: "application/json; charset=utf-8",
+			body:     `{"email": "a@mail.com", "pairs": ["USD-UAH", "EUR-UAH"], "change": 1.5, "timezone": null}`,
+			expected: url.Values{"email": {"a@mail.com"}, "pairs": {"USD-UAH", "EUR-UAH"}, "change": {"1.5"}},
+			isValid:  true},
+		{name: "Malformed JSON", contentType: "application/json", body: `{"email": `},
+		{name: "JSON array", contentType: "application/json", body: `["a@mail.com"]`},
+		{name: "Nested object", contentType: "application/json", body: `{"email": {"address": "a@mail.com"}}`},
+		{name: "Several objects", contentType: "application/json", body: `{} {}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/subscribe", strings.NewReader(tc.body))
+			request.Header.Set("Content-Type", tc.contentType)
+			form, err := readForm(httptest.NewRecorder(), request)
+			if !tc.isValid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, form)
+		})
+	}
+}
+
+func TestSubscribe_ValidationErrors(t *testing.T) {
+	app := application{}
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/subscribe",
+		strings.NewReader(`{"email": "not an email", "frequency": "monthly"}`))
+	request.Header.Set("Content-Type", "application/json")
+	app.routes().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var response struct {
+		Error errorBody `json:"error"`
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "validation_failed", response.Error.Code)
+	assert.Contains(t, response.Error.Fields, "email")
+	assert.Contains(t, response.Error.Fields, "frequency")
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodPost, "/subscribe", strings.NewReader(`{"email": `))
+	request.Header.Set("Content-Type", "application/json")
+	app.routes().ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"invalid_body"`)
+}
+
+// readEvent reads the next Server-Sent Event skipping retry field and comments.
+func readEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	event := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(event) > 0 {
+			return event
+		}
+		if field, value, found := strings.Cut(line, ": "); found && field != "" && field != "retry" {
+			event[field] = value
+		}
+	}
+}
+
+func TestRequestStreamedRates_SkipsUnsupportedRates(t *testing.T) {
+	fetched := make(chan rate.Rate, 2)
+	usd, xau := rate.NewCurrencyPair("USD", "UAH"), rate.NewCurrencyPair("USD", "XAU")
+	app := &application{
+		rateService: &RateServiceStub{
+			listener:    func(r rate.Rate) { fetched <- r },
+			unsupported: []rate.CurrencyPair{xau},
+		},
+		streamFetches: make(chan struct{}, 1),
+	}
+
+	app.requestStreamedRates(services.RateFilter{Pairs: []rate.CurrencyPair{xau, usd}}, nil)
+	select {
+	case r := <-fetched:
+		assert.Equal(t, usd, r.Pair)
+	case <-time.After(time.Second):
+		t.Fatal("rate is not requested")
+	}
+	select {
+	case r := <-fetched:
+		t.Fatalf("unexpected rate of %s is requested", r.Pair)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStreamRates(t *testing.T) {
+	broadcaster := services.NewRateBroadcaster(services.DefaultBroadcastHistorySize)
+	app := application{
+		rateService:   &RateServiceStub{listener: broadcaster.OnRateFetched},
+		rateStream:    broadcaster,
+		streamFetches: make(chan struct{}, MaxStreamFetches),
+	}
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+	defer broadcaster.Close()
+
+	resp, err := ts.Client().Get(ts.URL + APIPrefix + "/rate/stream?pairs=usd-uah")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+
+	// unknown rate of the filtered pair is requested once stream starts.
+	first := readEvent(t, reader)
+	assert.Equal(t, "rate", first["event"])
+	assert.Contains(t, first["data"], `"rate":41`)
+
+	usd, eur := rate.NewCurrencyPair("USD", "UAH"), rate.NewCurrencyPair("EUR", "UAH")
+	broadcaster.OnRateFetched(rate.Rate{Pair: eur, Type: rate.RateTypeOfficial, Value: decimal.NewFromInt(44)})
+	broadcaster.OnRateFetched(rate.Rate{Pair: usd, Type: rate.RateTypeOfficial, Value: decimal.NewFromInt(42)})
+	second := readEvent(t, reader)
+	assert.Contains(t, second["data"], `"rate":42`)
+	resp.Body.Close()
+
+	broadcaster.OnRateFetched(rate.Rate{Pair: usd, Type: rate.RateTypeOfficial, Value: decimal.NewFromInt(43)})
+	request, err := http.NewRequest(http.MethodGet, ts.URL+APIPrefix+"/rate/stream?pairs=USD-UAH", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Last-Event-ID", second["id"])
+	resp, err = ts.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	resumed := readEvent(t, bufio.NewReader(resp.Body))
+	assert.Contains(t, resumed["data"], `"rate":43`)
+}
+
+func TestWebSocket(t *testing.T) {
+	broadcaster := services.NewRateBroadcaster(services.DefaultBroadcastHistorySize)
+	app := &application{
+		cfg:           config{wsMaxConnections: 1},
+		rateService:   &RateServiceStub{listener: broadcaster.OnRateFetched},
+		rateStream:    broadcaster,
+		streamFetches: make(chan struct{}, MaxStreamFetches),
+	}
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+	defer broadcaster.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + APIPrefix + "/ws"
+	ws, err := websocket.Dial(wsURL, "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// only one connection is allowed.
+	_, err = websocket.Dial(wsURL, "", ts.URL)
+	assert.Error(t, err)
+
+	sendWSRequest(t, ws, `{"action": "subscribe", "pairs": ["usd-uah", "EUR-UAH"]}`)
+	assert.Equal(t, []any{"USD-UAH", "EUR-UAH"}, readWSMessage(t, ws, "subscriptions")["pairs"])
+	// unknown rates of subscribed pairs are requested once they are subscribed.
+	first, second := readWSMessage(t, ws, "rate"), readWSMessage(t, ws, "rate")
+	assert.ElementsMatch(t, []any{"USD", "EUR"}, []any{
+		first["rate"].(map[string]any)["base"], second["rate"].(map[string]any)["base"],
+	})
+
+	sendWSRequest(t, ws, `{"action": "unsubscribe", "pairs": ["EUR-UAH"]}`)
+	assert.Equal(t, []any{"USD-UAH"}, readWSMessage(t, ws, "subscriptions")["pairs"])
+	usd, eur := rate.NewCurrencyPair("USD", "UAH"), rate.NewCurrencyPair("EUR", "UAH")
+	broadcaster.OnRateFetched(rate.Rate{Pair: eur, Type: rate.RateTypeOfficial, Value: decimal.NewFromInt(44)})
+	broadcaster.OnRateFetched(rate.Rate{Pair: usd, Type: rate.RateTypeOfficial, Value: decimal.NewFromInt(42)})
+	tick := readWSMessage(t, ws, "rate")["rate"].(map[string]any)
+	assert.Equal(t, "USD", tick["base"])
+	assert.EqualValues(t, 42, tick["rate"])
+
+	sendWSRequest(t, ws, `{"action": "subscribe", "pairs": ["USD"]}`)
+	assert.Equal(t, "validation_failed", readWSMessage(t, ws, "error")["error"].(map[string]any)["code"])
+	sendWSRequest(t, ws, `not json`)
+	assert.Equal(t, "invalid_body", readWSMessage(t, ws, "error")["error"].(map[string]any)["code"])
+}
+
+func sendWSRequest(t *testing.T, ws *websocket.Conn, request string) {
+	t.Helper()
+	if err := websocket.Message.Send(ws, request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readWSMessage reads messages skipping heartbeats and checks type of the first other one.
+func readWSMessage(t *testing.T, ws *websocket.Conn, messageType string) map[string]any {
+	t.Helper()
+	if err := ws.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var message map[string]any
+		if err := websocket.JSON.Receive(ws, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message["type"] != "heartbeat" {
+			assert.Equal(t, messageType, message["type"])
+			return message
+		}
+	}
+}
+
+func TestUnsubscribe_Page(t *testing.T) {
+	links := services.NewUnsubscribeLinks(tokens.NewSigner([]byte("secret")), APIPrefix)
+	app := application{unsubscribeLinks: links}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, links.URL("someone@mail.com"), nil)
+	app.routes().ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "someone@mail.com")
+	assert.Contains(t, recorder.Body.String(), `<form method="post">`)
+}
+
+func TestReadiness(t *testing.T) {
+	open := rate.ProviderStatus{Name: "nbu", State: rate.BreakerOpen.String()}
+	rateService := &RateServiceStub{providers: []rate.ProviderStatus{open}}
+	app := &application{rateService: rateService, limiter: ratelimit.New(), health: health.NewChecker(time.Second)}
+	app.health.Add("rate_providers", app.checkRateProviders)
+	handler := app.routes()
+
+	probe := func(path string) *http.Response {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Result()
+	}
+
+	// probes are neither rate limited nor counted as API requests.
+	liveness := probe("/healthz")
+	assert.Equal(t, http.StatusOK, liveness.StatusCode)
+	assert.Empty(t, liveness.Header.Get("X-RateLimit-Limit"))
+
+	var report health.Report
+	readiness := probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, readiness.StatusCode)
+	if err := json.NewDecoder(readiness.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, health.StatusUnavailable, report.Checks["rate_providers"].Status)
+
+	closed := rate.ProviderStatus{Name: "fawaz", State: rate.BreakerClosed.String()}
+	rateService.providers = append(rateService.providers, closed)
+	assert.Equal(t, http.StatusOK, probe("/readyz").StatusCode)
+}
+
+type AlertServiceStub struct {
+	messages  []repositories.OutboxMessage
+	confirmed []int
+	deleted   []int
+}
+
+func (ass *AlertServiceStub) Create(
+	_ context.Context,
+	email string,
+	_ rate.CurrencyPair,
+	_ rate.RateType,
+	_ string,
+	_ decimal.Decimal,
+	newMessage repositories.OutboxMessageFunc,
+) (int, error) {
+	id := len(ass.messages) + 1
+	msg, err := newMessage(id, email)
+	if err != nil {
+		return 0, err
+	}
+	ass.messages = append(ass.messages, msg)
+	return id, nil
+}
+
+func (ass *AlertServiceStub) Confirm(_ context.Context, id int) error {
+	ass.confirmed = append(ass.confirmed, id)
+	return nil
+}
+
+func (ass *AlertServiceStub) Delete(_ context.Context, id int) error {
+	ass.deleted = append(ass.deleted, id)
+	return nil
+}
+
+func TestDeleteAlert_RequiresAlertToken(t *testing.T) {
+	alertService := &AlertServiceStub{}
+	links := services.NewAlertLinks(tokens.NewSigner([]byte("secret")), APIPrefix)
+	app := &application{alertService: alertService, alertLinks: links}
+	handler := app.routes()
+	deleteAlert := func(method, target string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, deleteAlert(http.MethodDelete, APIPrefix+"/alerts/1"))
+	// token of one alert does not delete another one.
+	assert.Equal(t, http.StatusBadRequest,
+		deleteAlert(http.MethodDelete, APIPrefix+"/alerts/2?token="+url.QueryEscape(links.Token(1))))
+	assert.Empty(t, alertService.deleted)
+
+	assert.Equal(t, http.StatusOK,
+		deleteAlert(http.MethodDelete, APIPrefix+"/alerts/1?token="+url.QueryEscape(links.Token(1))))
+	// page linked from emails deletes alert only after form is submitted.
+	assert.Equal(t, http.StatusOK, deleteAlert(http.MethodGet, links.DeleteURL(2)))
+	assert.Equal(t, []int{1}, alertService.deleted)
+	assert.Equal(t, http.StatusOK, deleteAlert(http.MethodPost, links.DeleteURL(2)))
+	assert.Equal(t, []int{1, 2}, alertService.deleted)
+}
+
+func TestCreateAlert_PendingUntilConfirmed(t *testing.T) {
+	alertService := &AlertServiceStub{}
+	signer := tokens.NewSigner([]byte("secret"))
+	app := &application{
+		rateService:  &RateServiceStub{unsupported: []rate.CurrencyPair{rate.NewCurrencyPair("USD", "XAU")}},
+		alertService: alertService,
+		alertLinks:   services.NewAlertLinks(signer, APIPrefix),
+		tokens:       signer,
+		outbox:       services.NewOutboxRelay(nil, nil),
+	}
+	app.cfg.confirmationTTL = DefaultConfirmationTTL
+	handler := app.routes()
+	createAlert := func(pair string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {"trader@mail.com"}, "pair": {pair}, "type": {"buy"}, "level": {"42"}}
+		request := httptest.NewRequest(http.MethodPost, APIPrefix+"/alerts", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", EmailContentType)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// alert on pair nobody provides rate of would never fire.
+	recorder := createAlert("USD-XAU")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"unsupported_pair"`)
+	assert.Empty(t, alertService.messages)
+
+	recorder = createAlert("USD-UAH")
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"status":"pending"`)
+	assert.Empty(t, alertService.confirmed)
+
+	// owner of the email gets link, that confirms the alert.
+	var msg communication.Message[mailer.SendConfirmationEmailCommand]
+	if !assert.Len(t, alertService.messages, 1) {
+		return
+	}
+	assert.Equal(t, mailer.RateEmailsQueue, alertService.messages[0].Queue)
+	assert.NoError(t, json.Unmarshal(alertService.messages[0].Payload, &msg))
+	assert.Equal(t, "trader@mail.com", msg.Payload.Email)
+	assert.Equal(t, &mailer.AlertRule{Pair: "USD-UAH", Type: "buy", Kind: services.AlertKindCrossing,
+		Threshold: decimal.NewFromInt(42)}, msg.Payload.Alert)
+
+	// subscription confirmation link does not confirm alert.
+	recorder = httptest.NewRecorder()
+	subscriptionToken := signer.Sign(ConfirmationTokenPurpose, "1", time.Now().Add(time.Hour))
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet,
+		APIPrefix+"/alerts/confirm?token="+url.QueryEscape(subscriptionToken), nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, msg.Payload.ConfirmationURL, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []int{1}, alertService.confirmed)
+}
+
+func TestProviders_ServedOnInternalRoutesOnly(t *testing.T) {
+	rateService := &RateServiceStub{providers: []rate.ProviderStatus{{Name: "nbu", State: "closed"}}}
+	app := &application{rateService: rateService}
+
+	for _, path := range []string{"/admin/providers", APIPrefix + "/admin/providers"} {
+		recorder := httptest.NewRecorder()
+		app.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, recorder.Code, path)
+	}
+
+	recorder := httptest.NewRecorder()
+	app.internalRoutes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/providers", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"nbu"`)
+}
+
+func TestMetrics_ServedOnInternalRoutesOnly(t *testing.T) {
+	signer := tokens.NewSigner([]byte("secret"))
+	app := &application{unsubscribeLinks: services.NewUnsubscribeLinks(signer, APIPrefix)}
+
+	recorder := httptest.NewRecorder()
+	app.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	recorder = httptest.NewRecorder()
+	app.routes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, app.unsubscribeLinks.URL("victim@mail.com"), nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	app.internalRoutes().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	// requests are counted by route pattern, so unsubscribe tokens are not exposed.
+	body := recorder.Body.String()
+	assert.Contains(t, body, `requests_total{method="GET", pattern="GET /api/v1/unsubscribe/{token}", status="200"}`)
+	assert.Contains(t, body, `requests_total{method="GET", pattern="unmatched", status="404"}`)
+	assert.NotContains(t, body, "/unsubscribe/"+strings.TrimPrefix(app.unsubscribeLinks.URL("victim@mail.com"),
+		APIPrefix+"/unsubscribe/"))
+}
+
 type SubscribeEndpointTestSuite struct {
 	suite.Suite
-	container  *postgres.PostgresContainer
-	testServer *httptest.Server
+	container        *postgres.PostgresContainer
+	testServer       *httptest.Server
+	outbox           *services.OutboxRelay
+	publisher        *PublisherStub
+	unsubscribeLinks *services.UnsubscribeLinks
+	emailService     EmailService
 }
 
 func (sets *SubscribeEndpointTestSuite) SetupSuite() {
@@ -95,11 +637,55 @@ func (sets *SubscribeEndpointTestSuite) SetupSuite() {
 	postgresRepo := &repositories.PostgresSubscriptionRepository{DB: db}
 	emailService := services.NewSubscriptionService(postgresRepo)
 
+	sets.publisher = &PublisherStub{}
+	sets.outbox = services.NewOutboxRelay(&repositories.PostgresOutboxRepository{DB: db}, sets.publisher)
+	signer := tokens.NewSigner([]byte("secret"))
 	app := application{
 		emailService: emailService,
+		rateService:  &RateServiceStub{},
+		outbox:       sets.outbox,
+		tokens:       signer,
 	}
+	app.cfg.confirmationTTL = DefaultConfirmationTTL
 	ts := httptest.NewServer(app.routes())
 	sets.testServer = ts
+	app.cfg.baseURL = ts.URL
+	app.unsubscribeLinks = services.NewUnsubscribeLinks(signer, ts.URL+APIPrefix)
+	sets.unsubscribeLinks = app.unsubscribeLinks
+	sets.emailService = emailService
+}
+
+func (sets *SubscribeEndpointTestSuite) subscribe(email string) int {
+	data := url.Values{}
+	data.Set("email", email)
+	resp, err := sets.testServer.Client().Post(sets.testServer.URL+APIPrefix+"/subscribe", EmailContentType,
+		strings.NewReader(data.Encode()))
+	if err != nil {
+		sets.T().Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// lastMessage relays messages saved to outbox and decodes the last one published to the queue.
+func (sets *SubscribeEndpointTestSuite) lastMessage(queue string, msg any) {
+	if err := sets.outbox.Relay(context.Background()); err != nil {
+		sets.T().Fatal(err)
+	}
+	if err := sets.publisher.last(queue, msg); err != nil {
+		sets.T().Fatal(err)
+	}
+}
+
+func (sets *SubscribeEndpointTestSuite) confirmLastSubscription() int {
+	var msg communication.Message[mailer.SendConfirmationEmailCommand]
+	sets.lastMessage(mailer.RateEmailsQueue, &msg)
+	resp, err := sets.testServer.Client().Get(msg.Payload.ConfirmationURL)
+	if err != nil {
+		sets.T().Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
 }
 
 func (sets *SubscribeEndpointTestSuite) TestSubscribe_Success() {
@@ -108,7 +694,7 @@ func (sets *SubscribeEndpointTestSuite) TestSubscribe_Success() {
 	data := url.Values{}
 	data.Set("email", "someemail@gmail.com")
 	encodedData := data.Encode()
-	resp, err := client.Post(sets.testServer.URL+"/subscribe", EmailContentType, strings.NewReader(encodedData))
+	resp, err := client.Post(sets.testServer.URL+APIPrefix+"/subscribe", EmailContentType, strings.NewReader(encodedData))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -117,13 +703,40 @@ func (sets *SubscribeEndpointTestSuite) TestSubscribe_Success() {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func (sets *SubscribeEndpointTestSuite) TestSubscribe_JSON() {
+	t := sets.T()
+	body := `{"email": "json@gmail.com", "pairs": ["EUR-UAH"], "frequency": "hourly"}`
+	resp, err := sets.testServer.Client().Post(sets.testServer.URL+APIPrefix+"/subscribe", "application/json",
+		strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = sets.testServer.Client().Post(sets.testServer.URL+APIPrefix+"/subscribe", "application/json",
+		strings.NewReader(`{"email": "json@gmail.com", "pairs": "EUR"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var response struct {
+		Error errorBody `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, "validation_failed", response.Error.Code)
+	assert.Contains(t, response.Error.Fields, "pairs")
+}
+
 func (sets *SubscribeEndpointTestSuite) TestSubscribe_InvalidEmail() {
 	t := sets.T()
 	client := sets.testServer.Client()
 	data := url.Values{}
 	data.Set("email", "some^!invalid@@gmail_com")
 	encodedData := data.Encode()
-	resp, err := client.Post(sets.testServer.URL+"/subscribe", EmailContentType, strings.NewReader(encodedData))
+	resp, err := client.Post(sets.testServer.URL+APIPrefix+"/subscribe", EmailContentType, strings.NewReader(encodedData))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -138,14 +751,15 @@ func (sets *SubscribeEndpointTestSuite) TestSubscribe_DuplicateEmail() {
 	data := url.Values{}
 	data.Set("email", "mail@mail.com")
 	encodedData := data.Encode()
-	resp, err := client.Post(sets.testServer.URL+"/subscribe", EmailContentType, strings.NewReader(encodedData))
+	resp, err := client.Post(sets.testServer.URL+APIPrefix+"/subscribe", EmailContentType, strings.NewReader(encodedData))
 	if err != nil {
 		t.Fatal(err)
 	}
 	resp.Body.Close()
 
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
-	resp, err = client.Post(sets.testServer.URL+"/subscribe", EmailContentType, strings.NewReader(encodedData))
+	assert.Equal(t, http.StatusOK, sets.confirmLastSubscription())
+	resp, err = client.Post(sets.testServer.URL+APIPrefix+"/subscribe", EmailContentType, strings.NewReader(encodedData))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -153,6 +767,61 @@ func (sets *SubscribeEndpointTestSuite) TestSubscribe_DuplicateEmail() {
 	assert.Equal(t, http.StatusConflict, resp.StatusCode)
 }
 
+func (sets *SubscribeEndpointTestSuite) TestSubscribe_PendingCanBeRequestedAgain() {
+	t := sets.T()
+	assert.Equal(t, http.StatusOK, sets.subscribe("pending@mail.com"))
+	assert.Equal(t, http.StatusOK, sets.subscribe("pending@mail.com"))
+	assert.Equal(t, http.StatusOK, sets.confirmLastSubscription())
+	// confirmation link may be opened several times.
+	assert.Equal(t, http.StatusOK, sets.confirmLastSubscription())
+}
+
+func (sets *SubscribeEndpointTestSuite) TestConfirm_RequestsCustomerCreation() {
+	t := sets.T()
+	assert.Equal(t, http.StatusOK, sets.subscribe("Customer@mail.com"))
+	assert.Equal(t, http.StatusOK, sets.confirmLastSubscription())
+
+	var msg communication.Message[customers.CreateCustomerRequestPayload]
+	sets.lastMessage(customers.CreateCustomerRequestQueue, &msg)
+	assert.Equal(t, customers.CreateCustomerRequest, msg.Type)
+	assert.Equal(t, "customer@mail.com", msg.Payload.Email)
+
+	// published messages are not relayed again.
+	if err := sets.outbox.Relay(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, sets.publisher.messages[customers.CreateCustomerRequestQueue], 1)
+}
+
+func (sets *SubscribeEndpointTestSuite) TestUnsubscribe_OneClick() {
+	t := sets.T()
+	assert.Equal(t, http.StatusOK, sets.subscribe("leaving@mail.com"))
+	assert.Equal(t, http.StatusOK, sets.confirmLastSubscription())
+
+	data := url.Values{}
+	data.Set("List-Unsubscribe", "One-Click")
+	resp, err := sets.testServer.Client().Post(sets.unsubscribeLinks.URL("leaving@mail.com"), EmailContentType,
+		strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	emails, err := sets.emailService.GetAll()
+	assert.NoError(t, err)
+	assert.NotContains(t, emails, "leaving@mail.com")
+}
+
+func (sets *SubscribeEndpointTestSuite) TestConfirm_InvalidToken() {
+	resp, err := sets.testServer.Client().Get(sets.testServer.URL + "/subscribe/confirm?token=forged")
+	if err != nil {
+		sets.T().Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(sets.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
 func (sets *SubscribeEndpointTestSuite) TearDownTest() {
 	err := sets.container.Restore(context.Background())
 	if err != nil {
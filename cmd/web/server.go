@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,11 +12,40 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
 )
 
 const ServerShutdownTimeout = 5 * time.Second
 
-func (app *application) serveHTTP() error {
+// serve runs HTTP, internal HTTP and gRPC servers until termination signal, failure of gRPC or internal
+// server stops all of them.
+func (app *application) serve() error {
+	grpcListener, err := net.Listen("tcp", app.cfg.grpcAddr)
+	if err != nil {
+		return err
+	}
+	grpcServer := app.newGRPCServer()
+	serverErrors := make(chan error, 2)
+	go func() {
+		log.Info().Str("address", app.cfg.grpcAddr).Msg("gRPC server started")
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			serverErrors <- fmt.Errorf("gRPC server: %w", err)
+		}
+	}()
+
+	internalServer := http.Server{
+		Handler:           app.internalRoutes(),
+		Addr:              app.cfg.internalAddr,
+		WriteTimeout:      ServerTimeout,
+		ReadHeaderTimeout: ServerTimeout,
+	}
+	go func() {
+		log.Info().Str("address", app.cfg.internalAddr).Msg("Internal server started")
+		if err := internalServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serverErrors <- fmt.Errorf("internal server: %w", err)
+		}
+	}()
+
 	server := http.Server{
 		Handler:           app.routes(),
 		Addr:              app.cfg.addr,
@@ -28,20 +59,46 @@ func (app *application) serveHTTP() error {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
 
-		quitSignal := <-quit
-
-		log.Info().Str("signal", quitSignal.String()).Msg("app terminated")
+		var serverErr error
+		select {
+		case quitSignal := <-quit:
+			log.Info().Str("signal", quitSignal.String()).Msg("app terminated")
+		case serverErr = <-serverErrors:
+			log.Error().Err(serverErr).Msg("Server failed")
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), ServerShutdownTimeout)
 		defer cancel()
 
-		shutdownErrors <- server.Shutdown(ctx)
+		// streams never end on their own, so they are closed before servers wait for active connections and calls.
+		if app.rateStream != nil {
+			app.rateStream.Close()
+		}
+		shutdownErrors <- errors.Join(serverErr, server.Shutdown(ctx), stopGRPC(ctx, grpcServer),
+			internalServer.Shutdown(ctx))
 	}()
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
 	return <-shutdownErrors
 }
+
+// stopGRPC waits for active calls to finish, calls are cancelled once ctx is done.
+func stopGRPC(ctx context.Context, server *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/validator"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	WSActionSubscribe   = "subscribe"
+	WSActionUnsubscribe = "unsubscribe"
+
+	wsMaxMessageSize = 4096
+	// wsMaxPendingMessages is a number of replies client may not read before connection is closed,
+	// rate ticks are not limited, because only the latest tick of every pair is kept.
+	wsMaxPendingMessages = 64
+)
+
+// wsRequest is a message client sends to change subscribed pairs.
+type wsRequest struct {
+	Action string   `json:"action"`
+	Pairs  []string `json:"pairs"`
+}
+
+// serveWebSocket upgrades connection, if number of connections is below configured limit.
+func (app *application) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		app.errorResponse(w, http.StatusUpgradeRequired, "upgrade_required", "websocket upgrade is required", nil)
+		return
+	}
+	if app.wsConnections.Add(1) > int64(app.cfg.wsMaxConnections) {
+		app.wsConnections.Add(-1)
+		metrics.GetOrCreateCounter("ws_connections_rejected_total").Inc()
+		app.errorResponse(w, http.StatusServiceUnavailable, "too_many_connections",
+			"too many websocket connections, try again later", nil)
+		return
+	}
+	defer app.wsConnections.Add(-1)
+
+	// rates are public and connections carry no credentials, so any origin is accepted.
+	server := websocket.Server{Handler: app.handleWebSocket}
+	server.ServeHTTP(w, r)
+}
+
+func (app *application) handleWebSocket(ws *websocket.Conn) {
+	ws.MaxPayloadBytes = wsMaxMessageSize
+	metrics.GetOrCreateGauge("ws_connections_active", nil).Inc()
+	defer metrics.GetOrCreateGauge("ws_connections_active", nil).Dec()
+
+	conn := &wsConnection{
+		app:   app,
+		ws:    ws,
+		ticks: make(map[wsTickKey]services.RateEvent),
+		wake:  make(chan struct{}, 1),
+	}
+	conn.run()
+}
+
+type wsTickKey struct {
+	pair     rate.CurrencyPair
+	rateType rate.RateType
+}
+
+// wsConnection receives rate changes from rate stream and sends them to client, slow client receives
+// only the latest tick of every pair instead of every change.
+type wsConnection struct {
+	app *application
+	ws  *websocket.Conn
+
+	mu      sync.Mutex
+	replies []envelope
+	ticks   map[wsTickKey]services.RateEvent
+	wake    chan struct{}
+}
+
+func (c *wsConnection) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// closing connection stops reader, that waits for the next request.
+	defer c.ws.Close()
+
+	requests := make(chan wsRequest)
+	go c.readRequests(ctx, cancel, requests)
+	go c.writeMessages(ctx, cancel)
+
+	var subscription *services.RateSubscription
+	var events <-chan services.RateEvent
+	var pairs []rate.CurrencyPair
+	defer func() {
+		if subscription != nil {
+			c.app.rateStream.Unsubscribe(subscription)
+		}
+	}()
+
+	heartbeat := time.NewTicker(StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case request := <-requests:
+			updated, ok := c.updatedPairs(pairs, request)
+			if !ok {
+				continue
+			}
+			pairs = updated
+			filter := services.RateFilter{Pairs: pairs}
+			var known []services.RateEvent
+			switch {
+			case len(pairs) == 0 && subscription != nil:
+				c.app.rateStream.Unsubscribe(subscription)
+				subscription, events = nil, nil
+			case len(pairs) > 0 && subscription == nil:
+				subscription, known = c.app.rateStream.Subscribe(filter, 0)
+				events = subscription.Events()
+			case len(pairs) > 0:
+				known = c.app.rateStream.UpdateFilter(subscription, filter)
+			}
+			c.reply(envelope{"type": "subscriptions", "pairs": pairsToStrings(pairs)})
+			for _, event := range known {
+				c.pushTick(event)
+			}
+			c.app.requestStreamedRates(filter, known)
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			c.pushTick(event)
+		case <-heartbeat.C:
+			c.reply(envelope{"type": "heartbeat"})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// updatedPairs applies request to subscribed pairs, invalid request is replied with error.
+func (c *wsConnection) updatedPairs(pairs []rate.CurrencyPair, request wsRequest) ([]rate.CurrencyPair, bool) {
+	v := validator.New()
+	v.Check(request.Action == WSActionSubscribe || request.Action == WSActionUnsubscribe,
+		"action", "must be subscribe or unsubscribe")
+	v.Check(len(request.Pairs) > 0, "pairs", "must not be empty")
+	requested := parsePairs(request.Pairs, v, MaxStreamPairs)
+
+	updated := slices.Clone(pairs)
+	if request.Action == WSActionSubscribe {
+		for _, pair := range requested {
+			if !slices.Contains(updated, pair) {
+				updated = append(updated, pair)
+			}
+		}
+	} else {
+		updated = slices.DeleteFunc(updated, func(pair rate.CurrencyPair) bool {
+			return slices.Contains(requested, pair)
+		})
+	}
+	v.Check(len(updated) <= MaxStreamPairs, "pairs", "too many pairs")
+	if !v.IsValid() {
+		c.replyError("validation_failed", "request contains invalid fields", v.Errors)
+		return nil, false
+	}
+	return updated, true
+}
+
+func (c *wsConnection) readRequests(ctx context.Context, cancel context.CancelFunc, requests chan<- wsRequest) {
+	defer cancel()
+	for {
+		var request wsRequest
+		err := websocket.JSON.Receive(c.ws, &request)
+		if err != nil {
+			var syntaxError *json.SyntaxError
+			var typeError *json.UnmarshalTypeError
+			if errors.As(err, &syntaxError) || errors.As(err, &typeError) {
+				c.replyError("invalid_body", "message must be JSON object with action and pairs", nil)
+				continue
+			}
+			log.Debug().Err(err).Msg("WebSocket connection is closed")
+			return
+		}
+		select {
+		case requests <- request:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *wsConnection) writeMessages(ctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		select {
+		case <-c.wake:
+		case <-ctx.Done():
+			return
+		}
+		messages, ok := c.drain()
+		if !ok {
+			log.Warn().Msg("WebSocket client does not read replies, closing connection")
+			return
+		}
+		for _, message := range messages {
+			err := c.ws.SetWriteDeadline(time.Now().Add(ServerTimeout))
+			if err == nil {
+				err = websocket.JSON.Send(c.ws, message)
+			}
+			if err != nil {
+				log.Debug().Err(err).Msg("WebSocket connection is closed")
+				return
+			}
+		}
+	}
+}
+
+// drain returns queued replies followed by ticks ordered by event IDs.
+func (c *wsConnection) drain() ([]envelope, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.replies) > wsMaxPendingMessages {
+		return nil, false
+	}
+
+	messages := c.replies
+	c.replies = nil
+	events := make([]services.RateEvent, 0, len(c.ticks))
+	for key, event := range c.ticks {
+		events = append(events, event)
+		delete(c.ticks, key)
+	}
+	slices.SortFunc(events, func(a, b services.RateEvent) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	for _, event := range events {
+		messages = append(messages, envelope{"type": "rate", "id": event.ID, "rate": c.app.newRateResponse(event.Rate)})
+	}
+	return messages, true
+}
+
+func (c *wsConnection) reply(message envelope) {
+	c.mu.Lock()
+	c.replies = append(c.replies, message)
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *wsConnection) replyError(code, message string, fields map[string]string) {
+	c.reply(envelope{"type": "error", "error": errorBody{Code: code, Message: message, Fields: fields}})
+}
+
+// pushTick queues rate change replacing the previous one of the pair, that is not sent yet.
+func (c *wsConnection) pushTick(event services.RateEvent) {
+	key := wsTickKey{pair: event.Rate.Pair, rateType: event.Rate.Type}
+	c.mu.Lock()
+	if _, ok := c.ticks[key]; ok {
+		metrics.GetOrCreateCounter("ws_ticks_conflated_total").Inc()
+	}
+	c.ticks[key] = event
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *wsConnection) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func pairsToStrings(pairs []rate.CurrencyPair) []string {
+	strs := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		strs = append(strs, pair.String())
+	}
+	return strs
+}
@@ -51,11 +51,8 @@ func (c *Cache[K, V]) Get(key K) (V, bool) {
 	defer c.mu.RUnlock()
 
 	item, exists := c.items[key]
-	if !exists {
-		return item.value, false
-	}
-	if item.hasExpired() {
-		delete(c.items, key)
+	if !exists || item.hasExpired() {
+		// expired items are removed by cleanup goroutine, as map cannot be modified under read lock.
 		return item.value, false
 	}
 
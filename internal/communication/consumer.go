@@ -0,0 +1,253 @@
+package communication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultConsumerWorkers  = 1
+	DefaultConsumerPrefetch = 16
+
+	// RetryCountHeader holds number of times message has failed.
+	RetryCountHeader = "x-retry-count"
+	// ErrorHeader holds error of the last attempt of dead-lettered message.
+	ErrorHeader = "x-error"
+
+	publishTimeout = rabbitmq.DefaultConfirmTimeout
+)
+
+var (
+	ErrUnknownMessageType = errors.New("unknown message type")
+	ErrMalformedMessage   = errors.New("malformed message")
+	// ErrPermanent is wrapped by handlers into errors, which do not go away on retry,
+	// so message is dead-lettered at once.
+	ErrPermanent = errors.New("permanent failure")
+)
+
+// Consumer decodes messages of the channel queue and passes them to handlers registered for their types.
+// Message, which handler fails, is delivered again after delay, that grows with every attempt,
+// and is dead-lettered once retries are exhausted or error is permanent.
+type Consumer struct {
+	channel  *rabbitmq.Channel
+	handlers map[MessageType]func(ctx context.Context, body []byte) error
+	workers  int
+	prefetch int
+
+	consumer *rabbitmq.Consumer
+	wg       sync.WaitGroup
+}
+
+type ConsumerOption func(*Consumer)
+
+// WithWorkers sets number of messages handled concurrently, handlers have to be safe for concurrent use.
+func WithWorkers(workers int) ConsumerOption {
+	return func(c *Consumer) {
+		c.workers = workers
+	}
+}
+
+// WithPrefetch sets number of messages broker sends before previous ones are acknowledged.
+func WithPrefetch(prefetch int) ConsumerOption {
+	return func(c *Consumer) {
+		c.prefetch = prefetch
+	}
+}
+
+func NewConsumer(channel *rabbitmq.Channel, opts ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		channel:  channel,
+		handlers: make(map[MessageType]func(context.Context, []byte) error),
+		workers:  DefaultConsumerWorkers,
+		prefetch: DefaultConsumerPrefetch,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Handle registers handler of messages of the type, payload of message is decoded into T.
+// Handlers have to be registered before consumer is started.
+func Handle[T any](c *Consumer, messageType MessageType, handler func(ctx context.Context, msg Message[T]) error) {
+	c.handlers[messageType] = func(ctx context.Context, body []byte) error {
+		var msg Message[T]
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+		}
+		return handler(ctx, msg)
+	}
+}
+
+// Start starts consuming messages until ctx is cancelled, messages being handled at that moment
+// are finished before Wait returns.
+func (c *Consumer) Start(ctx context.Context) error {
+	// failed message is acknowledged once broker confirms its copy for retry or dead-letter queue.
+	if err := c.channel.Confirm(); err != nil {
+		return err
+	}
+	if err := c.channel.Qos(c.prefetch); err != nil {
+		return err
+	}
+
+	deliveries := make(chan amqp.Delivery)
+	consumer, err := c.channel.Consume(func(delivery amqp.Delivery) {
+		select {
+		case deliveries <- delivery:
+		case <-ctx.Done():
+			// message is returned to the queue for another consumer.
+			if err := delivery.Nack(false, true); err != nil {
+				log.Error().Err(err).Send()
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	c.consumer = consumer
+
+	for range c.workers {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			for {
+				select {
+				case delivery := <-deliveries:
+					c.handle(context.WithoutCancel(ctx), delivery)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		if err := c.channel.Cancel(consumer); err != nil {
+			log.Error().Err(err).Msg("Cannot cancel consumer")
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until consumer is stopped and messages being handled are finished.
+func (c *Consumer) Wait() {
+	c.wg.Wait()
+}
+
+// Check reports whether consumer receives deliveries.
+func (c *Consumer) Check(ctx context.Context) error {
+	if c.consumer == nil {
+		return errors.New("consumer is not started")
+	}
+	return c.consumer.Check(ctx)
+}
+
+func (c *Consumer) handle(ctx context.Context, delivery amqp.Delivery) {
+	messageType, err := c.dispatch(ctx, delivery.Body)
+	s := fmt.Sprintf(`messages_handled_total{type=%q, success="%v"}`, messageType, err == nil)
+	metrics.GetOrCreateCounter(s).Inc()
+	if err == nil {
+		if err := delivery.Ack(false); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return
+	}
+
+	attempt := retryCount(delivery.Headers) + 1
+	logger := log.With().Str("type", string(messageType)).Int("attempt", attempt).Logger()
+	if retryable(err) && attempt <= rabbitmq.MaxRetries {
+		logger.Warn().Err(err).Dur("retry_in", rabbitmq.RetryDelay(attempt)).Msg("Cannot handle message, retrying")
+		err = c.retry(ctx, delivery, attempt)
+	} else {
+		logger.Error().Err(err).Msg("Cannot handle message, dead-lettering")
+		metrics.GetOrCreateCounter(fmt.Sprintf(`messages_dead_lettered_total{type=%q}`, messageType)).Inc()
+		err = c.deadLetter(ctx, delivery, err)
+	}
+	if err != nil {
+		// message is dead-lettered by broker, when it cannot be republished.
+		logger.Error().Err(err).Msg("Cannot republish failed message")
+		if err := delivery.Nack(false, false); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return
+	}
+	if err := delivery.Ack(false); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
+
+// retry publishes copy of the message to retry queue of the attempt, the copy returns to the queue
+// once its delay expires.
+func (c *Consumer) retry(ctx context.Context, delivery amqp.Delivery, attempt int) error {
+	msg := republished(delivery)
+	msg.Headers[RetryCountHeader] = int32(attempt)
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	return c.channel.PublishConfirmed(ctx, "", rabbitmq.RetryQueue(c.channel.Queue(), attempt), msg)
+}
+
+func (c *Consumer) deadLetter(ctx context.Context, delivery amqp.Delivery, cause error) error {
+	msg := republished(delivery)
+	msg.Headers[ErrorHeader] = cause.Error()
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	queue := c.channel.Queue()
+	return c.channel.PublishConfirmed(ctx, rabbitmq.DeadLetterExchange(queue), queue, msg)
+}
+
+func republished(delivery amqp.Delivery) amqp.Publishing {
+	headers := make(amqp.Table, len(delivery.Headers)+1)
+	for key, value := range delivery.Headers {
+		headers[key] = value
+	}
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  delivery.ContentType,
+		DeliveryMode: delivery.DeliveryMode,
+		MessageId:    delivery.MessageId,
+		Timestamp:    delivery.Timestamp,
+		Body:         delivery.Body,
+	}
+}
+
+// retryCount returns number of failed attempts to handle message, header is missing in new messages.
+func retryCount(headers amqp.Table) int {
+	switch count := headers[RetryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	default:
+		return 0
+	}
+}
+
+// retryable reports whether message may be handled successfully next time.
+func retryable(err error) bool {
+	return !errors.Is(err, ErrMalformedMessage) &&
+		!errors.Is(err, ErrUnknownMessageType) &&
+		!errors.Is(err, ErrPermanent)
+}
+
+func (c *Consumer) dispatch(ctx context.Context, body []byte) (MessageType, error) {
+	var header MessageHeader
+	if err := json.Unmarshal(body, &header); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+	handler, ok := c.handlers[header.Type]
+	if !ok {
+		// unknown type is not used as metric label, so arbitrary types do not create new series.
+		return "unknown", fmt.Errorf("%w: %q", ErrUnknownMessageType, header.Type)
+	}
+	return header.Type, handler(ctx, body)
+}
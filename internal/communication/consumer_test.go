@@ -0,0 +1,61 @@
+package communication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPayload struct {
+	Email string `json:"email"`
+}
+
+func TestConsumer_Dispatch(t *testing.T) {
+	consumer := NewConsumer(nil)
+	var received []string
+	Handle(consumer, "Test", func(_ context.Context, msg Message[testPayload]) error {
+		received = append(received, msg.Payload.Email)
+		return nil
+	})
+
+	body, err := json.Marshal(Message[testPayload]{
+		MessageHeader: MessageHeader{Type: "Test", Timestamp: time.Now()},
+		Payload:       testPayload{Email: "someone@mail.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageType, err := consumer.dispatch(context.Background(), body)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageType("Test"), messageType)
+	assert.Equal(t, []string{"someone@mail.com"}, received)
+
+	_, err = consumer.dispatch(context.Background(), []byte(`{"messageType": "Other", "payload": {}}`))
+	assert.ErrorIs(t, err, ErrUnknownMessageType)
+	_, err = consumer.dispatch(context.Background(), []byte(`{"messageType": "Test", "payload": []}`))
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+	_, err = consumer.dispatch(context.Background(), []byte(`not json`))
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+}
+
+func TestConsumer_Retry(t *testing.T) {
+	assert.Equal(t, 0, retryCount(nil))
+	assert.Equal(t, 2, retryCount(amqp.Table{RetryCountHeader: int32(2)}))
+	assert.Equal(t, 3, retryCount(amqp.Table{RetryCountHeader: int64(3)}))
+
+	assert.True(t, retryable(errors.New("connection refused")))
+	assert.False(t, retryable(fmt.Errorf("%w: invalid email", ErrPermanent)))
+	assert.False(t, retryable(fmt.Errorf("%w: %q", ErrUnknownMessageType, "Other")))
+
+	delivery := amqp.Delivery{Headers: amqp.Table{RetryCountHeader: int32(1)}, Body: []byte("{}")}
+	msg := republished(delivery)
+	msg.Headers[RetryCountHeader] = int32(2)
+	assert.Equal(t, int32(1), delivery.Headers[RetryCountHeader], "headers of delivery are copied")
+	assert.Equal(t, delivery.Body, msg.Body)
+}
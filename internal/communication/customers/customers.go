@@ -1,12 +1,22 @@
 package customers
 
-import "github.com/fdemchenko/exchanger/internal/communication"
+import (
+	"github.com/fdemchenko/exchanger/internal/communication"
+	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
+)
 
 const (
-	CreateCustomerRequestQueue  = "CreateCustomerRequests"
-	CreateCustomerResponseQueue = "CreateCustomerResponses"
+	CreateCustomerRequestQueue  = "CreateCustomerRequests.v2"
+	CreateCustomerResponseQueue = "CreateCustomerResponses.v2"
 )
 
+// Queues holds options of customers queues, every service declaring them has to dial with these options.
+// Previous versions declared queues without version suffix, their messages are moved to the new queues.
+var Queues = map[string]rabbitmq.QueueOptions{
+	CreateCustomerRequestQueue:  rabbitmq.DefaultQueueOptions.Replacing("CreateCustomerRequests"),
+	CreateCustomerResponseQueue: rabbitmq.DefaultQueueOptions.Replacing("CreateCustomerResponses"),
+}
+
 const (
 	CreateCustomerRequest  communication.MessageType = "CreateCustomerRequest"
 	CustomerCreated        communication.MessageType = "CustomerCreated"
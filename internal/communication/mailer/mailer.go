@@ -1,20 +1,74 @@
 package mailer
 
-import "github.com/fdemchenko/exchanger/internal/communication"
+import (
+	"time"
 
-const RateEmailsQueue = "emails"
-const TriggerEmailsSendingQueue = "email_trigger"
+	"github.com/fdemchenko/exchanger/internal/communication"
+	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
+	"github.com/shopspring/decimal"
+)
+
+const RateEmailsQueue = "emails.v2"
+const TriggerEmailsSendingQueue = "email_trigger.v2"
+
+// Queues holds options of mailer queues, every service declaring them has to dial with these options.
+// Previous versions declared queues without version suffix, their messages are moved to the new queues.
+var Queues = map[string]rabbitmq.QueueOptions{
+	RateEmailsQueue:           rabbitmq.DefaultQueueOptions.Replacing("emails"),
+	TriggerEmailsSendingQueue: rabbitmq.DefaultQueueOptions.Replacing("email_trigger"),
+}
 
 const (
-	ExchangeRateUpdated   communication.MessageType = "ExchangeRateUpdated"
 	SendEmailNotification communication.MessageType = "SendEmailNotification"
 	StartEmailSending     communication.MessageType = "StartEmailSending"
+	SendConfirmationEmail communication.MessageType = "SendConfirmationEmail"
 )
 
-type ExchangeRateUpdatedEvent struct {
-	Rate float32 `json:"rate"`
-}
-
 type SendEmailNotificationCommand struct {
 	Email string `json:"email"`
+	// UnsubscribeURL is a signed one-click unsubscribe link of the recipient.
+	UnsubscribeURL string `json:"unsubscribeUrl,omitempty"`
+	// Alert is set if email is sent because subscriber's alert rule matched, regular rate update is sent otherwise.
+	Alert *RateAlert `json:"alert,omitempty"`
+	// Rates are rates of pairs subscriber chose.
+	Rates []PairRate `json:"rates,omitempty"`
+}
+
+// PairRate holds official or middle market rate of the pair and its retail prices, zero price means it is unknown.
+type PairRate struct {
+	Pair string          `json:"pair"`
+	Rate decimal.Decimal `json:"rate"`
+	Buy  decimal.Decimal `json:"buy"`
+	Sell decimal.Decimal `json:"sell"`
+}
+
+const (
+	AlertKindChange   = "change"
+	AlertKindCrossing = "crossing"
+)
+
+// SendConfirmationEmailCommand asks subscriber to confirm subscription by following the link.
+type SendConfirmationEmailCommand struct {
+	Email           string    `json:"email"`
+	ConfirmationURL string    `json:"confirmationUrl"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+	// Alert is set if the link confirms alert rule instead of subscription.
+	Alert *AlertRule `json:"alert,omitempty"`
+}
+
+// AlertRule describes when subscriber wants to be alerted.
+type AlertRule struct {
+	Pair      string          `json:"pair"`
+	Type      string          `json:"type"`
+	Kind      string          `json:"kind"`
+	Threshold decimal.Decimal `json:"threshold"`
+}
+
+// RateAlert describes alert rule, that matched, and rate change, that triggered it.
+type RateAlert struct {
+	AlertRule
+	Rate         decimal.Decimal `json:"rate"`
+	PreviousRate decimal.Decimal `json:"previousRate"`
+	// DeleteURL is a signed link to page, that deletes the alert.
+	DeleteURL string `json:"deleteUrl,omitempty"`
 }
@@ -0,0 +1,131 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrReturned means broker has not routed mandatory message to any queue.
+var ErrReturned = errors.New("message is returned by broker, no queue is bound to its routing key")
+
+// publishing is a message published in confirm mode, that broker has not confirmed yet.
+type publishing struct {
+	tag      uint64
+	exchange string
+	key      string
+	body     []byte
+	returned bool
+	done     chan error
+}
+
+// confirms tracks messages published on amqp channel in confirm mode. Broker returns unroutable mandatory
+// message before it confirms it, both are received by the same goroutine in that order, so returned message
+// is known by the time its confirmation is handled.
+type confirms struct {
+	mu      sync.Mutex
+	pending []*publishing
+}
+
+// newConfirms puts amqp channel into confirm mode and starts receiving its returns and confirmations.
+func newConfirms(amqpCh *amqp.Channel) (*confirms, error) {
+	if err := amqpCh.Confirm(false); err != nil {
+		return nil, err
+	}
+	c := &confirms{}
+	returns := amqpCh.NotifyReturn(make(chan amqp.Return))
+	confirmations := amqpCh.NotifyPublish(make(chan amqp.Confirmation))
+	go c.watch(returns, confirmations)
+	return c, nil
+}
+
+// publish publishes mandatory message, result of publishing is sent to the returned channel.
+func (c *confirms) publish(
+	ctx context.Context,
+	amqpCh *amqp.Channel,
+	exchange, key string,
+	msg amqp.Publishing,
+) (<-chan error, error) {
+	// message is tracked before its confirmation can be handled, as handling waits for the lock.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	confirmation, err := amqpCh.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return nil, err
+	}
+	p := &publishing{
+		tag:      confirmation.DeliveryTag,
+		exchange: exchange,
+		key:      key,
+		body:     msg.Body,
+		done:     make(chan error, 1),
+	}
+	c.pending = append(c.pending, p)
+	return p.done, nil
+}
+
+func (c *confirms) watch(returns <-chan amqp.Return, confirmations <-chan amqp.Confirmation) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.markReturned(ret)
+		case confirmation, ok := <-confirmations:
+			if !ok {
+				c.failPending()
+				return
+			}
+			c.settle(confirmation)
+		}
+	}
+}
+
+// markReturned marks the earliest pending message, returned message matches. Messages are returned
+// in order they were published, so identical messages are returned in the same order.
+func (c *confirms) markReturned(ret amqp.Return) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pending {
+		if !p.returned && p.exchange == ret.Exchange && p.key == ret.RoutingKey && bytes.Equal(p.body, ret.Body) {
+			p.returned = true
+			return
+		}
+	}
+}
+
+func (c *confirms) settle(confirmation amqp.Confirmation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pending {
+		if p.tag != confirmation.DeliveryTag {
+			continue
+		}
+		switch {
+		case !confirmation.Ack:
+			p.done <- ErrNotConfirmed
+		case p.returned:
+			p.done <- ErrReturned
+		default:
+			p.done <- nil
+		}
+		c.pending = slices.Delete(c.pending, i, i+1)
+		return
+	}
+}
+
+// failPending fails messages, that are not confirmed before channel is closed.
+func (c *confirms) failPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pending {
+		p.done <- ErrNotConfirmed
+	}
+	c.pending = nil
+}
@@ -0,0 +1,434 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/health"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultReconnectMinDelay = 500 * time.Millisecond
+	DefaultReconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	ErrConnectionLost = errors.New("connection is lost")
+	ErrNotConfirmMode = errors.New("channel is not in confirm mode")
+)
+
+// Connection keeps connection to the broker open. Lost connection is reestablished with exponential backoff,
+// then channels opened by the connection are reopened, so their consumers and producers keep working.
+type Connection struct {
+	url      string
+	minDelay time.Duration
+	maxDelay time.Duration
+	queues   map[string]QueueOptions
+
+	mu       sync.RWMutex
+	conn     *amqp.Connection
+	channels []*Channel
+	done     chan struct{}
+}
+
+type Option func(*Connection)
+
+// WithReconnectDelay sets delay before the first reconnection attempt, delay doubles after every failed
+// attempt up to maxDelay.
+func WithReconnectDelay(minDelay, maxDelay time.Duration) Option {
+	return func(c *Connection) {
+		c.minDelay = minDelay
+		c.maxDelay = maxDelay
+	}
+}
+
+// WithQueues sets options of the queues, queues without options use DefaultQueueOptions.
+func WithQueues(queues map[string]QueueOptions) Option {
+	return func(c *Connection) {
+		for name, opts := range queues {
+			c.queues[name] = opts
+		}
+	}
+}
+
+// Dial connects to the broker, it fails if the broker is not available at the moment.
+func Dial(url string, opts ...Option) (*Connection, error) {
+	c := &Connection{
+		url:      url,
+		minDelay: DefaultReconnectMinDelay,
+		maxDelay: DefaultReconnectMaxDelay,
+		queues:   make(map[string]QueueOptions),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.watch(conn)
+	return c, nil
+}
+
+// Channel opens channel and declares the queue, channel is reopened whenever broker closes it or connection.
+func (c *Connection) Channel(queue string) (*Channel, error) {
+	ch := &Channel{conn: c, queue: queue}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ch.open(c.conn); err != nil {
+		return nil, err
+	}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+// IsClosed reports whether connection is closed, connection is closed while it is being reestablished.
+func (c *Connection) IsClosed() bool {
+	return c.current().IsClosed()
+}
+
+// Close closes connection along with its channels, they are not reopened anymore.
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	close(c.done)
+	if err := c.conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (c *Connection) queueOptions(queue string) QueueOptions {
+	if opts, ok := c.queues[queue]; ok {
+		return opts
+	}
+	return DefaultQueueOptions
+}
+
+func (c *Connection) current() *amqp.Connection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+func (c *Connection) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Connection) watch(conn *amqp.Connection) {
+	amqpErr := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+	if c.closed() {
+		return
+	}
+	var err error = ErrConnectionLost
+	if amqpErr != nil {
+		err = amqpErr
+	}
+	log.Warn().Err(err).Msg("RabbitMQ connection is lost, reconnecting")
+	c.reconnect()
+}
+
+func (c *Connection) reconnect() {
+	delay := c.minDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+		err := c.connect()
+		if err == nil {
+			metrics.GetOrCreateCounter("rabbitmq_reconnections_total").Inc()
+			log.Info().Int("attempt", attempt).Msg("Reconnected to RabbitMQ")
+			return
+		}
+		delay = min(2*delay, c.maxDelay)
+		log.Error().Err(err).Int("attempt", attempt).Dur("retry_in", delay).Msg("Cannot reconnect to RabbitMQ")
+	}
+}
+
+func (c *Connection) connect() error {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.closed() {
+		c.mu.Unlock()
+		return conn.Close()
+	}
+	c.conn = conn
+	channels := slices.Clone(c.channels)
+	c.mu.Unlock()
+
+	for _, ch := range channels {
+		if err := ch.open(conn); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	go c.watch(conn)
+	return nil
+}
+
+// Channel is reopened with its queue declared, confirm mode and consumers restored, after broker closes it.
+// Messages published while channel is closed fail.
+type Channel struct {
+	conn  *Connection
+	queue string
+
+	mu        sync.RWMutex
+	ch        *amqp.Channel
+	drain     *amqp.Channel
+	confirm   bool
+	confirms  *confirms
+	prefetch  int
+	consumers []*Consumer
+}
+
+// consumerSeq makes consumer tags unique.
+var consumerSeq atomic.Uint64
+
+// Consume passes deliveries of channel queue to handle until consumer is cancelled or connection is closed.
+func (ch *Channel) Consume(handle func(amqp.Delivery)) (*Consumer, error) {
+	consumer := &Consumer{
+		queue:  ch.queue,
+		tag:    fmt.Sprintf("%s-%d", ch.queue, consumerSeq.Add(1)),
+		handle: handle,
+	}
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if err := consumer.start(ch.ch); err != nil {
+		return nil, err
+	}
+	ch.consumers = append(ch.consumers, consumer)
+	return consumer, nil
+}
+
+// Cancel stops deliveries to consumer, cancelled consumer is not restarted when channel is reopened.
+func (ch *Channel) Cancel(consumer *Consumer) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	consumer.cancelled.Store(true)
+	ch.consumers = slices.DeleteFunc(ch.consumers, func(c *Consumer) bool {
+		return c == consumer
+	})
+	if ch.ch.IsClosed() {
+		return nil
+	}
+	return ch.ch.Cancel(consumer.tag, false)
+}
+
+// Qos limits number of deliveries, that are not acknowledged yet, limit is restored when channel is reopened.
+func (ch *Channel) Qos(prefetch int) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if err := ch.ch.Qos(prefetch, 0, false); err != nil {
+		return err
+	}
+	ch.prefetch = prefetch
+	return nil
+}
+
+// Confirm puts channel into confirm mode, so every published message is acknowledged by the broker.
+func (ch *Channel) Confirm() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	confirms, err := newConfirms(ch.ch)
+	if err != nil {
+		return err
+	}
+	ch.confirm = true
+	ch.confirms = confirms
+	return nil
+}
+
+// Publish publishes message to the queue through default exchange.
+func (ch *Channel) Publish(ctx context.Context, queue string, msg amqp.Publishing) error {
+	return ch.current().PublishWithContext(ctx, "", queue, false, false, ch.withDeliveryMode(queue, msg))
+}
+
+// PublishConfirmed publishes mandatory message on channel in confirm mode and waits until broker confirms it.
+// ErrNotConfirmed is returned if broker rejects the message or channel is closed before broker confirms it,
+// ErrReturned is returned if broker has not routed the message to any queue.
+func (ch *Channel) PublishConfirmed(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	ch.mu.RLock()
+	amqpCh, confirms := ch.ch, ch.confirms
+	ch.mu.RUnlock()
+	if confirms == nil {
+		return ErrNotConfirmMode
+	}
+
+	done, err := confirms.publish(ctx, amqpCh, exchange, key, ch.withDeliveryMode(key, msg))
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withDeliveryMode sets delivery mode of the queue, which the key names, unless message has its own.
+func (ch *Channel) withDeliveryMode(key string, msg amqp.Publishing) amqp.Publishing {
+	if msg.DeliveryMode == 0 {
+		msg.DeliveryMode = ch.conn.queueOptions(key).DeliveryMode
+	}
+	return msg
+}
+
+// Queue returns name of the queue declared by the channel.
+func (ch *Channel) Queue() string {
+	return ch.queue
+}
+
+func (ch *Channel) IsClosed() bool {
+	return ch.current().IsClosed()
+}
+
+func (ch *Channel) current() *amqp.Channel {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	return ch.ch
+}
+
+// open replaces closed channel with the new one opened on conn, open channel is kept.
+func (ch *Channel) open(conn *amqp.Connection) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.ch != nil && !ch.ch.IsClosed() {
+		return nil
+	}
+
+	opts := ch.conn.queueOptions(ch.queue)
+	amqpCh, err := OpenWithQueueName(conn, ch.queue, opts)
+	if err != nil {
+		return err
+	}
+	var confirms *confirms
+	if ch.confirm {
+		confirms, err = newConfirms(amqpCh)
+	}
+	if err == nil && ch.prefetch > 0 {
+		err = amqpCh.Qos(ch.prefetch, 0, false)
+	}
+	for _, consumer := range ch.consumers {
+		if err != nil {
+			break
+		}
+		err = consumer.start(amqpCh)
+	}
+	if err != nil {
+		amqpCh.Close()
+		return err
+	}
+	ch.ch = amqpCh
+	ch.confirms = confirms
+	go ch.watch(amqpCh)
+	if opts.Legacy != "" {
+		ch.drainLegacy(conn, opts)
+	}
+	return nil
+}
+
+// drainLegacy starts moving messages of legacy queue, unless they are moved already. Queue stays usable,
+// if legacy queue cannot be drained, draining is retried once channel is reopened.
+func (ch *Channel) drainLegacy(conn *amqp.Connection, opts QueueOptions) {
+	if ch.drain != nil && !ch.drain.IsClosed() {
+		return
+	}
+	drain, err := drainLegacy(conn, opts.Legacy, ch.queue, opts.DeliveryMode)
+	if err != nil {
+		log.Error().Err(err).Str("queue", opts.Legacy).Msg("Cannot drain legacy RabbitMQ queue")
+		return
+	}
+	ch.drain = drain
+}
+
+// watch reopens channel closed by broker, while connection stays open. Channels of lost connection
+// are reopened by the connection once it is reestablished.
+func (ch *Channel) watch(amqpCh *amqp.Channel) {
+	amqpErr := <-amqpCh.NotifyClose(make(chan *amqp.Error, 1))
+	delay := ch.conn.minDelay
+	for {
+		conn := ch.conn.current()
+		if ch.conn.closed() || conn.IsClosed() {
+			return
+		}
+		if amqpErr != nil {
+			log.Warn().Err(amqpErr).Str("queue", ch.queue).Msg("RabbitMQ channel is closed, reopening")
+			amqpErr = nil
+		}
+		err := ch.open(conn)
+		if err == nil {
+			return
+		}
+		delay = min(2*delay, ch.conn.maxDelay)
+		log.Error().Err(err).Str("queue", ch.queue).Dur("retry_in", delay).Msg("Cannot reopen RabbitMQ channel")
+		select {
+		case <-time.After(delay):
+		case <-ch.conn.done:
+			return
+		}
+	}
+}
+
+// Consumer passes deliveries to handler, it is restarted whenever its channel is reopened.
+type Consumer struct {
+	queue  string
+	tag    string
+	handle func(amqp.Delivery)
+	// active is a number of running delivery loops, loop of closed channel may still finish
+	// when loop of reopened one starts.
+	active    atomic.Int32
+	cancelled atomic.Bool
+}
+
+func (c *Consumer) start(amqpCh *amqp.Channel) error {
+	deliveries, err := amqpCh.Consume(c.queue, c.tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	c.active.Add(1)
+	go func() {
+		defer c.active.Add(-1)
+		for delivery := range deliveries {
+			c.handle(delivery)
+		}
+		if !c.cancelled.Load() {
+			log.Warn().Str("queue", c.queue).Msg("Consumer stopped, deliveries channel is closed")
+		}
+	}()
+	return nil
+}
+
+// Check reports whether consumer receives deliveries, it fails while channel is being reopened.
+func (c *Consumer) Check(context.Context) error {
+	if c.active.Load() == 0 {
+		return health.ErrConsumerStopped
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+// moveTimeout limits time broker takes to confirm message moved from legacy queue.
+const moveTimeout = 5 * time.Second
+
+// drainLegacy opens channel, that moves messages of legacy queue to the queue. Legacy queue is declared
+// the way previous versions declared it, so declaration never conflicts with the existing one and messages
+// of producers, that are not upgraded yet, keep being moved. Moved messages get delivery mode of the queue.
+func drainLegacy(conn *amqp.Connection, legacy, queue string, deliveryMode uint8) (*amqp.Channel, error) {
+	amqpCh, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := consumeLegacy(amqpCh, legacy)
+	if err != nil {
+		amqpCh.Close()
+		return nil, err
+	}
+	go func() {
+		for delivery := range deliveries {
+			move(amqpCh, queue, deliveryMode, delivery)
+		}
+	}()
+	return amqpCh, nil
+}
+
+func consumeLegacy(amqpCh *amqp.Channel, legacy string) (<-chan amqp.Delivery, error) {
+	if _, err := amqpCh.QueueDeclare(legacy, false, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	if err := amqpCh.Confirm(false); err != nil {
+		return nil, err
+	}
+	return amqpCh.Consume(legacy, "", false, false, false, false, nil)
+}
+
+// move publishes delivery to the queue and acknowledges it once broker confirms the message,
+// delivery, that is not moved, is returned to legacy queue.
+func move(amqpCh *amqp.Channel, queue string, deliveryMode uint8, delivery amqp.Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), moveTimeout)
+	defer cancel()
+
+	confirmation, err := amqpCh.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+		Headers:         delivery.Headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    deliveryMode,
+		Priority:        delivery.Priority,
+		CorrelationId:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		Expiration:      delivery.Expiration,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		UserId:          delivery.UserId,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	})
+	if err == nil {
+		var acked bool
+		if acked, err = confirmation.WaitContext(ctx); err == nil && !acked {
+			err = ErrNotConfirmed
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Str("queue", queue).Msg("Cannot move message from legacy queue")
+		if err := delivery.Nack(false, true); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return
+	}
+	if err := delivery.Ack(false); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
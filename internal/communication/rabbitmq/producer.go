@@ -1,32 +1,80 @@
 package rabbitmq
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-const PublishingContentType = "application/json"
+const (
+	PublishingContentType = "application/json"
+	// DefaultConfirmTimeout is a time producer waits for broker to confirm message.
+	DefaultConfirmTimeout = 5 * time.Second
+)
+
+var ErrNotConfirmed = errors.New("message is not confirmed by broker")
 
+// GenericProducer sends messages encoded as JSON, SendMessage returns once broker confirms the message.
 type GenericProducer struct {
-	channel *amqp.Channel
+	channel *Channel
+	timeout time.Duration
 }
 
-func NewGenericProducer(
-	channel *amqp.Channel,
-) *GenericProducer {
-	return &GenericProducer{
-		channel: channel,
+type ProducerOption func(*GenericProducer)
+
+// WithConfirmTimeout sets time SendMessage waits for broker to confirm message.
+func WithConfirmTimeout(timeout time.Duration) ProducerOption {
+	return func(gp *GenericProducer) {
+		gp.timeout = timeout
 	}
 }
 
+// NewGenericProducer puts channel into confirm mode.
+func NewGenericProducer(channel *Channel, opts ...ProducerOption) (*GenericProducer, error) {
+	if err := channel.Confirm(); err != nil {
+		return nil, err
+	}
+	gp := &GenericProducer{channel: channel, timeout: DefaultConfirmTimeout}
+	for _, opt := range opts {
+		opt(gp)
+	}
+	return gp, nil
+}
+
+// SendMessage fails if broker does not confirm the message within timeout, message may still be delivered
+// in that case.
 func (gp *GenericProducer) SendMessage(msg any, queue string) error {
 	body, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	return gp.channel.Publish("", queue, false, false, amqp.Publishing{
+	ctx, cancel := context.WithTimeout(context.Background(), gp.timeout)
+	defer cancel()
+	return gp.channel.PublishConfirmed(ctx, "", queue, amqp.Publishing{
+		ContentType: PublishingContentType,
+		Body:        body,
+	})
+}
+
+// ConfirmingProducer publishes messages on channel in confirm mode and waits until broker confirms each of them.
+type ConfirmingProducer struct {
+	channel *Channel
+}
+
+func NewConfirmingProducer(channel *Channel) (*ConfirmingProducer, error) {
+	if err := channel.Confirm(); err != nil {
+		return nil, err
+	}
+	return &ConfirmingProducer{channel: channel}, nil
+}
+
+// Publish returns ErrNotConfirmed if broker rejects the message and ErrReturned if no queue has received it.
+func (cp *ConfirmingProducer) Publish(ctx context.Context, queue string, body []byte) error {
+	return cp.channel.PublishConfirmed(ctx, "", queue, amqp.Publishing{
 		ContentType: PublishingContentType,
 		Body:        body,
 	})
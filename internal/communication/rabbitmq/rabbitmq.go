@@ -1,25 +1,102 @@
 package rabbitmq
 
 import (
+	"fmt"
+	"time"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func OpenWithQueueName(conn *amqp.Connection, queueName string) (*amqp.Channel, error) {
+const (
+	// MaxRetries is a number of times failed message is delivered again before it is dead-lettered.
+	MaxRetries = 5
+	// RetryBaseDelay is a delay before the first retry, delay doubles with every next retry.
+	RetryBaseDelay = 2 * time.Second
+)
+
+// QueueOptions sets how broker keeps the queue and messages published to it.
+type QueueOptions struct {
+	// Durable queue survives broker restart.
+	Durable bool
+	// DeliveryMode of messages published to the queue, amqp.Persistent messages of durable queue
+	// survive broker restart.
+	DeliveryMode uint8
+	// Legacy is a queue, that previous versions declared non-durable and without arguments instead of this one.
+	// Messages published to legacy queue are moved to this queue.
+	Legacy string
+}
+
+// DefaultQueueOptions keep queue and its messages across broker restarts.
+var DefaultQueueOptions = QueueOptions{Durable: true, DeliveryMode: amqp.Persistent}
+
+// Replacing returns options of the queue, that replaces legacy queue. Arguments of existing queue cannot
+// be changed, so queue with new arguments gets new name, while legacy queue is drained into it.
+func (o QueueOptions) Replacing(legacy string) QueueOptions {
+	o.Legacy = legacy
+	return o
+}
+
+// DeadLetterExchange receives messages rejected by consumers of the queue and routes them to DeadLetterQueue.
+func DeadLetterExchange(queue string) string {
+	return queue + ".dlx"
+}
+
+func DeadLetterQueue(queue string) string {
+	return queue + ".dlq"
+}
+
+// RetryQueue holds messages failed attempt times until their delay expires, then they return to the queue.
+func RetryQueue(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queue, attempt)
+}
+
+// RetryDelay is a delay before message failed attempt times is delivered again.
+func RetryDelay(attempt int) time.Duration {
+	return RetryBaseDelay << (attempt - 1)
+}
+
+// OpenWithQueueName opens channel and declares the queue along with its retry queues, dead-letter exchange
+// and dead-letter queue, all of them have durability of the queue. Every service has to declare queue
+// with the same options, broker rejects conflicting declaration.
+func OpenWithQueueName(conn *amqp.Connection, queueName string, opts QueueOptions) (*amqp.Channel, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, err
 	}
-
-	_, err = ch.QueueDeclare(
-		queueName,
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
+	if err := declareTopology(ch, queueName, opts.Durable); err != nil {
+		ch.Close()
 		return nil, err
 	}
 	return ch, nil
 }
+
+func declareTopology(ch *amqp.Channel, queue string, durable bool) error {
+	dlx := DeadLetterExchange(queue)
+	if err := ch.ExchangeDeclare(dlx, amqp.ExchangeDirect, durable, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(DeadLetterQueue(queue), durable, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(DeadLetterQueue(queue), queue, dlx, false, nil); err != nil {
+		return err
+	}
+
+	for attempt := 1; attempt <= MaxRetries; attempt++ {
+		// expired messages are dead-lettered back to the queue through the default exchange.
+		_, err := ch.QueueDeclare(RetryQueue(queue, attempt), durable, false, false, false, amqp.Table{
+			"x-message-ttl":             RetryDelay(attempt).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := ch.QueueDeclare(queue, durable, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    dlx,
+		"x-dead-letter-routing-key": queue,
+	})
+	return err
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	// DefaultTimeout limits all checks of readiness probe, probes usually time out after a few seconds.
+	DefaultTimeout = 2 * time.Second
+)
+
+var (
+	ErrConsumerStopped  = errors.New("consumer is stopped")
+	ErrConnectionClosed = errors.New("connection is closed")
+	ErrChannelClosed    = errors.New("channel is closed")
+)
+
+// Check returns error if dependency cannot be used.
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Checker serves liveness and readiness probes, service is ready when all its checks pass.
+type Checker struct {
+	checks  map[string]Check
+	timeout time.Duration
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{checks: make(map[string]Check), timeout: timeout}
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.checks[name] = check
+}
+
+// Check runs all checks concurrently, checks, that do not finish in time, fail.
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runCheck(ctx, check)
+			result := CheckResult{Status: StatusOK}
+			if err != nil {
+				result = CheckResult{Status: StatusUnavailable, Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusUnavailable
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// runCheck stops waiting for check, that ignores ctx, once ctx is done.
+func runCheck(ctx context.Context, check Check) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- check(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Register adds GET /healthz, that reports process is alive, and GET /readyz, that reports result of checks.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, Report{Status: StatusOK, Checks: map[string]CheckResult{}}, http.StatusOK)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := c.Check(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+			log.Warn().Interface("checks", report.Checks).Msg("Service is not ready")
+		}
+		writeJSON(w, report, status)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, report Report, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
+
+func DB(db *sql.DB) Check {
+	return db.PingContext
+}
+
+// Closable is RabbitMQ connection or channel.
+type Closable interface {
+	IsClosed() bool
+}
+
+// RabbitMQ checks connection and channels are open.
+func RabbitMQ(conn Closable, channels ...Closable) Check {
+	return func(context.Context) error {
+		if conn.IsClosed() {
+			return ErrConnectionClosed
+		}
+		for _, channel := range channels {
+			if channel.IsClosed() {
+				return ErrChannelClosed
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker(t *testing.T) {
+	checker := NewChecker(50 * time.Millisecond)
+	checker.Add("db", func(context.Context) error { return nil })
+	mux := http.NewServeMux()
+	checker.Register(mux)
+
+	serve := func(path string) (int, Report) {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		var report Report
+		if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return recorder.Code, report
+	}
+
+	status, report := serve("/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, Report{Status: StatusOK, Checks: map[string]CheckResult{"db": {Status: StatusOK}}}, report)
+
+	checker.Add("rabbitmq", func(context.Context) error { return ErrChannelClosed })
+	// check ignoring context fails once timeout elapses.
+	checker.Add("consumer", func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	status, report = serve("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["db"].Status)
+	assert.Equal(t, CheckResult{Status: StatusUnavailable, Error: ErrChannelClosed.Error()}, report.Checks["rabbitmq"])
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["consumer"].Error)
+
+	// liveness does not depend on checks.
+	status, report = serve("/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, StatusOK, report.Status)
+}
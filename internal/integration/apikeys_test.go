@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+type APIKeyServiceSuite struct {
+	suite.Suite
+	apiKeyService *services.APIKeyService
+	container     *postgres.PostgresContainer
+}
+
+func (aks *APIKeyServiceSuite) SetupSuite() {
+	t := aks.T()
+	container, err := CreateTestDBContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aks.container = container
+	dsn, err := container.ConnectionString(context.Background(), "sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys are not cached, so revocation is visible immediately.
+	aks.apiKeyService = services.NewAPIKeyService(&repositories.PostgresAPIKeyRepository{DB: db}, 0)
+}
+
+func (aks *APIKeyServiceSuite) TearDownTest() {
+	err := aks.container.Restore(context.Background())
+	if err != nil {
+		aks.T().Fatal(err)
+	}
+}
+
+func (aks *APIKeyServiceSuite) TestIssueAndRevoke() {
+	t := aks.T()
+	ctx := context.Background()
+	key, id, err := aks.apiKeyService.Issue(ctx, "backend", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey, err := aks.apiKeyService.Authenticate(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, id, apiKey.ID)
+	assert.Equal(t, "backend", apiKey.Name)
+	assert.Equal(t, sql.NullInt32{Int32: 100, Valid: true}, apiKey.RateLimit)
+
+	assert.NoError(t, aks.apiKeyService.Revoke(ctx, id))
+	assert.ErrorIs(t, aks.apiKeyService.Revoke(ctx, id), repositories.ErrAPIKeyDoesNotExist)
+	_, err = aks.apiKeyService.Authenticate(ctx, key)
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+
+	keys, err := aks.apiKeyService.GetAll(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.True(t, keys[0].RevokedAt.Valid)
+	}
+}
+
+func TestAPIKeySuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping API key service integration test...")
+	}
+
+	suite.Run(t, new(APIKeyServiceSuite))
+}
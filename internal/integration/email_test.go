@@ -3,7 +3,9 @@ package integration
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fdemchenko/exchanger/internal/repositories"
 	"github.com/fdemchenko/exchanger/internal/services"
@@ -13,13 +15,17 @@ import (
 )
 
 type EmailService interface {
-	Create(email string) (int, error)
+	Create(email string, preferences repositories.Preferences, newMessage repositories.OutboxMessageFunc) (int, error)
+	Confirm(id int, newMessage repositories.OutboxMessageFunc) (string, error)
 	GetAll() ([]string, error)
+	GetDue(now time.Time) ([]repositories.Subscription, error)
+	MarkSent(ids []int, sentAt time.Time) error
 }
 
 type EmailServiceSuite struct {
 	suite.Suite
 	emailService EmailService
+	db           *sql.DB
 	container    *postgres.PostgresContainer
 }
 
@@ -40,7 +46,8 @@ func (em *EmailServiceSuite) SetupSuite() {
 		t.Fatal(err)
 	}
 
-	repo := &repositories.PostgresSubscriptionRepository{DB: db}
+	em.db = db
+	repo := &repositories.PostgresSubscriptionRepository{DB: db, ResendInterval: time.Hour}
 	em.emailService = services.NewSubscriptionService(repo)
 }
 
@@ -52,25 +59,65 @@ func (em *EmailServiceSuite) TearDownTest() {
 }
 
 func (em *EmailServiceSuite) TestCreateEmail_Success() {
-	_, err := em.emailService.Create("someemail@gmail.com")
+	_, err := em.emailService.Create("someemail@gmail.com", services.DefaultPreferences(), nil)
 	assert.NoError(em.T(), err)
 }
 
 func (em *EmailServiceSuite) TestCreateEmail_Duplicate() {
 	t := em.T()
-	_, err := em.emailService.Create("somemail@gmail.com")
+	id, err := em.emailService.Create("somemail@gmail.com", services.DefaultPreferences(), nil)
 	assert.NoError(t, err)
+	_, err = em.emailService.Create("somemail@gmail.com", services.DefaultPreferences(), nil)
+	assert.ErrorIs(t, err, repositories.ErrRecentlyRequested)
 
-	_, err = em.emailService.Create("somemail@gmail.com")
+	// pending subscription may be requested again after resend interval, preferences are not changed.
+	_, err = em.db.Exec(`UPDATE subscriptions SET created_at = NOW() - INTERVAL '2 hours' WHERE id = $1`, id)
+	assert.NoError(t, err)
+	preferences := services.DefaultPreferences()
+	preferences.Pairs = []string{"EUR-UAH"}
+	pendingID, err := em.emailService.Create("somemail@gmail.com", preferences, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, id, pendingID)
+	var pairs string
+	assert.NoError(t, em.db.QueryRow(`SELECT array_to_string(pairs, ',') FROM subscriptions WHERE id = $1`, id).
+		Scan(&pairs))
+	assert.Equal(t, strings.Join(services.DefaultPreferences().Pairs, ","), pairs)
+
+	_, err = em.emailService.Confirm(id, nil)
+	assert.NoError(t, err)
+	_, err = em.emailService.Create("somemail@gmail.com", services.DefaultPreferences(), nil)
 	assert.ErrorIs(t, err, repositories.ErrDuplicateEmail)
 }
 
+func (em *EmailServiceSuite) TestConfirm() {
+	t := em.T()
+	id, err := em.emailService.Create("somemail@gmail.com", services.DefaultPreferences(), nil)
+	assert.NoError(t, err)
+
+	email, err := em.emailService.Confirm(id, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "somemail@gmail.com", email)
+
+	_, err = em.emailService.Confirm(id, nil)
+	assert.ErrorIs(t, err, repositories.ErrAlreadyConfirmed)
+	_, err = em.emailService.Confirm(id+1, nil)
+	assert.ErrorIs(t, err, repositories.ErrEmailDoesNotExist)
+}
+
 func (em *EmailServiceSuite) TestGetEmails() {
 	t := em.T()
-	_, err := em.emailService.Create("somemail1@gmail.com")
+	id, err := em.emailService.Create("somemail1@gmail.com", services.DefaultPreferences(), nil)
+	assert.NoError(t, err)
+	_, err = em.emailService.Confirm(id, nil)
+	assert.NoError(t, err)
+
+	id, err = em.emailService.Create("another@gmail.com", services.DefaultPreferences(), nil)
+	assert.NoError(t, err)
+	_, err = em.emailService.Confirm(id, nil)
 	assert.NoError(t, err)
 
-	_, err = em.emailService.Create("another@gmail.com")
+	// unconfirmed subscriptions do not receive emails.
+	_, err = em.emailService.Create("pending@gmail.com", services.DefaultPreferences(), nil)
 	assert.NoError(t, err)
 
 	emails, err := em.emailService.GetAll()
@@ -78,6 +125,34 @@ func (em *EmailServiceSuite) TestGetEmails() {
 	assert.ElementsMatch(t, emails, []string{"somemail1@gmail.com", "another@gmail.com"})
 }
 
+func (em *EmailServiceSuite) TestGetDue() {
+	t := em.T()
+	preferences := repositories.Preferences{
+		Pairs:        []string{"USD-UAH", "EUR-UAH"},
+		Frequency:    services.FrequencyDaily,
+		DeliveryHour: 10,
+		Timezone:     "Europe/Kyiv",
+	}
+	id, err := em.emailService.Create("kyiv@gmail.com", preferences, nil)
+	assert.NoError(t, err)
+	_, err = em.emailService.Confirm(id, nil)
+	assert.NoError(t, err)
+
+	// 10:20 in Kyiv.
+	now := time.Date(2024, time.June, 3, 7, 20, 0, 0, time.UTC)
+	due, err := em.emailService.GetDue(now)
+	assert.NoError(t, err)
+	assert.Len(t, due, 1)
+	assert.Equal(t, "kyiv@gmail.com", due[0].Email)
+	assert.Equal(t, preferences, due[0].Preferences)
+
+	err = em.emailService.MarkSent([]int{id}, now)
+	assert.NoError(t, err)
+	due, err = em.emailService.GetDue(now.Add(10 * time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, due)
+}
+
 func (em *EmailServiceSuite) TearDownSuite() {
 	if err := em.container.Terminate(context.Background()); err != nil {
 		em.T().Fatal(err)
@@ -0,0 +1,354 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/communication"
+	"github.com/fdemchenko/exchanger/internal/communication/mailer"
+	"github.com/fdemchenko/exchanger/internal/communication/rabbitmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnection_Reconnect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx := context.Background()
+	container, url, err := CreateTestRabbitMQContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Terminate(ctx) //nolint:errcheck // container is removed by reaper anyway
+
+	conn, err := rabbitmq.Dial(url, rabbitmq.WithReconnectDelay(time.Second, 2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	channel, err := conn.Channel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	consumer, err := channel.Consume(func(delivery amqp.Delivery) {
+		received <- string(delivery.Body)
+		_ = delivery.Ack(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producer, err := rabbitmq.NewGenericProducer(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = container.Exec(ctx, []string{"rabbitmqctl", "close_all_connections", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, conn.IsClosed, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return !channel.IsClosed() && consumer.Check(ctx) == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// queue is declared, confirm mode and consumer are restored on the new connection.
+	err = producer.SendMessage("after reconnect", "test")
+	assert.NoError(t, err)
+	select {
+	case body := <-received:
+		assert.Equal(t, `"after reconnect"`, body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not consumed after reconnection")
+	}
+}
+
+func TestConsumer_Shutdown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	container, url, err := CreateTestRabbitMQContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Terminate(ctx) //nolint:errcheck // container is removed by reaper anyway
+
+	conn, err := rabbitmq.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	channel, err := conn.Channel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan string, 1)
+	consumer := communication.NewConsumer(channel, communication.WithWorkers(2))
+	communication.Handle(consumer, "Test", func(_ context.Context, msg communication.Message[string]) error {
+		handled <- msg.Payload
+		return nil
+	})
+	if err := consumer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	producer, err := rabbitmq.NewGenericProducer(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := communication.Message[string]{MessageHeader: communication.MessageHeader{Type: "Test"}, Payload: "hello"}
+	if err := producer.SendMessage(msg, "test"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-handled:
+		assert.Equal(t, "hello", payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not handled")
+	}
+
+	cancel()
+	consumer.Wait()
+	assert.Eventually(t, func() bool {
+		return consumer.Check(context.Background()) != nil
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestConsumer_RetryAndDeadLetter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	container, url, err := CreateTestRabbitMQContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Terminate(ctx) //nolint:errcheck // container is removed by reaper anyway
+
+	conn, err := rabbitmq.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	channel, err := conn.Channel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := make(chan string, rabbitmq.MaxRetries+1)
+	var failed atomic.Bool
+	consumer := communication.NewConsumer(channel)
+	communication.Handle(consumer, "Test", func(_ context.Context, msg communication.Message[string]) error {
+		attempts <- msg.Payload
+		if msg.Payload == "flaky" && !failed.Swap(true) {
+			return errors.New("database is unavailable")
+		}
+		return nil
+	})
+	if err := consumer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	producer, err := rabbitmq.NewGenericProducer(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := communication.Message[string]{MessageHeader: communication.MessageHeader{Type: "Test"}, Payload: "flaky"}
+	if err := producer.SendMessage(msg, "test"); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		select {
+		case payload := <-attempts:
+			assert.Equal(t, "flaky", payload)
+		case <-time.After(2 * rabbitmq.RetryBaseDelay * 2):
+			t.Fatal("failed message is not retried")
+		}
+	}
+
+	// malformed message is not retried.
+	if err := producer.SendMessage(map[string]any{"messageType": "Test", "payload": 1}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	inspectConn, err := amqp.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inspectConn.Close()
+	inspectCh, err := inspectConn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delivery amqp.Delivery
+	assert.Eventually(t, func() bool {
+		var ok bool
+		delivery, ok, err = inspectCh.Get(rabbitmq.DeadLetterQueue("test"), true)
+		return err == nil && ok
+	}, 5*time.Second, 100*time.Millisecond)
+	assert.Contains(t, delivery.Headers[communication.ErrorHeader], communication.ErrMalformedMessage.Error())
+}
+
+func TestProducer_MessagesSurviveBrokerRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx := context.Background()
+	container, url, err := CreateTestRabbitMQContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Terminate(ctx) //nolint:errcheck // container is removed by reaper anyway
+
+	conn, err := rabbitmq.Dial(url, rabbitmq.WithReconnectDelay(time.Second, 2*time.Second),
+		rabbitmq.WithQueues(map[string]rabbitmq.QueueOptions{
+			"transient": {Durable: false, DeliveryMode: amqp.Transient},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	durable, err := conn.Channel("durable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transient, err := conn.Channel("transient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, err := rabbitmq.NewGenericProducer(durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// broker has written persistent message to disk, once it is confirmed.
+	if err := producer.SendMessage("persistent", "durable"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, command := range []string{"stop_app", "start_app"} {
+		if _, _, err := container.Exec(ctx, []string{"rabbitmqctl", command}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return !durable.IsClosed() && !transient.IsClosed()
+	}, 20*time.Second, 100*time.Millisecond)
+
+	received := make(chan string, 1)
+	_, err = durable.Consume(func(delivery amqp.Delivery) {
+		received <- string(delivery.Body)
+		_ = delivery.Ack(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-received:
+		assert.Equal(t, `"persistent"`, body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is lost after broker restart")
+	}
+}
+
+func TestProducer_UnroutableMessageFails(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx := context.Background()
+	container, url, err := CreateTestRabbitMQContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Terminate(ctx) //nolint:errcheck // container is removed by reaper anyway
+
+	conn, err := rabbitmq.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	channel, err := conn.Channel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, err := rabbitmq.NewConfirmingProducer(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// broker confirms message, that no queue has received, so it must be reported as failure.
+	publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.ErrorIs(t, producer.Publish(publishCtx, "missing", []byte(`"lost"`)), rabbitmq.ErrReturned)
+	assert.NoError(t, producer.Publish(publishCtx, "test", []byte(`"delivered"`)))
+	assert.ErrorIs(t, producer.Publish(publishCtx, "missing", []byte(`"lost"`)), rabbitmq.ErrReturned)
+}
+
+func TestConnection_DrainsLegacyQueue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx := context.Background()
+	container, url, err := CreateTestRabbitMQContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Terminate(ctx) //nolint:errcheck // container is removed by reaper anyway
+
+	// queue is declared and holds message the way previous versions left it.
+	legacyConn, err := amqp.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacyConn.Close()
+	legacyCh, err := legacyConn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacyCh.QueueDeclare("emails", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(body string) {
+		err := legacyCh.PublishWithContext(ctx, "", "emails", false, false, amqp.Publishing{Body: []byte(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("before upgrade")
+
+	conn, err := rabbitmq.Dial(url, rabbitmq.WithQueues(mailer.Queues))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	channel, err := conn.Channel(mailer.RateEmailsQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan amqp.Delivery, 2)
+	_, err = channel.Consume(func(delivery amqp.Delivery) {
+		received <- delivery
+		_ = delivery.Ack(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// service of previous version still publishes transient messages to legacy queue.
+	publish("during upgrade")
+
+	for _, expected := range []string{"before upgrade", "during upgrade"} {
+		select {
+		case delivery := <-received:
+			assert.Equal(t, expected, string(delivery.Body))
+			// moved message survives broker restart along with the durable queue.
+			assert.Equal(t, amqp.Persistent, delivery.DeliveryMode)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %q is not moved from legacy queue", expected)
+		}
+	}
+}
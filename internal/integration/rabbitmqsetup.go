@@ -0,0 +1,32 @@
+package integration
+
+import (
+	"context"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const RabbitMQStartTimeout = time.Minute
+
+// CreateTestRabbitMQContainer starts broker and returns its AMQP URL.
+func CreateTestRabbitMQContainer() (testcontainers.Container, string, error) {
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "docker.io/rabbitmq:3-alpine",
+			ExposedPorts: []string{"5672/tcp"},
+			WaitingFor:   wait.ForLog("Server startup complete").WithStartupTimeout(RabbitMQStartTimeout),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	endpoint, err := container.PortEndpoint(ctx, "5672/tcp", "")
+	if err != nil {
+		return nil, "", err
+	}
+	return container, "amqp://guest:guest@" + endpoint, nil
+}
@@ -0,0 +1,103 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+type RateRepositorySuite struct {
+	suite.Suite
+	rateRepository *repositories.PostgresRateRepository
+	container      *postgres.PostgresContainer
+}
+
+func (rrs *RateRepositorySuite) SetupSuite() {
+	t := rrs.T()
+	container, err := CreateTestDBContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rrs.container = container
+	dsn, err := container.ConnectionString(context.Background(), "sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rrs.rateRepository = &repositories.PostgresRateRepository{DB: db}
+}
+
+func (rrs *RateRepositorySuite) TearDownTest() {
+	err := rrs.container.Restore(context.Background())
+	if err != nil {
+		rrs.T().Fatal(err)
+	}
+}
+
+func (rrs *RateRepositorySuite) TestGetHistory_Buckets() {
+	t := rrs.T()
+	ctx := context.Background()
+	from := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	records := []repositories.RateRecord{
+		{Provider: "nbu", Base: "USD", Quote: "UAH", Type: "official",
+			Rate: decimal.NewFromInt(40), FetchedAt: from.Add(10 * time.Minute)},
+		{Provider: "nbu", Base: "USD", Quote: "UAH", Type: "official",
+			Rate: decimal.NewFromInt(42), FetchedAt: from.Add(50 * time.Minute)},
+		{Provider: "privat", Base: "USD", Quote: "UAH", Type: "official",
+			Rate: decimal.NewFromInt(41), FetchedAt: from.Add(90 * time.Minute)},
+		{Provider: "privat", Base: "USD", Quote: "UAH", Type: "buy",
+			Rate: decimal.NewFromInt(39), FetchedAt: from.Add(30 * time.Minute)},
+		{Provider: "nbu", Base: "EUR", Quote: "UAH", Type: "official",
+			Rate: decimal.NewFromInt(44), FetchedAt: from.Add(20 * time.Minute)},
+	}
+	for _, record := range records {
+		assert.NoError(t, rrs.rateRepository.Insert(ctx, record))
+	}
+
+	points, err := rrs.rateRepository.GetHistory(ctx, "USD", "UAH", "official", from, from.Add(3*time.Hour), time.Hour)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+
+	assert.True(t, from.Equal(points[0].Timestamp))
+	assert.True(t, decimal.NewFromInt(41).Equal(points[0].Average))
+	assert.True(t, decimal.NewFromInt(40).Equal(points[0].Min))
+	assert.True(t, decimal.NewFromInt(42).Equal(points[0].Max))
+	assert.Equal(t, 2, points[0].Samples)
+
+	assert.True(t, from.Add(time.Hour).Equal(points[1].Timestamp))
+	assert.Equal(t, 1, points[1].Samples)
+}
+
+func (rrs *RateRepositorySuite) TestGetHistory_Empty() {
+	points, err := rrs.rateRepository.GetHistory(context.Background(), "USD", "UAH", "official",
+		time.Now().Add(-time.Hour), time.Now(), time.Minute)
+	assert.NoError(rrs.T(), err)
+	assert.Empty(rrs.T(), points)
+}
+
+func (rrs *RateRepositorySuite) TearDownSuite() {
+	if err := rrs.container.Terminate(context.Background()); err != nil {
+		rrs.T().Fatal(err)
+	}
+}
+
+func TestRateRepositorySuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping rate repository integration test...")
+	}
+
+	suite.Run(t, new(RateRepositorySuite))
+}
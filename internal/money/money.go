@@ -0,0 +1,25 @@
+package money
+
+import (
+	"encoding/json"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultDisplayPrecision is the number of digits after decimal point banks publish exchange rates with.
+const DefaultDisplayPrecision = 4
+
+// AmountPrecision is the number of digits after decimal point converted amounts are shown with.
+const AmountPrecision = 2
+
+// Format returns value rounded to places digits after decimal point, trailing zeros are kept.
+// Halves are rounded away from zero the same way banks round published rates. Rounding is applied
+// only for display, all calculations are made with full precision.
+func Format(value decimal.Decimal, places int32) string {
+	return value.StringFixed(places)
+}
+
+// JSONNumber returns rounded value, that is encoded as JSON number instead of a string.
+func JSONNumber(value decimal.Decimal, places int32) json.Number {
+	return json.Number(Format(value, places))
+}
@@ -0,0 +1,36 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		places   int32
+		expected string
+	}{
+		{name: "Rounding down", value: "41.12339", places: 4, expected: "41.1234"},
+		{name: "Half is rounded up", value: "41.12345", places: 4, expected: "41.1235"},
+		{name: "Trailing zeros are kept", value: "41.1", places: 4, expected: "41.1000"},
+		{name: "Integer", value: "41", places: 2, expected: "41.00"},
+		{name: "Negative half", value: "-0.125", places: 2, expected: "-0.13"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Format(decimal.RequireFromString(tc.value), tc.places))
+		})
+	}
+}
+
+func TestJSONNumber(t *testing.T) {
+	body, err := json.Marshal(map[string]any{"rate": JSONNumber(decimal.RequireFromString("41.123398"), 4)})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"rate": 41.1234}`, string(body))
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets, that refilled completely, are removed.
+const sweepInterval = time.Minute
+
+// Quota allows Limit requests per Period, bursts of up to Limit requests are allowed.
+type Quota struct {
+	Limit  int
+	Period time.Duration
+}
+
+func PerMinute(limit int) Quota {
+	return Quota{Limit: limit, Period: time.Minute}
+}
+
+// rate is a number of tokens added per second.
+func (q Quota) rate() float64 {
+	return float64(q.Limit) / q.Period.Seconds()
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is time after which the next request is allowed, zero if request is allowed.
+	RetryAfter time.Duration
+	// Reset is time after which all requests of the quota are available again.
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	quota     Quota
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = min(float64(b.quota.Limit), b.tokens+now.Sub(b.updatedAt).Seconds()*b.quota.rate())
+	b.updatedAt = now
+}
+
+// Limiter is a token bucket limiter keeping separate bucket for every client key.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow takes token from bucket of the key. Bucket keeps its tokens when quota changes,
+// but not more than the new limit.
+func (l *Limiter) Allow(key string, quota Quota) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(quota.Limit), updatedAt: now, quota: quota}
+		l.buckets[key] = b
+	}
+	b.quota = quota
+	b.refill(now)
+
+	result := Result{Limit: quota.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / quota.rate())
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((float64(quota.Limit) - b.tokens) / quota.rate())
+	return result
+}
+
+// sweep removes full buckets, as new bucket of the key would be the same.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= float64(b.quota.Limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	limiter := New()
+	limiter.now = func() time.Time { return now }
+	quota := PerMinute(3)
+
+	for i := range 3 {
+		result := limiter.Allow("client", quota)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+	}
+	result := limiter.Allow("client", quota)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 20*time.Second, result.RetryAfter)
+	assert.Equal(t, time.Minute, result.Reset)
+
+	// other clients have their own buckets.
+	assert.True(t, limiter.Allow("other", quota).Allowed)
+
+	now = now.Add(20 * time.Second)
+	result = limiter.Allow("client", quota)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.False(t, limiter.Allow("client", quota).Allowed)
+}
+
+func TestLimiter_QuotaChange(t *testing.T) {
+	now := time.Now()
+	limiter := New()
+	limiter.now = func() time.Time { return now }
+
+	assert.Equal(t, 9, limiter.Allow("client", PerMinute(10)).Remaining)
+	assert.Equal(t, 1, limiter.Allow("client", PerMinute(2)).Remaining)
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Now()
+	limiter := New()
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow("idle", PerMinute(60))
+	now = now.Add(30 * time.Second)
+	hourly := Quota{Limit: 1, Period: time.Hour}
+	limiter.Allow("active", hourly)
+	now = now.Add(sweepInterval)
+	assert.False(t, limiter.Allow("active", hourly).Allowed)
+
+	assert.NotContains(t, limiter.buckets, "idle")
+	assert.Contains(t, limiter.buckets, "active")
+}
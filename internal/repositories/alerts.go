@@ -0,0 +1,201 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Alert is a subscriber's rule, that decides when rate of the pair is worth an email.
+type Alert struct {
+	ID        int
+	Email     string
+	Base      string
+	Quote     string
+	Type      string
+	Kind      string
+	Threshold decimal.Decimal
+	// LastRate is the rate alert was evaluated against last time.
+	LastRate         decimal.NullDecimal
+	LastNotifiedRate decimal.NullDecimal
+	LastNotifiedAt   sql.NullTime
+}
+
+type PostgresAlertRepository struct {
+	DB *sql.DB
+}
+
+// Insert creates pending alert for subscription with alert's email, message built by newMessage
+// is saved in the same transaction.
+func (ar *PostgresAlertRepository) Insert(
+	ctx context.Context,
+	alert Alert,
+	newMessage OutboxMessageFunc,
+) (int, error) {
+	stmt := `INSERT INTO alerts (subscription_id, base_currency, quote_currency, rate_type, kind, threshold)
+	SELECT id, $2, $3, $4, $5, $6 FROM subscriptions WHERE email = $1 AND status = 'active'
+	RETURNING id`
+
+	tx, err := ar.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is no-op
+
+	var id int
+	err = tx.QueryRowContext(ctx, stmt,
+		alert.Email, alert.Base, alert.Quote, alert.Type, alert.Kind, alert.Threshold).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrEmailDoesNotExist
+		}
+		return 0, err
+	}
+	if err := insertOutboxMessage(tx, newMessage, id, alert.Email); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// Confirm activates pending alert, so it starts being evaluated.
+func (ar *PostgresAlertRepository) Confirm(ctx context.Context, id int) error {
+	stmt := `UPDATE alerts SET status = 'active', confirmed_at = NOW() WHERE id = $1 AND status = 'pending'`
+
+	result, err := ar.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 1 {
+		return nil
+	}
+
+	var status string
+	err = ar.DB.QueryRowContext(ctx, `SELECT status FROM alerts WHERE id = $1`, id).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAlertDoesNotExist
+		}
+		return err
+	}
+	return ErrAlertConfirmed
+}
+
+// DeleteUnconfirmed deletes alerts, that are still pending since the moment.
+func (ar *PostgresAlertRepository) DeleteUnconfirmed(ctx context.Context, createdBefore time.Time) (int64, error) {
+	stmt := `DELETE FROM alerts WHERE status = 'pending' AND created_at < $1`
+
+	result, err := ar.DB.ExecContext(ctx, stmt, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AlertEvaluation returns new state of the alert along with function, that builds message about it,
+// nil function means alert has not fired.
+type AlertEvaluation func(alert Alert) (Alert, OutboxMessageFunc)
+
+// Evaluate passes confirmed alerts on the pair and rate type to evaluate, then saves their new state along with
+// messages in one transaction. Alerts are locked until it ends and alerts locked by another evaluation are skipped,
+// so replicas, that evaluate the same rate, never notify subscriber twice.
+func (ar *PostgresAlertRepository) Evaluate(
+	ctx context.Context,
+	base, quote, rateType string,
+	evaluate AlertEvaluation,
+) error {
+	query := `SELECT a.id, s.email, a.base_currency, a.quote_currency, a.rate_type, a.kind, a.threshold,
+		a.last_rate, a.last_notified_rate, a.last_notified_at
+	FROM alerts a JOIN subscriptions s ON s.id = a.subscription_id
+	WHERE a.base_currency = $1 AND a.quote_currency = $2 AND a.rate_type = $3 AND a.status = 'active'
+	FOR UPDATE OF a SKIP LOCKED`
+	stmt := `UPDATE alerts SET last_rate = $2, last_notified_rate = $3, last_notified_at = $4 WHERE id = $1`
+
+	tx, err := ar.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is no-op
+
+	rows, err := tx.QueryContext(ctx, query, base, quote, rateType)
+	if err != nil {
+		return err
+	}
+	var alerts []Alert
+	for rows.Next() {
+		var alert Alert
+		err := rows.Scan(&alert.ID, &alert.Email, &alert.Base, &alert.Quote, &alert.Type, &alert.Kind,
+			&alert.Threshold, &alert.LastRate, &alert.LastNotifiedRate, &alert.LastNotifiedAt)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		alerts = append(alerts, alert)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, alert := range alerts {
+		alert, newMessage := evaluate(alert)
+		_, err := tx.ExecContext(ctx, stmt, alert.ID, alert.LastRate, alert.LastNotifiedRate, alert.LastNotifiedAt)
+		if err != nil {
+			return err
+		}
+		if err := insertOutboxMessage(tx, newMessage, alert.ID, alert.Email); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AlertedPair is a pair and rate type somebody has alerts on.
+type AlertedPair struct {
+	Base  string
+	Quote string
+	Type  string
+}
+
+func (ar *PostgresAlertRepository) GetAlertedPairs(ctx context.Context) ([]AlertedPair, error) {
+	query := `SELECT DISTINCT base_currency, quote_currency, rate_type FROM alerts WHERE status = 'active'`
+
+	rows, err := ar.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pairs []AlertedPair
+	for rows.Next() {
+		var pair AlertedPair
+		if err := rows.Scan(&pair.Base, &pair.Quote, &pair.Type); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
+
+func (ar *PostgresAlertRepository) DeleteByID(ctx context.Context, id int) error {
+	stmt := `DELETE FROM alerts WHERE id = $1`
+
+	result, err := ar.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAlertDoesNotExist
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type APIKey struct {
+	ID   int
+	Name string
+	// RateLimit is a number of requests per minute, default quota of keys is applied when it is not set.
+	RateLimit sql.NullInt32
+	CreatedAt time.Time
+	RevokedAt sql.NullTime
+}
+
+type PostgresAPIKeyRepository struct {
+	DB *sql.DB
+}
+
+func (akr *PostgresAPIKeyRepository) Insert(
+	ctx context.Context,
+	name string,
+	keyHash []byte,
+	rateLimit sql.NullInt32,
+) (int, error) {
+	stmt := `INSERT INTO api_keys (name, key_hash, rate_limit) VALUES ($1, $2, $3) RETURNING id`
+
+	var id int
+	err := akr.DB.QueryRowContext(ctx, stmt, name, keyHash, rateLimit).Scan(&id)
+	return id, err
+}
+
+// GetByHash returns key, that is not revoked.
+func (akr *PostgresAPIKeyRepository) GetByHash(ctx context.Context, keyHash []byte) (APIKey, error) {
+	query := `SELECT id, name, rate_limit, created_at, revoked_at FROM api_keys
+	WHERE key_hash = $1 AND revoked_at IS NULL`
+
+	var key APIKey
+	err := akr.DB.QueryRowContext(ctx, query, keyHash).
+		Scan(&key.ID, &key.Name, &key.RateLimit, &key.CreatedAt, &key.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrAPIKeyDoesNotExist
+	}
+	return key, err
+}
+
+func (akr *PostgresAPIKeyRepository) GetAll(ctx context.Context) ([]APIKey, error) {
+	query := `SELECT id, name, rate_limit, created_at, revoked_at FROM api_keys ORDER BY id`
+
+	rows, err := akr.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.RateLimit, &key.CreatedAt, &key.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke marks key as revoked, revoked keys are kept, so it is known who used them.
+func (akr *PostgresAPIKeyRepository) Revoke(ctx context.Context, id int) error {
+	stmt := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	result, err := akr.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyDoesNotExist
+	}
+	return nil
+}
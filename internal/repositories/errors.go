@@ -3,8 +3,14 @@ package repositories
 import "errors"
 
 var (
-	ErrDuplicateEmail    = errors.New("email already exists")
-	ErrEmailDoesNotExist = errors.New("email does not exist")
+	ErrDuplicateEmail     = errors.New("email already exists")
+	ErrEmailDoesNotExist  = errors.New("email does not exist")
+	ErrRateDoesNotExist   = errors.New("rate does not exist")
+	ErrAlertDoesNotExist  = errors.New("alert does not exist")
+	ErrAlreadyConfirmed   = errors.New("subscription is already confirmed")
+	ErrRecentlyRequested  = errors.New("confirmation is already requested recently")
+	ErrAlertConfirmed     = errors.New("alert is already confirmed")
+	ErrAPIKeyDoesNotExist = errors.New("api key does not exist")
 )
 
 const PostgreSQLUniqueViolationErrorCode = "23505"
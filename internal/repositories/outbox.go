@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OutboxMessage is published to the queue by outbox relay after transaction, that saved it, is committed.
+type OutboxMessage struct {
+	ID      int64
+	Queue   string
+	Payload []byte
+}
+
+// OutboxMessageFunc builds message about change of subscription or alert with the id, message is saved
+// in the same transaction as change.
+type OutboxMessageFunc func(id int, email string) (OutboxMessage, error)
+
+func NewOutboxMessage(queue string, msg any) (OutboxMessage, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+	return OutboxMessage{Queue: queue, Payload: payload}, nil
+}
+
+func insertOutboxMessage(tx *sql.Tx, newMessage OutboxMessageFunc, id int, email string) error {
+	if newMessage == nil {
+		return nil
+	}
+	msg, err := newMessage(id, email)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO outbox (queue, payload) VALUES ($1, $2)`, msg.Queue, msg.Payload)
+	return err
+}
+
+type PostgresOutboxRepository struct {
+	DB *sql.DB
+}
+
+// ClaimPending returns up to limit unsent messages, that are not claimed by other relays, in order they were saved.
+// Messages are claimed until the moment, so they are published by the caller only, if it manages to do it in time.
+func (obr *PostgresOutboxRepository) ClaimPending(
+	ctx context.Context,
+	limit int,
+	claimedUntil time.Time,
+) ([]OutboxMessage, error) {
+	query := `UPDATE outbox SET claimed_until = $2
+	WHERE id IN (
+		SELECT id FROM outbox WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < $3)
+		ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, queue, payload`
+
+	rows, err := obr.DB.QueryContext(ctx, query, limit, claimedUntil, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.Queue, &msg.Payload); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(messages, func(a, b OutboxMessage) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return messages, nil
+}
+
+// MarkSent marks published messages sent.
+func (obr *PostgresOutboxRepository) MarkSent(ctx context.Context, ids []int64) error {
+	_, err := obr.DB.ExecContext(ctx, `UPDATE outbox SET sent_at = NOW() WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+// Release drops claims of messages, that were not published, so they are published again without waiting
+// for claims to expire.
+func (obr *PostgresOutboxRepository) Release(ctx context.Context, ids []int64) error {
+	_, err := obr.DB.ExecContext(ctx, `UPDATE outbox SET claimed_until = NULL WHERE id = ANY($1) AND sent_at IS NULL`,
+		pq.Array(ids))
+	return err
+}
+
+// DeleteSent deletes messages, that were published before the moment.
+func (obr *PostgresOutboxRepository) DeleteSent(ctx context.Context, sentBefore time.Time) (int64, error) {
+	result, err := obr.DB.ExecContext(ctx, `DELETE FROM outbox WHERE sent_at < $1`, sentBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
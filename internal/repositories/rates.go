@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type RateRecord struct {
+	Provider  string
+	Base      string
+	Quote     string
+	Type      string
+	Rate      decimal.Decimal
+	FetchedAt time.Time
+}
+
+// RatePoint aggregates all rates fetched within one history bucket.
+type RatePoint struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Average   decimal.Decimal `json:"average"`
+	Min       decimal.Decimal `json:"min"`
+	Max       decimal.Decimal `json:"max"`
+	Samples   int             `json:"samples"`
+}
+
+type PostgresRateRepository struct {
+	DB *sql.DB
+}
+
+func (rr *PostgresRateRepository) Insert(ctx context.Context, record RateRecord) error {
+	stmt := `INSERT INTO rates (provider, base_currency, quote_currency, rate_type, rate, fetched_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := rr.DB.ExecContext(ctx, stmt,
+		record.Provider, record.Base, record.Quote, record.Type, record.Rate, record.FetchedAt)
+	return err
+}
+
+// GetLatest returns the latest rate of the pair and type fetched before the moment.
+func (rr *PostgresRateRepository) GetLatest(
+	ctx context.Context,
+	base, quote, rateType string,
+	before time.Time,
+) (RateRecord, error) {
+	query := `SELECT provider, base_currency, quote_currency, rate_type, rate, fetched_at
+	FROM rates
+	WHERE base_currency = $1 AND quote_currency = $2 AND rate_type = $3 AND fetched_at < $4
+	ORDER BY fetched_at DESC
+	LIMIT 1`
+
+	var record RateRecord
+	err := rr.DB.QueryRowContext(ctx, query, base, quote, rateType, before).Scan(
+		&record.Provider, &record.Base, &record.Quote, &record.Type, &record.Rate, &record.FetchedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RateRecord{}, ErrRateDoesNotExist
+		}
+		return RateRecord{}, err
+	}
+	return record, nil
+}
+
+// GetHistory returns rates of the pair and type in [from, to) grouped into buckets of interval length,
+// buckets are aligned to from and buckets without fetched rates are omitted.
+func (rr *PostgresRateRepository) GetHistory(
+	ctx context.Context,
+	base, quote, rateType string,
+	from, to time.Time,
+	interval time.Duration,
+) ([]RatePoint, error) {
+	query := `SELECT date_bin($4::interval, fetched_at, $5) AS bucket, AVG(rate), MIN(rate), MAX(rate), COUNT(*)
+	FROM rates
+	WHERE base_currency = $1 AND quote_currency = $2 AND rate_type = $3 AND fetched_at >= $5 AND fetched_at < $6
+	GROUP BY bucket
+	ORDER BY bucket`
+
+	pgInterval := fmt.Sprintf("%d seconds", int64(interval.Seconds()))
+	rows, err := rr.DB.QueryContext(ctx, query, base, quote, rateType, pgInterval, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []RatePoint{}
+	for rows.Next() {
+		var point RatePoint
+		err := rows.Scan(&point.Timestamp, &point.Average, &point.Min, &point.Max, &point.Samples)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+
+	return points, rows.Err()
+}
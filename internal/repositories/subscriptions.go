@@ -3,41 +3,166 @@ package repositories
 import (
 	"database/sql"
 	"errors"
-	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
 
+// Preferences describe which rates subscriber receives and when.
+type Preferences struct {
+	// Pairs are currency pairs in BASE-QUOTE format.
+	Pairs     []string
+	Frequency string
+	// DeliveryHour is an hour of the day in Timezone emails are sent at.
+	DeliveryHour int
+	Timezone     string
+}
+
+type Subscription struct {
+	ID    int
+	Email string
+	Preferences
+	LastSentAt sql.NullTime
+}
+
 type PostgresSubscriptionRepository struct {
 	DB *sql.DB
+	// ResendInterval is a time confirmation of pending subscription can not be requested again for.
+	ResendInterval time.Duration
 }
 
-func (em *PostgresSubscriptionRepository) Insert(email string) (int, error) {
-	stmt := `INSERT INTO subscriptions (email) VALUES ($1) RETURNING id`
+// Insert creates pending subscription. Pending subscription of the same email keeps its preferences,
+// but confirmation can be requested again once ResendInterval has passed, ErrRecentlyRequested is returned
+// before that. Confirmed subscription causes ErrDuplicateEmail.
+// Message built by newMessage is saved to outbox in the same transaction.
+func (em *PostgresSubscriptionRepository) Insert(
+	email string,
+	preferences Preferences,
+	newMessage OutboxMessageFunc,
+) (int, error) {
+	stmt := `INSERT INTO subscriptions (email, status, pairs, frequency, delivery_hour, timezone)
+	VALUES ($1, 'pending', $2, $3, $4, $5)
+	ON CONFLICT (email) DO UPDATE SET created_at = NOW()
+	WHERE subscriptions.status = 'pending' AND subscriptions.created_at <= $6
+	RETURNING id`
+
+	tx, err := em.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is no-op
 
 	var id int
-	row := em.DB.QueryRow(stmt, email)
-	if row.Err() != nil {
-		var pgError *pq.Error
-		if errors.As(row.Err(), &pgError) {
-			// unique constraint error
-			if pgError.Code == pq.ErrorCode(PostgreSQLUniqueViolationErrorCode) &&
-				strings.Contains(pgError.Message, "subscriptions_email_key") {
-				return 0, ErrDuplicateEmail
-			}
+	err = tx.QueryRow(stmt, email, pq.Array(preferences.Pairs), preferences.Frequency,
+		preferences.DeliveryHour, preferences.Timezone, time.Now().Add(-em.ResendInterval)).Scan(&id)
+	if err != nil {
+		// conflicting row is not updated if subscription is already confirmed or requested recently.
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, em.conflictError(tx, email)
+		}
+		return 0, err
+	}
+	if err := insertOutboxMessage(tx, newMessage, id, email); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (em *PostgresSubscriptionRepository) conflictError(tx *sql.Tx, email string) error {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM subscriptions WHERE email = $1`, email).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status == "pending" {
+		return ErrRecentlyRequested
+	}
+	return ErrDuplicateEmail
+}
+
+// Confirm activates pending subscription and returns its email.
+// Message built by newMessage is saved to outbox in the same transaction.
+func (em *PostgresSubscriptionRepository) Confirm(id int, newMessage OutboxMessageFunc) (string, error) {
+	stmt := `UPDATE subscriptions SET status = 'active', confirmed_at = NOW()
+	WHERE id = $1 AND status = 'pending'
+	RETURNING email`
+
+	tx, err := em.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is no-op
+
+	var email string
+	err = tx.QueryRow(stmt, id).Scan(&email)
+	if err == nil {
+		if err := insertOutboxMessage(tx, newMessage, id, email); err != nil {
+			return "", err
 		}
-		return 0, row.Err()
+		return email, tx.Commit()
 	}
-	err := row.Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	var status string
+	err = tx.QueryRow(`SELECT status FROM subscriptions WHERE id = $1`, id).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrEmailDoesNotExist
+		}
+		return "", err
+	}
+	return "", ErrAlreadyConfirmed
+}
+
+// DeleteUnconfirmed deletes subscriptions, that are still pending since the moment.
+func (em *PostgresSubscriptionRepository) DeleteUnconfirmed(createdBefore time.Time) (int64, error) {
+	stmt := `DELETE FROM subscriptions WHERE status = 'pending' AND created_at < $1`
+
+	result, err := em.DB.Exec(stmt, createdBefore)
 	if err != nil {
 		return 0, err
 	}
+	return result.RowsAffected()
+}
+
+// GetActive returns confirmed subscriptions along with their preferences.
+func (em *PostgresSubscriptionRepository) GetActive() ([]Subscription, error) {
+	query := `SELECT id, email, pairs, frequency, delivery_hour, timezone, last_sent_at
+	FROM subscriptions WHERE status = 'active'`
+
+	rows, err := em.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		var subscription Subscription
+		err := rows.Scan(&subscription.ID, &subscription.Email, pq.Array(&subscription.Pairs),
+			&subscription.Frequency, &subscription.DeliveryHour, &subscription.Timezone, &subscription.LastSentAt)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// MarkSent saves time emails were sent to subscriptions at.
+func (em *PostgresSubscriptionRepository) MarkSent(ids []int, sentAt time.Time) error {
+	stmt := `UPDATE subscriptions SET last_sent_at = $2 WHERE id = ANY($1)`
 
-	return id, nil
+	_, err := em.DB.Exec(stmt, pq.Array(ids), sentAt)
+	return err
 }
 
+// GetAll returns emails of confirmed subscriptions.
 func (em *PostgresSubscriptionRepository) GetAll() ([]string, error) {
-	query := `SELECT email FROM subscriptions`
+	query := `SELECT email FROM subscriptions WHERE status = 'active'`
 	var emails []string
 
 	rows, err := em.DB.Query(query)
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/tokens"
+)
+
+const AlertTokenPurpose tokens.Purpose = "alert"
+
+// AlertLinks issues signed per-alert tokens, so only the one who created alert or receives its emails
+// can delete it. Tokens do not expire, because they are kept in old emails.
+type AlertLinks struct {
+	signer  *tokens.Signer
+	baseURL string
+}
+
+func NewAlertLinks(signer *tokens.Signer, baseURL string) *AlertLinks {
+	return &AlertLinks{signer: signer, baseURL: baseURL}
+}
+
+func (al *AlertLinks) Token(id int) string {
+	return al.signer.Sign(AlertTokenPurpose, strconv.Itoa(id), time.Time{})
+}
+
+// DeleteURL is a link to page, that deletes the alert.
+func (al *AlertLinks) DeleteURL(id int) string {
+	return al.baseURL + "/alerts/" + strconv.Itoa(id) + "/delete?token=" + url.QueryEscape(al.Token(id))
+}
+
+// ID returns id of alert token was issued for.
+func (al *AlertLinks) ID(token string) (int, error) {
+	subject, err := al.signer.Verify(AlertTokenPurpose, token)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(subject)
+	if err != nil {
+		return 0, tokens.ErrInvalidToken
+	}
+	return id, nil
+}
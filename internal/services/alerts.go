@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/communication"
+	"github.com/fdemchenko/exchanger/internal/communication/mailer"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// AlertKindChange notifies when rate moves more than threshold percents from the last notification.
+	AlertKindChange = mailer.AlertKindChange
+	// AlertKindCrossing notifies when rate crosses threshold level in any direction.
+	AlertKindCrossing = mailer.AlertKindCrossing
+
+	// alertsQueueSize is a number of fetched rates waiting for evaluation, newer rates are dropped when it is full.
+	alertsQueueSize = 100
+	alertsTimeout   = 10 * time.Second
+)
+
+var (
+	ErrInvalidAlertKind      = errors.New("invalid alert kind")
+	ErrInvalidAlertThreshold = errors.New("alert threshold must be positive")
+)
+
+type AlertRepository interface {
+	Insert(ctx context.Context, alert repositories.Alert, newMessage repositories.OutboxMessageFunc) (int, error)
+	Confirm(ctx context.Context, id int) error
+	Evaluate(ctx context.Context, base, quote, rateType string, evaluate repositories.AlertEvaluation) error
+	GetAlertedPairs(ctx context.Context) ([]repositories.AlertedPair, error)
+	DeleteByID(ctx context.Context, id int) error
+	DeleteUnconfirmed(ctx context.Context, createdBefore time.Time) (int64, error)
+}
+
+type MessageProducer interface {
+	SendMessage(msg any, queue string) error
+}
+
+// OutboxNotifier makes outbox relay publish messages saved to outbox.
+type OutboxNotifier interface {
+	Notify()
+}
+
+// AlertService evaluates subscribers' alert rules against freshly fetched rates
+// and sends emails to subscribers, whose rules matched, through outbox.
+type AlertService struct {
+	repository AlertRepository
+	outbox     OutboxNotifier
+	links      *UnsubscribeLinks
+	alertLinks *AlertLinks
+	rates      chan rate.Rate
+}
+
+func NewAlertService(
+	repository AlertRepository,
+	outbox OutboxNotifier,
+	links *UnsubscribeLinks,
+	alertLinks *AlertLinks,
+) *AlertService {
+	return &AlertService{
+		repository: repository,
+		outbox:     outbox,
+		links:      links,
+		alertLinks: alertLinks,
+		rates:      make(chan rate.Rate, alertsQueueSize),
+	}
+}
+
+// Create saves pending alert along with message built by newMessage, alert is not evaluated until confirmed.
+func (as *AlertService) Create(
+	ctx context.Context,
+	email string,
+	pair rate.CurrencyPair,
+	rateType rate.RateType,
+	kind string,
+	threshold decimal.Decimal,
+	newMessage repositories.OutboxMessageFunc,
+) (int, error) {
+	if kind != AlertKindChange && kind != AlertKindCrossing {
+		return 0, ErrInvalidAlertKind
+	}
+	if !threshold.IsPositive() {
+		return 0, ErrInvalidAlertThreshold
+	}
+	return as.repository.Insert(ctx, repositories.Alert{
+		// email is case insensitive
+		Email:     strings.ToLower(email),
+		Base:      pair.Base,
+		Quote:     pair.Quote,
+		Type:      string(rateType),
+		Kind:      kind,
+		Threshold: threshold,
+	}, newMessage)
+}
+
+// Confirm activates pending alert.
+func (as *AlertService) Confirm(ctx context.Context, id int) error {
+	return as.repository.Confirm(ctx, id)
+}
+
+// StartCleanup deletes alerts, that are not confirmed within ttl, every interval until ctx is cancelled.
+func (as *AlertService) StartCleanup(ctx context.Context, interval, ttl time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				deleted, err := as.repository.DeleteUnconfirmed(ctx, time.Now().Add(-ttl))
+				if err != nil {
+					log.Error().Err(err).Msg("Cannot delete unconfirmed alerts")
+					continue
+				}
+				metrics.GetOrCreateCounter("unconfirmed_alerts_deleted_total").Add(int(deleted))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (as *AlertService) Delete(ctx context.Context, id int) error {
+	return as.repository.DeleteByID(ctx, id)
+}
+
+// OnRateFetched queues rate for evaluation, it is meant to be registered as rate service listener.
+func (as *AlertService) OnRateFetched(fetched rate.Rate) {
+	select {
+	case as.rates <- fetched:
+	default:
+		log.Warn().Stringer("pair", fetched.Pair).Msg("Alerts queue is full, rate is not evaluated")
+	}
+}
+
+// Start evaluates queued rates until ctx is cancelled.
+func (as *AlertService) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case fetched := <-as.rates:
+				as.evaluate(ctx, fetched)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// WatchAlertedPairs requests rates of all alerted pairs every interval, so alerts are evaluated
+// even if nobody asks for these rates. Cached rates are not reported to listeners,
+// so rates are actually fetched once cache expires.
+func (as *AlertService) WatchAlertedPairs(ctx context.Context, rateService RateService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				as.requestAlertedRates(ctx, rateService)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (as *AlertService) requestAlertedRates(ctx context.Context, rateService RateService) {
+	pairs, err := as.repository.GetAlertedPairs(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot get alerted pairs")
+		return
+	}
+	for _, pair := range pairs {
+		_, err := rateService.GetRate(ctx, rate.NewCurrencyPair(pair.Base, pair.Quote), rate.RateType(pair.Type))
+		if err != nil {
+			log.Warn().Err(err).Str("base", pair.Base).Str("quote", pair.Quote).Msg("Cannot get alerted rate")
+		}
+	}
+}
+
+func (as *AlertService) evaluate(ctx context.Context, fetched rate.Rate) {
+	ctx, cancel := context.WithTimeout(ctx, alertsTimeout)
+	defer cancel()
+
+	// notifications are saved to outbox along with alert state, so they are sent once and only if state is saved.
+	var triggeredKinds []string
+	evaluate := func(alert repositories.Alert) (repositories.Alert, repositories.OutboxMessageFunc) {
+		previousRate, triggered := shouldNotify(alert, fetched.Value)
+		alert.LastRate = decimal.NewNullDecimal(fetched.Value)
+		// the first evaluated rate is a starting point for change alerts.
+		if triggered || !alert.LastNotifiedRate.Valid {
+			alert.LastNotifiedRate = decimal.NewNullDecimal(fetched.Value)
+		}
+		if !triggered {
+			return alert, nil
+		}
+		alert.LastNotifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		triggeredKinds = append(triggeredKinds, alert.Kind)
+		return alert, as.newNotification(alert, fetched, previousRate)
+	}
+	err := as.repository.Evaluate(ctx, fetched.Pair.Base, fetched.Pair.Quote, string(fetched.Type), evaluate)
+	if err != nil {
+		log.Error().Err(err).Stringer("pair", fetched.Pair).Msg("Cannot evaluate alerts")
+		return
+	}
+
+	for _, kind := range triggeredKinds {
+		metrics.GetOrCreateCounter(`rate_alerts_triggered_total{kind="` + kind + `"}`).Inc()
+	}
+	if len(triggeredKinds) > 0 {
+		as.outbox.Notify()
+	}
+}
+
+// shouldNotify reports whether alert matches current rate along with previous rate it is compared to.
+func shouldNotify(alert repositories.Alert, current decimal.Decimal) (decimal.Decimal, bool) {
+	switch alert.Kind {
+	case AlertKindChange:
+		if !alert.LastNotifiedRate.Valid || alert.LastNotifiedRate.Decimal.IsZero() {
+			return decimal.Zero, false
+		}
+		previous := alert.LastNotifiedRate.Decimal
+		changePercent := current.Sub(previous).Abs().Div(previous).Mul(decimal.NewFromInt(100))
+		return previous, changePercent.GreaterThanOrEqual(alert.Threshold)
+	case AlertKindCrossing:
+		if !alert.LastRate.Valid {
+			return decimal.Zero, false
+		}
+		previous := alert.LastRate.Decimal
+		crossedUp := previous.LessThan(alert.Threshold) && current.GreaterThanOrEqual(alert.Threshold)
+		crossedDown := previous.GreaterThan(alert.Threshold) && current.LessThanOrEqual(alert.Threshold)
+		return previous, crossedUp || crossedDown
+	}
+	return decimal.Zero, false
+}
+
+// newNotification asks mailer to send email about fired alert to the subscriber.
+func (as *AlertService) newNotification(
+	alert repositories.Alert,
+	fetched rate.Rate,
+	previousRate decimal.Decimal,
+) repositories.OutboxMessageFunc {
+	msg := communication.Message[mailer.SendEmailNotificationCommand]{
+		MessageHeader: communication.MessageHeader{Type: mailer.SendEmailNotification, Timestamp: time.Now()},
+		Payload: mailer.SendEmailNotificationCommand{
+			Email:          alert.Email,
+			UnsubscribeURL: as.links.URL(alert.Email),
+			Alert: &mailer.RateAlert{
+				AlertRule: mailer.AlertRule{
+					Pair:      fetched.Pair.String(),
+					Type:      string(fetched.Type),
+					Kind:      alert.Kind,
+					Threshold: alert.Threshold,
+				},
+				Rate:         fetched.Value,
+				PreviousRate: previousRate,
+				DeleteURL:    as.alertLinks.DeleteURL(alert.ID),
+			},
+		},
+	}
+	return func(int, string) (repositories.OutboxMessage, error) {
+		return repositories.NewOutboxMessage(mailer.RateEmailsQueue, msg)
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/communication"
+	"github.com/fdemchenko/exchanger/internal/communication/mailer"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/fdemchenko/exchanger/internal/tokens"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	TestingUnsubscribeLinks = NewUnsubscribeLinks(tokens.NewSigner([]byte("secret")), "http://localhost")
+	TestingAlertLinks       = NewAlertLinks(tokens.NewSigner([]byte("secret")), "http://localhost")
+)
+
+type AlertRepositoryMock struct {
+	alerts   []repositories.Alert
+	messages []repositories.OutboxMessage
+}
+
+func (arm *AlertRepositoryMock) Insert(
+	_ context.Context,
+	alert repositories.Alert,
+	_ repositories.OutboxMessageFunc,
+) (int, error) {
+	alert.ID = len(arm.alerts) + 1
+	arm.alerts = append(arm.alerts, alert)
+	return alert.ID, nil
+}
+
+func (arm *AlertRepositoryMock) Evaluate(
+	_ context.Context,
+	base, quote, rateType string,
+	evaluate repositories.AlertEvaluation,
+) error {
+	for i, alert := range arm.alerts {
+		if alert.Base != base || alert.Quote != quote || alert.Type != rateType {
+			continue
+		}
+		alert, newMessage := evaluate(alert)
+		arm.alerts[i] = alert
+		if newMessage == nil {
+			continue
+		}
+		msg, err := newMessage(alert.ID, alert.Email)
+		if err != nil {
+			return err
+		}
+		arm.messages = append(arm.messages, msg)
+	}
+	return nil
+}
+
+// commands decodes email commands saved to outbox.
+func (arm *AlertRepositoryMock) commands(t *testing.T) []mailer.SendEmailNotificationCommand {
+	t.Helper()
+	var commands []mailer.SendEmailNotificationCommand
+	for _, msg := range arm.messages {
+		assert.Equal(t, mailer.RateEmailsQueue, msg.Queue)
+		var command communication.Message[mailer.SendEmailNotificationCommand]
+		assert.NoError(t, json.Unmarshal(msg.Payload, &command))
+		commands = append(commands, command.Payload)
+	}
+	return commands
+}
+
+func (arm *AlertRepositoryMock) GetAlertedPairs(_ context.Context) ([]repositories.AlertedPair, error) {
+	return nil, nil
+}
+
+func (arm *AlertRepositoryMock) Confirm(_ context.Context, _ int) error {
+	return nil
+}
+
+func (arm *AlertRepositoryMock) DeleteByID(_ context.Context, _ int) error {
+	return nil
+}
+
+func (arm *AlertRepositoryMock) DeleteUnconfirmed(_ context.Context, _ time.Time) (int64, error) {
+	return 0, nil
+}
+
+type OutboxNotifierMock struct {
+	notified int
+}
+
+func (onm *OutboxNotifierMock) Notify() {
+	onm.notified++
+}
+
+type ProducerMock struct {
+	commands []mailer.SendEmailNotificationCommand
+}
+
+func (pm *ProducerMock) SendMessage(msg any, _ string) error {
+	command := msg.(communication.Message[mailer.SendEmailNotificationCommand])
+	pm.commands = append(pm.commands, command.Payload)
+	return nil
+}
+
+func TestShouldNotify(t *testing.T) {
+	testCases := []struct {
+		name      string
+		kind      string
+		threshold string
+		lastRate  string
+		current   string
+		expected  bool
+	}{
+		{name: "Change below threshold", kind: AlertKindChange, threshold: "1", lastRate: "40", current: "40.3"},
+		{name: "Change up", kind: AlertKindChange, threshold: "1", lastRate: "40", current: "40.4", expected: true},
+		{name: "Change down", kind: AlertKindChange, threshold: "1", lastRate: "40", current: "39.5", expected: true},
+		{name: "Crossing up", kind: AlertKindCrossing, threshold: "42", lastRate: "41.9", current: "42", expected: true},
+		{name: "Crossing down", kind: AlertKindCrossing, threshold: "42", lastRate: "42.1", current: "41.9", expected: true},
+		{name: "Not crossed", kind: AlertKindCrossing, threshold: "42", lastRate: "42.1", current: "42.5"},
+		{name: "No previous rate", kind: AlertKindCrossing, threshold: "42", current: "42.5"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			alert := repositories.Alert{Kind: tc.kind, Threshold: decimal.RequireFromString(tc.threshold)}
+			if tc.lastRate != "" {
+				alert.LastRate = decimal.NewNullDecimal(decimal.RequireFromString(tc.lastRate))
+				alert.LastNotifiedRate = alert.LastRate
+			}
+			_, triggered := shouldNotify(alert, decimal.RequireFromString(tc.current))
+			assert.Equal(t, tc.expected, triggered)
+		})
+	}
+}
+
+func TestAlertService_Evaluate(t *testing.T) {
+	repository := &AlertRepositoryMock{}
+	outbox := &OutboxNotifierMock{}
+	service := NewAlertService(repository, outbox, TestingUnsubscribeLinks, TestingAlertLinks)
+	pair := rate.NewCurrencyPair("USD", "UAH")
+	ctx := context.Background()
+
+	_, err := service.Create(ctx, "Treasury@example.com", pair, rate.RateTypeOfficial, AlertKindChange,
+		decimal.NewFromInt(2), nil)
+	assert.NoError(t, err)
+	_, err = service.Create(ctx, "trader@example.com", pair, rate.RateTypeOfficial, AlertKindCrossing,
+		decimal.NewFromInt(42), nil)
+	assert.NoError(t, err)
+
+	for _, value := range []string{"41", "41.2", "41.5", "42.1"} {
+		service.evaluate(ctx, rate.Rate{
+			Pair:      pair,
+			Type:      rate.RateTypeOfficial,
+			Value:     decimal.RequireFromString(value),
+			FetchedAt: time.Now(),
+		})
+	}
+
+	// both alerts fire at 42.1, that is 2.7% more than starting rate of change alert.
+	commands := repository.commands(t)
+	if !assert.Len(t, commands, 2) {
+		return
+	}
+	assert.Equal(t, 1, outbox.notified)
+	assert.Equal(t, "treasury@example.com", commands[0].Email)
+	email, err := TestingUnsubscribeLinks.Email(strings.TrimPrefix(commands[0].UnsubscribeURL,
+		"http://localhost/unsubscribe/"))
+	assert.NoError(t, err)
+	assert.Equal(t, "treasury@example.com", email)
+	assert.True(t, decimal.RequireFromString("41").Equal(commands[0].Alert.PreviousRate))
+	deleteURL, err := url.Parse(commands[0].Alert.DeleteURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "/alerts/1/delete", deleteURL.Path)
+	id, err := TestingAlertLinks.ID(deleteURL.Query().Get("token"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+	assert.Equal(t, "trader@example.com", commands[1].Email)
+	assert.Equal(t, AlertKindCrossing, commands[1].Alert.Kind)
+	assert.True(t, decimal.RequireFromString("42.1").Equal(repository.alerts[0].LastNotifiedRate.Decimal))
+}
+
+func TestAlertService_InvalidAlert(t *testing.T) {
+	service := NewAlertService(&AlertRepositoryMock{}, &OutboxNotifierMock{}, TestingUnsubscribeLinks, TestingAlertLinks)
+	pair := rate.NewCurrencyPair("USD", "UAH")
+
+	_, err := service.Create(context.Background(), "a@example.com", pair, rate.RateTypeOfficial, "unknown",
+		decimal.NewFromInt(1), nil)
+	assert.ErrorIs(t, err, ErrInvalidAlertKind)
+	_, err = service.Create(context.Background(), "a@example.com", pair, rate.RateTypeOfficial, AlertKindChange,
+		decimal.Zero, nil)
+	assert.ErrorIs(t, err, ErrInvalidAlertThreshold)
+}
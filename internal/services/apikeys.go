@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/cache"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+)
+
+const (
+	// APIKeyPrefix makes keys recognizable, e.g. by secret scanners.
+	APIKeyPrefix = "exk_"
+	// DefaultAPIKeyCacheTTL is how long resolved keys are cached, so revocation takes effect within it.
+	DefaultAPIKeyCacheTTL = time.Minute
+	apiKeyRandomBytes     = 32
+)
+
+var (
+	ErrInvalidAPIKey    = errors.New("invalid api key")
+	ErrInvalidRateLimit = errors.New("rate limit must be positive")
+)
+
+type APIKeyRepository interface {
+	Insert(ctx context.Context, name string, keyHash []byte, rateLimit sql.NullInt32) (int, error)
+	GetByHash(ctx context.Context, keyHash []byte) (repositories.APIKey, error)
+	GetAll(ctx context.Context) ([]repositories.APIKey, error)
+	Revoke(ctx context.Context, id int) error
+}
+
+// apiKeyLookup caches unknown keys as well, so requests with made up keys do not reach database every time.
+type apiKeyLookup struct {
+	key   repositories.APIKey
+	found bool
+}
+
+// APIKeyService issues keys and resolves keys of requests, only hashes of keys are stored.
+type APIKeyService struct {
+	repository APIKeyRepository
+	cache      *cache.Cache[string, apiKeyLookup]
+	cacheTTL   time.Duration
+}
+
+func NewAPIKeyService(repository APIKeyRepository, cacheTTL time.Duration) *APIKeyService {
+	return &APIKeyService{
+		repository: repository,
+		cache:      cache.New[string, apiKeyLookup](),
+		cacheTTL:   cacheTTL,
+	}
+}
+
+// Issue creates key and returns it with its ID, key itself cannot be retrieved later.
+// Zero rate limit means default quota of keys.
+func (aks *APIKeyService) Issue(ctx context.Context, name string, rateLimit int) (string, int, error) {
+	if rateLimit < 0 {
+		return "", 0, ErrInvalidRateLimit
+	}
+	random := make([]byte, apiKeyRandomBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", 0, err
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	limit := sql.NullInt32{Int32: int32(rateLimit), Valid: rateLimit > 0}
+	id, err := aks.repository.Insert(ctx, name, hashAPIKey(key), limit)
+	if err != nil {
+		return "", 0, err
+	}
+	return key, id, nil
+}
+
+// Authenticate returns active key, ErrInvalidAPIKey is returned for unknown and revoked keys.
+func (aks *APIKeyService) Authenticate(ctx context.Context, key string) (repositories.APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return repositories.APIKey{}, ErrInvalidAPIKey
+	}
+	keyHash := hashAPIKey(key)
+	if lookup, ok := aks.cache.Get(string(keyHash)); ok {
+		if !lookup.found {
+			return repositories.APIKey{}, ErrInvalidAPIKey
+		}
+		return lookup.key, nil
+	}
+
+	apiKey, err := aks.repository.GetByHash(ctx, keyHash)
+	if err != nil && !errors.Is(err, repositories.ErrAPIKeyDoesNotExist) {
+		return repositories.APIKey{}, err
+	}
+	aks.cache.Set(string(keyHash), apiKeyLookup{key: apiKey, found: err == nil}, aks.cacheTTL)
+	if err != nil {
+		return repositories.APIKey{}, ErrInvalidAPIKey
+	}
+	return apiKey, nil
+}
+
+func (aks *APIKeyService) GetAll(ctx context.Context) ([]repositories.APIKey, error) {
+	return aks.repository.GetAll(ctx)
+}
+
+// Revoke revokes key, servers, that cached it, accept it until cache expires.
+func (aks *APIKeyService) Revoke(ctx context.Context, id int) error {
+	return aks.repository.Revoke(ctx, id)
+}
+
+func hashAPIKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
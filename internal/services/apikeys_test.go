@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/stretchr/testify/assert"
+)
+
+type APIKeyRepositoryMock struct {
+	keys    map[string]repositories.APIKey
+	lookups int
+}
+
+func (akr *APIKeyRepositoryMock) Insert(
+	_ context.Context,
+	name string,
+	keyHash []byte,
+	limit sql.NullInt32,
+) (int, error) {
+	id := len(akr.keys) + 1
+	akr.keys[string(keyHash)] = repositories.APIKey{ID: id, Name: name, RateLimit: limit}
+	return id, nil
+}
+
+func (akr *APIKeyRepositoryMock) GetByHash(_ context.Context, keyHash []byte) (repositories.APIKey, error) {
+	akr.lookups++
+	key, ok := akr.keys[string(keyHash)]
+	if !ok || key.RevokedAt.Valid {
+		return repositories.APIKey{}, repositories.ErrAPIKeyDoesNotExist
+	}
+	return key, nil
+}
+
+func (akr *APIKeyRepositoryMock) GetAll(_ context.Context) ([]repositories.APIKey, error) {
+	return nil, nil
+}
+
+func (akr *APIKeyRepositoryMock) Revoke(_ context.Context, id int) error {
+	for hash, key := range akr.keys {
+		if key.ID == id {
+			key.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			akr.keys[hash] = key
+			return nil
+		}
+	}
+	return repositories.ErrAPIKeyDoesNotExist
+}
+
+func TestAPIKeyService(t *testing.T) {
+	repository := &APIKeyRepositoryMock{keys: make(map[string]repositories.APIKey)}
+	service := NewAPIKeyService(repository, time.Minute)
+	ctx := context.Background()
+
+	key, id, err := service.Issue(ctx, "backend", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+	assert.NotContains(t, repository.keys, key, "key must be stored hashed")
+
+	apiKey, err := service.Authenticate(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, id, apiKey.ID)
+	assert.Equal(t, int32(100), apiKey.RateLimit.Int32)
+
+	// resolved and unknown keys are cached.
+	_, err = service.Authenticate(ctx, key)
+	assert.NoError(t, err)
+	for range 2 {
+		_, err = service.Authenticate(ctx, APIKeyPrefix+"unknown")
+		assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	}
+	assert.Equal(t, 2, repository.lookups)
+
+	_, err = service.Authenticate(ctx, "without-prefix")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, _, err = service.Issue(ctx, "backend", -1)
+	assert.ErrorIs(t, err, ErrInvalidRateLimit)
+}
+
+func TestAPIKeyService_Revoke(t *testing.T) {
+	repository := &APIKeyRepositoryMock{keys: make(map[string]repositories.APIKey)}
+	service := NewAPIKeyService(repository, 0)
+	ctx := context.Background()
+
+	key, id, err := service.Issue(ctx, "backend", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, service.Revoke(ctx, id))
+	_, err = service.Authenticate(ctx, key)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
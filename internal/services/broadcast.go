@@ -0,0 +1,234 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultBroadcastHistorySize is a number of the latest events kept to resume streams after reconnection.
+	DefaultBroadcastHistorySize = 1000
+	// subscriptionBufferSize is a number of events waiting to be sent to a single stream,
+	// stream is closed when it is full, so slow client resumes from the last received event.
+	subscriptionBufferSize = 64
+)
+
+// RateEvent is a rate change broadcasted to streams, IDs grow monotonically.
+type RateEvent struct {
+	ID   uint64
+	Rate rate.Rate
+}
+
+// RateFilter selects events of the stream, empty pairs or types match everything.
+type RateFilter struct {
+	Pairs []rate.CurrencyPair
+	Types []rate.RateType
+}
+
+func (rf RateFilter) Matches(r rate.Rate) bool {
+	return (len(rf.Pairs) == 0 || slices.Contains(rf.Pairs, r.Pair)) &&
+		(len(rf.Types) == 0 || slices.Contains(rf.Types, r.Type))
+}
+
+type RateSubscription struct {
+	filter RateFilter
+	events chan RateEvent
+}
+
+// Events are closed when subscriber is too slow or broadcaster is closed.
+func (rs *RateSubscription) Events() <-chan RateEvent {
+	return rs.events
+}
+
+type streamedRate struct {
+	pair     rate.CurrencyPair
+	rateType rate.RateType
+}
+
+// RateBroadcaster fans out rate changes to subscribed streams and keeps recent events,
+// so streams can be resumed from the last received event.
+type RateBroadcaster struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []RateEvent
+	historySize int
+	latest      map[streamedRate]RateEvent
+	subscribers map[*RateSubscription]struct{}
+	closed      bool
+}
+
+func NewRateBroadcaster(historySize int) *RateBroadcaster {
+	return &RateBroadcaster{
+		// IDs start from startup time, so IDs of the previous run are never mistaken for the current ones.
+		nextID:      uint64(time.Now().UnixMicro()),
+		historySize: historySize,
+		latest:      make(map[streamedRate]RateEvent),
+		subscribers: make(map[*RateSubscription]struct{}),
+	}
+}
+
+// OnRateFetched broadcasts fetched rate if its value differs from the previous one of the pair.
+func (rb *RateBroadcaster) OnRateFetched(fetched rate.Rate) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	key := streamedRate{pair: fetched.Pair, rateType: fetched.Type}
+	if previous, ok := rb.latest[key]; ok && previous.Rate.Value.Equal(fetched.Value) {
+		return
+	}
+	event := RateEvent{ID: rb.nextID, Rate: fetched}
+	rb.nextID++
+	rb.latest[key] = event
+	rb.history = append(rb.history, event)
+	if len(rb.history) > rb.historySize {
+		rb.history = slices.Delete(rb.history, 0, len(rb.history)-rb.historySize)
+	}
+
+	for subscription := range rb.subscribers {
+		if !subscription.filter.Matches(fetched) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			log.Warn().Msg("Rate stream is too slow, closing it")
+			metrics.GetOrCreateCounter("rate_streams_dropped_total").Inc()
+			rb.remove(subscription)
+		}
+	}
+}
+
+// Subscribe registers stream and returns events it missed. Events after lastEventID are returned
+// if they are still kept, the latest rates of filtered pairs are returned otherwise.
+func (rb *RateBroadcaster) Subscribe(filter RateFilter, lastEventID uint64) (*RateSubscription, []RateEvent) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	subscription := &RateSubscription{filter: filter, events: make(chan RateEvent, subscriptionBufferSize)}
+	if rb.closed {
+		close(subscription.events)
+		return subscription, nil
+	}
+	rb.subscribers[subscription] = struct{}{}
+
+	var missed []RateEvent
+	if rb.canResume(lastEventID) {
+		for _, event := range rb.history {
+			if event.ID > lastEventID && filter.Matches(event.Rate) {
+				missed = append(missed, event)
+			}
+		}
+		return subscription, missed
+	}
+	for _, event := range rb.latest {
+		if filter.Matches(event.Rate) {
+			missed = append(missed, event)
+		}
+	}
+	slices.SortFunc(missed, func(a, b RateEvent) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return subscription, missed
+}
+
+// canResume reports if no events after lastEventID were evicted from history.
+func (rb *RateBroadcaster) canResume(lastEventID uint64) bool {
+	if lastEventID == 0 || lastEventID >= rb.nextID {
+		return false
+	}
+	return len(rb.history) > 0 && lastEventID+1 >= rb.history[0].ID
+}
+
+// UpdateFilter replaces filter of the subscription and returns the latest rates, that only the new filter matches.
+func (rb *RateBroadcaster) UpdateFilter(subscription *RateSubscription, filter RateFilter) []RateEvent {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	previous := subscription.filter
+	subscription.filter = filter
+	var added []RateEvent
+	for _, event := range rb.latest {
+		if filter.Matches(event.Rate) && !previous.Matches(event.Rate) {
+			added = append(added, event)
+		}
+	}
+	slices.SortFunc(added, func(a, b RateEvent) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return added
+}
+
+func (rb *RateBroadcaster) Unsubscribe(subscription *RateSubscription) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.remove(subscription)
+}
+
+func (rb *RateBroadcaster) remove(subscription *RateSubscription) {
+	if _, ok := rb.subscribers[subscription]; ok {
+		delete(rb.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+// Close ends all streams, so server can shut down without waiting for them.
+func (rb *RateBroadcaster) Close() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.closed = true
+	for subscription := range rb.subscribers {
+		rb.remove(subscription)
+	}
+}
+
+// streamedRates returns rates filtered by streams, streams without pair filter are not taken into account,
+// official rate is used for streams without type filter.
+func (rb *RateBroadcaster) streamedRates() []streamedRate {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	var streamed []streamedRate
+	for subscription := range rb.subscribers {
+		types := subscription.filter.Types
+		if len(types) == 0 {
+			types = []rate.RateType{rate.RateTypeOfficial}
+		}
+		for _, pair := range subscription.filter.Pairs {
+			for _, rateType := range types {
+				if key := (streamedRate{pair: pair, rateType: rateType}); !slices.Contains(streamed, key) {
+					streamed = append(streamed, key)
+				}
+			}
+		}
+	}
+	return streamed
+}
+
+// WatchStreamedPairs requests rates of streamed pairs every interval until ctx is cancelled,
+// so they keep being refreshed even if nobody else requests them.
+func (rb *RateBroadcaster) WatchStreamedPairs(ctx context.Context, rateService RateService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, streamed := range rb.streamedRates() {
+					_, err := rateService.GetRate(ctx, streamed.pair, streamed.rateType)
+					if err != nil {
+						log.Warn().Err(err).Stringer("pair", streamed.pair).Msg("Cannot get streamed rate")
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestingRate(pair rate.CurrencyPair, value string) rate.Rate {
+	return rate.Rate{
+		Pair:      pair,
+		Type:      rate.RateTypeOfficial,
+		Value:     decimal.RequireFromString(value),
+		FetchedAt: time.Now(),
+	}
+}
+
+func receiveValues(subscription *RateSubscription) []string {
+	var values []string
+	for {
+		select {
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return values
+			}
+			values = append(values, event.Rate.Value.String())
+		default:
+			return values
+		}
+	}
+}
+
+func TestRateBroadcaster_Filters(t *testing.T) {
+	broadcaster := NewRateBroadcaster(DefaultBroadcastHistorySize)
+	usd, eur := rate.NewCurrencyPair("USD", "UAH"), rate.NewCurrencyPair("EUR", "UAH")
+
+	usdStream, _ := broadcaster.Subscribe(RateFilter{Pairs: []rate.CurrencyPair{usd}}, 0)
+	allStream, _ := broadcaster.Subscribe(RateFilter{}, 0)
+
+	broadcaster.OnRateFetched(newTestingRate(usd, "41"))
+	broadcaster.OnRateFetched(newTestingRate(eur, "44"))
+	// unchanged rate is not broadcasted.
+	broadcaster.OnRateFetched(newTestingRate(usd, "41.00"))
+	broadcaster.OnRateFetched(newTestingRate(usd, "41.2"))
+
+	assert.Equal(t, []string{"41", "41.2"}, receiveValues(usdStream))
+	assert.Equal(t, []string{"41", "44", "41.2"}, receiveValues(allStream))
+}
+
+func TestRateBroadcaster_UpdateFilter(t *testing.T) {
+	broadcaster := NewRateBroadcaster(DefaultBroadcastHistorySize)
+	usd, eur := rate.NewCurrencyPair("USD", "UAH"), rate.NewCurrencyPair("EUR", "UAH")
+	broadcaster.OnRateFetched(newTestingRate(usd, "41"))
+	broadcaster.OnRateFetched(newTestingRate(eur, "44"))
+
+	stream, missed := broadcaster.Subscribe(RateFilter{Pairs: []rate.CurrencyPair{usd}}, 0)
+	assert.Len(t, missed, 1)
+	added := broadcaster.UpdateFilter(stream, RateFilter{Pairs: []rate.CurrencyPair{usd, eur}})
+	assert.Len(t, added, 1)
+	assert.Equal(t, eur, added[0].Rate.Pair)
+
+	broadcaster.UpdateFilter(stream, RateFilter{Pairs: []rate.CurrencyPair{eur}})
+	broadcaster.OnRateFetched(newTestingRate(usd, "41.5"))
+	broadcaster.OnRateFetched(newTestingRate(eur, "44.5"))
+	assert.Equal(t, []string{"44.5"}, receiveValues(stream))
+}
+
+func TestRateBroadcaster_Resume(t *testing.T) {
+	broadcaster := NewRateBroadcaster(3)
+	usd, eur := rate.NewCurrencyPair("USD", "UAH"), rate.NewCurrencyPair("EUR", "UAH")
+	stream, _ := broadcaster.Subscribe(RateFilter{}, 0)
+
+	broadcaster.OnRateFetched(newTestingRate(usd, "41"))
+	broadcaster.OnRateFetched(newTestingRate(eur, "44"))
+	first := <-stream.Events()
+	broadcaster.Unsubscribe(stream)
+
+	_, missed := broadcaster.Subscribe(RateFilter{}, first.ID)
+	assert.Len(t, missed, 1)
+	assert.Equal(t, eur, missed[0].Rate.Pair)
+
+	// events after the last received one are evicted, so the latest rates are sent instead.
+	broadcaster.OnRateFetched(newTestingRate(usd, "41.1"))
+	broadcaster.OnRateFetched(newTestingRate(usd, "41.2"))
+	broadcaster.OnRateFetched(newTestingRate(usd, "41.3"))
+	_, missed = broadcaster.Subscribe(RateFilter{}, first.ID)
+	assert.Len(t, missed, 2)
+	assert.Equal(t, eur, missed[0].Rate.Pair)
+	assert.Equal(t, "41.3", missed[1].Rate.Value.String())
+
+	// unknown ID, e.g. of the previous run, is not resumed.
+	_, missed = broadcaster.Subscribe(RateFilter{Pairs: []rate.CurrencyPair{usd}}, 1)
+	assert.Len(t, missed, 1)
+}
+
+func TestRateBroadcaster_SlowSubscriber(t *testing.T) {
+	broadcaster := NewRateBroadcaster(DefaultBroadcastHistorySize)
+	usd := rate.NewCurrencyPair("USD", "UAH")
+	stream, _ := broadcaster.Subscribe(RateFilter{}, 0)
+
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		broadcaster.OnRateFetched(newTestingRate(usd, decimal.NewFromInt(int64(40+i)).String()))
+	}
+	assert.Len(t, receiveValues(stream), subscriptionBufferSize)
+	_, open := <-stream.Events()
+	assert.False(t, open)
+
+	broadcaster.Close()
+	stream, _ = broadcaster.Subscribe(RateFilter{}, 0)
+	_, open = <-stream.Events()
+	assert.False(t, open)
+}
@@ -2,88 +2,127 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"github.com/fdemchenko/exchanger/internal/communication"
 	"github.com/fdemchenko/exchanger/internal/communication/mailer"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
 	"github.com/rs/zerolog/log"
 )
 
 type RateService interface {
-	GetRate(context.Context, string) (float32, error)
+	GetRate(context.Context, rate.CurrencyPair, rate.RateType) (rate.Rate, error)
+	Supports(rate.CurrencyPair, rate.RateType) bool
 }
 
 type EmailService interface {
-	Create(email string) (int, error)
-	GetAll() ([]string, error)
+	GetDue(now time.Time) ([]repositories.Subscription, error)
+	MarkSent(ids []int, sentAt time.Time) error
 }
 
 type RabbitMQEmailSender struct {
 	emailService EmailService
 	rateService  RateService
-	channel      *amqp.Channel
+	producer     MessageProducer
+	links        *UnsubscribeLinks
 }
 
 func NewRabbitMQEmailSender(
 	emailService EmailService,
 	rateService RateService,
-	channel *amqp.Channel,
+	producer MessageProducer,
+	links *UnsubscribeLinks,
 ) *RabbitMQEmailSender {
 	return &RabbitMQEmailSender{
 		rateService:  rateService,
 		emailService: emailService,
-		channel:      channel,
+		producer:     producer,
+		links:        links,
 	}
 }
 
+// SendMessages sends rates of chosen pairs to subscribers due in the current hour.
+// It is expected to be called once an hour.
 func (es *RabbitMQEmailSender) SendMessages() error {
-	rate, err := es.rateService.GetRate(context.Background(), "usd")
-	if err != nil {
-		return err
-	}
-	rateUpdateMessage := communication.Message[mailer.ExchangeRateUpdatedEvent]{
-		MessageHeader: communication.MessageHeader{Type: mailer.ExchangeRateUpdated, Timestamp: time.Now()},
-		Payload:       mailer.ExchangeRateUpdatedEvent{Rate: rate},
-	}
-	bytes, err := json.Marshal(rateUpdateMessage)
-	if err != nil {
-		return err
-	}
-	err = es.channel.Publish("", mailer.RateEmailsQueue, false, false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        bytes,
-		},
-	)
-	if err != nil {
-		return err
-	}
-
-	emails, err := es.emailService.GetAll()
+	now := time.Now()
+	subscriptions, err := es.emailService.GetDue(now)
 	if err != nil {
 		return err
 	}
 
-	for _, email := range emails {
-		sendEmailMessage := communication.Message[mailer.SendEmailNotificationCommand]{
-			MessageHeader: communication.MessageHeader{Type: mailer.SendEmailNotification, Timestamp: time.Now()},
-			Payload:       mailer.SendEmailNotificationCommand{Email: email},
+	rates := make(map[string]mailer.PairRate)
+	var sentIDs []int
+	for _, subscription := range subscriptions {
+		var subscriberRates []mailer.PairRate
+		for _, pair := range subscription.Pairs {
+			pairRate, ok := rates[pair]
+			if !ok {
+				pairRate, err = es.getPairRate(pair)
+				if err != nil {
+					log.Warn().Err(err).Str("pair", pair).Msg("Cannot get rate for email")
+					continue
+				}
+				rates[pair] = pairRate
+			}
+			subscriberRates = append(subscriberRates, pairRate)
 		}
-		bytes, err := json.Marshal(sendEmailMessage)
-		if err != nil {
+		if len(subscriberRates) == 0 {
 			continue
 		}
-		err = es.channel.Publish("", mailer.RateEmailsQueue, false, false,
-			amqp.Publishing{
-				ContentType: "application/json",
-				Body:        bytes,
+
+		sendEmailMessage := communication.Message[mailer.SendEmailNotificationCommand]{
+			MessageHeader: communication.MessageHeader{Type: mailer.SendEmailNotification, Timestamp: now},
+			Payload: mailer.SendEmailNotificationCommand{
+				Email:          subscription.Email,
+				UnsubscribeURL: es.links.URL(subscription.Email),
+				Rates:          subscriberRates,
 			},
-		)
+		}
+		err = es.publish(sendEmailMessage)
 		if err != nil {
 			log.Error().Err(err).Send()
+			continue
 		}
+		sentIDs = append(sentIDs, subscription.ID)
+	}
+
+	if len(sentIDs) == 0 {
+		return nil
+	}
+	return es.emailService.MarkSent(sentIDs, now)
+}
+
+// getPairRate returns official rate of the pair, or middle market one if there is no official rate,
+// along with retail prices if they are available.
+func (es *RabbitMQEmailSender) getPairRate(pairParam string) (mailer.PairRate, error) {
+	pair, err := rate.ParseCurrencyPair(pairParam)
+	if err != nil {
+		return mailer.PairRate{}, err
+	}
+	rateType := rate.RateTypeOfficial
+	if !es.rateService.Supports(pair, rateType) {
+		rateType = rate.RateTypeMid
 	}
-	return nil
+	currentRate, err := es.rateService.GetRate(context.Background(), pair, rateType)
+	if err != nil {
+		return mailer.PairRate{}, err
+	}
+	pairRate := mailer.PairRate{Pair: pair.String(), Rate: currentRate.Value}
+	// retail prices are nice to have, so email is sent without them if they are unavailable.
+	if !es.rateService.Supports(pair, rate.RateTypeBuy) {
+		return pairRate, nil
+	}
+	retailRate, err := es.rateService.GetRate(context.Background(), pair, rate.RateTypeBuy)
+	if err != nil {
+		log.Warn().Err(err).Str("pair", pair.String()).Msg("Cannot get retail rates for email")
+	} else {
+		pairRate.Buy = retailRate.Quote.Bid
+		pairRate.Sell = retailRate.Quote.Ask
+	}
+	return pairRate, nil
+}
+
+func (es *RabbitMQEmailSender) publish(message any) error {
+	return es.producer.SendMessage(message, mailer.RateEmailsQueue)
 }
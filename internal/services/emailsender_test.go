@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type DueEmailServiceStub struct {
+	subscriptions []repositories.Subscription
+	sent          []int
+}
+
+func (des *DueEmailServiceStub) GetDue(_ time.Time) ([]repositories.Subscription, error) {
+	return des.subscriptions, nil
+}
+
+func (des *DueEmailServiceStub) MarkSent(ids []int, _ time.Time) error {
+	des.sent = append(des.sent, ids...)
+	return nil
+}
+
+type PairRateServiceStub struct {
+	types     map[rate.CurrencyPair][]rate.RateType
+	requested []rate.RateType
+}
+
+func (prs *PairRateServiceStub) GetRate(
+	_ context.Context,
+	pair rate.CurrencyPair,
+	rateType rate.RateType,
+) (rate.Rate, error) {
+	prs.requested = append(prs.requested, rateType)
+	return rate.Rate{
+		Pair:  pair,
+		Type:  rateType,
+		Value: decimal.NewFromInt(41),
+		Quote: rate.Quote{Bid: decimal.NewFromInt(40), Ask: decimal.NewFromInt(42)},
+	}, nil
+}
+
+func (prs *PairRateServiceStub) Supports(pair rate.CurrencyPair, rateType rate.RateType) bool {
+	return slices.Contains(prs.types[pair], rateType)
+}
+
+func TestRabbitMQEmailSender_PairRates(t *testing.T) {
+	emailService := &DueEmailServiceStub{subscriptions: []repositories.Subscription{
+		{ID: 1, Email: "a@example.com", Preferences: repositories.Preferences{Pairs: []string{"USD-UAH", "GBP-PLN"}}},
+	}}
+	rateService := &PairRateServiceStub{types: map[rate.CurrencyPair][]rate.RateType{
+		rate.NewCurrencyPair("USD", "UAH"): {rate.RateTypeOfficial, rate.RateTypeBuy},
+		rate.NewCurrencyPair("GBP", "PLN"): {rate.RateTypeMid},
+	}}
+	producer := &ProducerMock{}
+	sender := NewRabbitMQEmailSender(emailService, rateService, producer, TestingUnsubscribeLinks)
+
+	assert.NoError(t, sender.SendMessages())
+	// pair without official rate is sent with middle market one and without retail rates.
+	assert.Equal(t, []rate.RateType{rate.RateTypeOfficial, rate.RateTypeBuy, rate.RateTypeMid},
+		rateService.requested)
+	assert.Len(t, producer.commands, 1)
+	assert.True(t, decimal.NewFromInt(40).Equal(producer.commands[0].Rates[0].Buy))
+	assert.True(t, producer.commands[0].Rates[1].Buy.IsZero())
+	assert.Equal(t, []int{1}, emailService.sent)
+}
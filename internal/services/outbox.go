@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultOutboxPollInterval = time.Second
+	// OutboxRetention is a time published messages are kept in outbox for.
+	OutboxRetention = 24 * time.Hour
+
+	outboxBatchSize      = 100
+	outboxPublishTimeout = 5 * time.Second
+	// outboxClaimPeriod is a time batch of messages must be published within, messages of relay,
+	// that has crashed, are published by others after it.
+	outboxClaimPeriod     = time.Minute
+	outboxCleanupInterval = time.Hour
+)
+
+type OutboxRepository interface {
+	ClaimPending(ctx context.Context, limit int, claimedUntil time.Time) ([]repositories.OutboxMessage, error)
+	MarkSent(ctx context.Context, ids []int64) error
+	Release(ctx context.Context, ids []int64) error
+	DeleteSent(ctx context.Context, sentBefore time.Time) (int64, error)
+}
+
+// ConfirmedPublisher returns after broker confirms it has taken responsibility for the message.
+type ConfirmedPublisher interface {
+	Publish(ctx context.Context, queue string, body []byte) error
+}
+
+// OutboxRelay publishes messages saved to outbox, message is marked sent only after broker confirms it,
+// so messages survive broker restarts and may be delivered more than once.
+type OutboxRelay struct {
+	repository OutboxRepository
+	publisher  ConfirmedPublisher
+	wake       chan struct{}
+}
+
+func NewOutboxRelay(repository OutboxRepository, publisher ConfirmedPublisher) *OutboxRelay {
+	return &OutboxRelay{
+		repository: repository,
+		publisher:  publisher,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Notify makes relay publish pending messages without waiting for the next poll.
+func (orl *OutboxRelay) Notify() {
+	select {
+	case orl.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start relays pending messages every interval and on notification until ctx is cancelled.
+func (orl *OutboxRelay) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		cleanup := time.NewTicker(outboxCleanupInterval)
+		defer cleanup.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-orl.wake:
+			case <-cleanup.C:
+				deleted, err := orl.repository.DeleteSent(ctx, time.Now().Add(-OutboxRetention))
+				if err != nil {
+					log.Error().Err(err).Msg("Cannot delete published outbox messages")
+					continue
+				}
+				log.Debug().Int64("deleted", deleted).Msg("Published outbox messages deleted")
+				continue
+			case <-ctx.Done():
+				return
+			}
+			if err := orl.Relay(ctx); err != nil {
+				log.Error().Err(err).Msg("Cannot relay outbox messages")
+			}
+		}
+	}()
+}
+
+// Relay publishes pending messages until none is left or publishing fails.
+func (orl *OutboxRelay) Relay(ctx context.Context) error {
+	for {
+		published, err := orl.relayBatch(ctx)
+		metrics.GetOrCreateCounter("outbox_messages_published_total").Add(published)
+		if err != nil {
+			metrics.GetOrCreateCounter("outbox_relay_errors_total").Inc()
+			return err
+		}
+		if published < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// relayBatch claims pending messages and publishes them once claim is committed, so no transaction is kept
+// open while broker confirms messages. Messages, that are not published, are released.
+func (orl *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	messages, err := orl.repository.ClaimPending(ctx, outboxBatchSize, time.Now().Add(outboxClaimPeriod))
+	if err != nil {
+		return 0, err
+	}
+	claimCtx, cancel := context.WithTimeout(ctx, outboxClaimPeriod)
+	defer cancel()
+
+	var published []int64
+	var publishErr error
+	for i, msg := range messages {
+		publishCtx, cancel := context.WithTimeout(claimCtx, outboxPublishTimeout)
+		publishErr = orl.publisher.Publish(publishCtx, msg.Queue, msg.Payload)
+		cancel()
+		if publishErr != nil {
+			ids := make([]int64, 0, len(messages)-i)
+			for _, unpublished := range messages[i:] {
+				ids = append(ids, unpublished.ID)
+			}
+			if err := orl.repository.Release(context.WithoutCancel(ctx), ids); err != nil {
+				publishErr = errors.Join(publishErr, err)
+			}
+			break
+		}
+		published = append(published, msg.ID)
+	}
+	// messages published before failure are still marked, so they are not sent twice.
+	if len(published) > 0 {
+		if err := orl.repository.MarkSent(context.WithoutCancel(ctx), published); err != nil {
+			return 0, err
+		}
+	}
+	return len(published), publishErr
+}
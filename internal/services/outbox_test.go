@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/stretchr/testify/assert"
+)
+
+type OutboxRepositoryMock struct {
+	pending []repositories.OutboxMessage
+	claimed []repositories.OutboxMessage
+	sent    []int64
+}
+
+func (orm *OutboxRepositoryMock) ClaimPending(
+	_ context.Context,
+	limit int,
+	_ time.Time,
+) ([]repositories.OutboxMessage, error) {
+	n := min(limit, len(orm.pending))
+	orm.claimed = append(orm.claimed, orm.pending[:n]...)
+	orm.pending = orm.pending[n:]
+	return slices.Clone(orm.claimed[len(orm.claimed)-n:]), nil
+}
+
+func (orm *OutboxRepositoryMock) MarkSent(_ context.Context, ids []int64) error {
+	orm.sent = append(orm.sent, ids...)
+	orm.claimed = slices.DeleteFunc(orm.claimed, func(msg repositories.OutboxMessage) bool {
+		return slices.Contains(ids, msg.ID)
+	})
+	return nil
+}
+
+func (orm *OutboxRepositoryMock) Release(_ context.Context, ids []int64) error {
+	var released []repositories.OutboxMessage
+	orm.claimed = slices.DeleteFunc(orm.claimed, func(msg repositories.OutboxMessage) bool {
+		if slices.Contains(ids, msg.ID) {
+			released = append(released, msg)
+			return true
+		}
+		return false
+	})
+	orm.pending = append(released, orm.pending...)
+	return nil
+}
+
+func (orm *OutboxRepositoryMock) DeleteSent(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+// PublisherMock fails once broker is down.
+type PublisherMock struct {
+	queues []string
+	down   bool
+}
+
+func (pm *PublisherMock) Publish(_ context.Context, queue string, _ []byte) error {
+	if pm.down {
+		return errors.New("connection is closed")
+	}
+	pm.queues = append(pm.queues, queue)
+	return nil
+}
+
+func TestOutboxRelay(t *testing.T) {
+	repository := &OutboxRepositoryMock{}
+	for id := range int64(outboxBatchSize + 1) {
+		repository.pending = append(repository.pending, repositories.OutboxMessage{ID: id + 1, Queue: "queue"})
+	}
+	publisher := &PublisherMock{down: true}
+	relay := NewOutboxRelay(repository, publisher)
+
+	// messages stay in outbox until broker is available, claims of unpublished messages are dropped.
+	assert.Error(t, relay.Relay(context.Background()))
+	assert.Len(t, repository.pending, outboxBatchSize+1)
+	assert.Empty(t, repository.claimed)
+	assert.Equal(t, int64(1), repository.pending[0].ID)
+
+	publisher.down = false
+	assert.NoError(t, relay.Relay(context.Background()))
+	assert.Empty(t, repository.pending)
+	assert.Len(t, publisher.queues, outboxBatchSize+1)
+	assert.Equal(t, int64(1), repository.sent[0])
+}
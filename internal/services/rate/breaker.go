@@ -0,0 +1,186 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultBreakerFailureThreshold = 3
+	DefaultBreakerCoolDown         = 30 * time.Second
+)
+
+var ErrProviderUnavailable = errors.New("rate provider is temporarily unavailable")
+
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through to the provider.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects requests without reaching the provider until cool-down elapses.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial request through to check whether provider has recovered.
+	BreakerHalfOpen
+)
+
+func (bs BreakerState) String() string {
+	switch bs {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// ProviderStatus is a snapshot of rate provider health.
+type ProviderStatus struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+// circuitBreakerFetcher wraps fetcher and stops calling it after failureThreshold consecutive failures.
+type circuitBreakerFetcher struct {
+	RateFetcher
+	failureThreshold int
+	coolDown         time.Duration
+
+	mu                  sync.Mutex
+	state               BreakerState
+	consecutiveFailures int
+	lastError           error
+	openedAt            time.Time
+	trialInFlight       bool
+}
+
+func newCircuitBreakerFetcher(
+	fetcher RateFetcher,
+	failureThreshold int,
+	coolDown time.Duration,
+) *circuitBreakerFetcher {
+	cbf := &circuitBreakerFetcher{
+		RateFetcher:      fetcher,
+		failureThreshold: failureThreshold,
+		coolDown:         coolDown,
+	}
+	metrics.GetOrCreateGauge(fmt.Sprintf(`rate_provider_state{provider=%q}`, fetcher.Name()), func() float64 {
+		return float64(cbf.State())
+	})
+	return cbf
+}
+
+func (cbf *circuitBreakerFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
+	if !cbf.allow() {
+		return Quote{}, ErrProviderUnavailable
+	}
+
+	quote, err := cbf.RateFetcher.Fetch(ctx, pair, client)
+	switch {
+	// provider has responded, so it is healthy even if it does not know the currency.
+	case err == nil || errors.Is(err, ErrInvalidCurrencyCode):
+		cbf.onSuccess()
+	// caller gave up, this says nothing about provider health.
+	case ctx.Err() != nil:
+		cbf.releaseTrial()
+	default:
+		cbf.onFailure(err)
+	}
+	return quote, err
+}
+
+func (cbf *circuitBreakerFetcher) State() BreakerState {
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+	return cbf.state
+}
+
+func (cbf *circuitBreakerFetcher) Status() ProviderStatus {
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+
+	status := ProviderStatus{
+		Name:                cbf.Name(),
+		State:               cbf.state.String(),
+		ConsecutiveFailures: cbf.consecutiveFailures,
+	}
+	if cbf.lastError != nil {
+		status.LastError = cbf.lastError.Error()
+	}
+	if cbf.state != BreakerClosed {
+		openedAt := cbf.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+func (cbf *circuitBreakerFetcher) allow() bool {
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+
+	switch cbf.state {
+	case BreakerOpen:
+		if time.Since(cbf.openedAt) < cbf.coolDown {
+			return false
+		}
+		cbf.state = BreakerHalfOpen
+		cbf.trialInFlight = true
+		return true
+	case BreakerHalfOpen:
+		if cbf.trialInFlight {
+			return false
+		}
+		cbf.trialInFlight = true
+		return true
+	case BreakerClosed:
+	}
+	return true
+}
+
+func (cbf *circuitBreakerFetcher) onSuccess() {
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+
+	if cbf.state != BreakerClosed {
+		log.Info().Str("provider", cbf.Name()).Msg("Rate provider recovered, circuit closed")
+	}
+	cbf.state = BreakerClosed
+	cbf.consecutiveFailures = 0
+	cbf.lastError = nil
+	cbf.trialInFlight = false
+}
+
+func (cbf *circuitBreakerFetcher) onFailure(err error) {
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+
+	metrics.GetOrCreateCounter(fmt.Sprintf(`rate_provider_failures_total{provider=%q}`, cbf.Name())).Inc()
+	cbf.consecutiveFailures++
+	cbf.lastError = err
+	cbf.trialInFlight = false
+	if cbf.state == BreakerHalfOpen || cbf.consecutiveFailures >= cbf.failureThreshold {
+		if cbf.state != BreakerOpen {
+			log.Warn().Str("provider", cbf.Name()).Int("failures", cbf.consecutiveFailures).
+				Msg("Rate provider circuit opened")
+		}
+		cbf.state = BreakerOpen
+		cbf.openedAt = time.Now()
+	}
+}
+
+func (cbf *circuitBreakerFetcher) releaseTrial() {
+	cbf.mu.Lock()
+	defer cbf.mu.Unlock()
+	cbf.trialInFlight = false
+}
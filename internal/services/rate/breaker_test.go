@@ -0,0 +1,86 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+const TestingBreakerCoolDown = 100 * time.Millisecond
+
+var ErrProviderIsDown = errors.New("provider is down")
+
+func TestCircuitBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrProviderIsDown)
+
+	breaker := newCircuitBreakerFetcher(mockNBUFetcher, 2, TestingBreakerCoolDown)
+	for range 2 {
+		_, err := breaker.Fetch(ctx, TestingCurrencyPair, nil)
+		assert.ErrorIs(t, err, ErrProviderIsDown)
+	}
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// Open breaker must not reach the provider.
+	_, err := breaker.Fetch(ctx, TestingCurrencyPair, nil)
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	mockNBUFetcher.AssertNumberOfCalls(t, "Fetch", 2)
+}
+
+func TestCircuitBreaker_ClosesAfterSuccessfulTrial(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrProviderIsDown).Once()
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+
+	breaker := newCircuitBreakerFetcher(mockNBUFetcher, 1, TestingBreakerCoolDown)
+	_, _ = breaker.Fetch(ctx, TestingCurrencyPair, nil)
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	time.Sleep(2 * TestingBreakerCoolDown)
+	quote, err := breaker.Fetch(ctx, TestingCurrencyPair, nil)
+	assert.NoError(t, err)
+	assertDecimalEqual(t, ExpectedExchangeRate, quote.Official)
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, 0, breaker.Status().ConsecutiveFailures)
+}
+
+func TestCircuitBreaker_InvalidCurrencyIsNotFailure(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrInvalidCurrencyCode)
+
+	breaker := newCircuitBreakerFetcher(mockNBUFetcher, 1, TestingBreakerCoolDown)
+	_, _ = breaker.Fetch(ctx, TestingCurrencyPair, nil)
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestRateService_SkipsOpenProviders(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrProviderIsDown)
+
+	mockFawazFetcher := new(MockFawazFetcher)
+	mockFawazFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher, mockFawazFetcher),
+		WithUpdateInterval(time.Nanosecond),
+		WithCircuitBreaker(1, time.Hour),
+	)
+	for range 3 {
+		rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+		assert.NoError(t, err)
+		assertDecimalEqual(t, ExpectedExchangeRate, rate.Value)
+	}
+
+	mockNBUFetcher.AssertNumberOfCalls(t, "Fetch", 1)
+	statuses := rateService.Providers()
+	assert.Equal(t, BreakerOpen.String(), statuses[0].State)
+	assert.Equal(t, BreakerClosed.String(), statuses[1].State)
+}
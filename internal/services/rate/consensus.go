@@ -0,0 +1,137 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const DefaultMaxDeviation = 0.05
+
+var ErrNoConsensus = errors.New("providers do not agree on rate")
+
+type providerRate struct {
+	provider string
+	quote    Quote
+	rate     decimal.Decimal
+	err      error
+}
+
+func (crs *cachingRateService) fetchConsensus(
+	ctx context.Context,
+	pair CurrencyPair,
+	rateType RateType,
+	fetchers []RateFetcher,
+) (Rate, error) {
+	results := make(chan providerRate, len(fetchers))
+	for _, fetcher := range fetchers {
+		go func(fetcher RateFetcher) {
+			quote, rate, err := crs.fetchQuote(ctx, fetcher, pair, rateType)
+			results <- providerRate{provider: fetcher.Name(), quote: quote, rate: rate, err: err}
+		}(fetcher)
+	}
+
+	var errs []error
+	var fetched []providerRate
+	for range fetchers {
+		result := <-results
+		log.Debug().Str("name", result.provider).Stringer("pair", pair).Stringer("rate", result.rate).
+			Err(result.err).Send()
+		if result.err != nil {
+			log.Warn().Str("provider", result.provider).Err(result.err).Msg("Provider excluded from consensus")
+			errs = append(errs, result.err)
+			continue
+		}
+		fetched = append(fetched, result)
+	}
+	if len(fetched) == 0 {
+		return Rate{}, allProvidersFailed(errs)
+	}
+
+	agreed := discardOutliers(fetched, decimal.NewFromFloat(crs.maxDeviation))
+	if len(agreed) == 0 {
+		return Rate{}, ErrNoConsensus
+	}
+
+	rates := make([]decimal.Decimal, 0, len(agreed))
+	quotes := make([]Quote, 0, len(agreed))
+	providers := make([]string, 0, len(agreed))
+	for _, result := range agreed {
+		rates = append(rates, result.rate)
+		quotes = append(quotes, result.quote)
+		providers = append(providers, result.provider)
+	}
+	return Rate{
+		Pair:      pair,
+		Type:      rateType,
+		Value:     median(rates),
+		Quote:     medianQuote(quotes),
+		Providers: providers,
+		FetchedAt: time.Now(),
+	}, nil
+}
+
+// discardOutliers keeps only rates, which relative deviation from the median does not exceed maxDeviation.
+func discardOutliers(fetched []providerRate, maxDeviation decimal.Decimal) []providerRate {
+	rates := make([]decimal.Decimal, 0, len(fetched))
+	for _, result := range fetched {
+		rates = append(rates, result.rate)
+	}
+	middle := median(rates)
+
+	var agreed []providerRate
+	for _, result := range fetched {
+		if middle.IsZero() {
+			continue
+		}
+		deviation := result.rate.Sub(middle).Abs().Div(middle)
+		if deviation.LessThanOrEqual(maxDeviation) {
+			agreed = append(agreed, result)
+		} else {
+			log.Warn().Str("provider", result.provider).Stringer("rate", result.rate).
+				Stringer("deviation", deviation).Msg("Provider rate discarded as outlier")
+		}
+	}
+	return agreed
+}
+
+// medianQuote combines quotes of several providers taking median of every published price.
+func medianQuote(quotes []Quote) Quote {
+	var bids, asks, officials []decimal.Decimal
+	var combined Quote
+	for _, quote := range quotes {
+		if !quote.Bid.IsZero() {
+			bids = append(bids, quote.Bid)
+		}
+		if !quote.Ask.IsZero() {
+			asks = append(asks, quote.Ask)
+		}
+		if !quote.Official.IsZero() {
+			officials = append(officials, quote.Official)
+		}
+		if quote.Timestamp.After(combined.Timestamp) {
+			combined.Timestamp = quote.Timestamp
+		}
+	}
+	combined.Bid = median(bids)
+	combined.Ask = median(asks)
+	combined.Official = median(officials)
+	return combined
+}
+
+func median(values []decimal.Decimal) decimal.Decimal {
+	if len(values) == 0 {
+		return decimal.Zero
+	}
+	sorted := slices.Clone(values)
+	slices.SortFunc(sorted, decimal.Decimal.Cmp)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return sorted[middle-1].Add(sorted[middle]).Div(decimal.NewFromInt(2))
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,92 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateService_ConsensusReturnsMedian(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(40.0), nil)
+
+	mockFawazFetcher := new(MockFawazFetcher)
+	mockFawazFetcher.On("Fetch").Return(decimal.NewFromFloat(41.0), nil)
+
+	mockPrivatFetcher := new(MockPrivatFetcher)
+	mockPrivatFetcher.On("Fetch").Return(decimal.NewFromFloat(40.5), nil)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher, mockFawazFetcher, mockPrivatFetcher),
+		WithStrategy(ConsensusStrategy),
+	)
+
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assertDecimalEqual(t, decimal.NewFromFloat(40.5), rate.Value)
+	assert.ElementsMatch(t, []string{"nbu fetcher", "fawaz fetcher", "privat fetcher"}, rate.Providers)
+}
+
+func TestRateService_ConsensusDiscardsOutliers(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(40.0), nil)
+
+	mockFawazFetcher := new(MockFawazFetcher)
+	mockFawazFetcher.On("Fetch").Return(decimal.NewFromFloat(400.0), nil)
+
+	mockPrivatFetcher := new(MockPrivatFetcher)
+	mockPrivatFetcher.On("Fetch").Return(decimal.NewFromFloat(41.0), nil)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher, mockFawazFetcher, mockPrivatFetcher),
+		WithStrategy(ConsensusStrategy),
+		WithMaxDeviation(0.1),
+	)
+
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assertDecimalEqual(t, decimal.NewFromFloat(40.5), rate.Value)
+	assert.ElementsMatch(t, []string{"nbu fetcher", "privat fetcher"}, rate.Providers)
+}
+
+func TestRateService_ConsensusIgnoresFailedProviders(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), errors.New("provider is down"))
+
+	mockFawazFetcher := new(MockFawazFetcher)
+	mockFawazFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher, mockFawazFetcher),
+		WithStrategy(ConsensusStrategy),
+	)
+
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assertDecimalEqual(t, ExpectedExchangeRate, rate.Value)
+	assert.Equal(t, []string{"fawaz fetcher"}, rate.Providers)
+}
+
+func TestRateService_NoConsensus(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(40.0), nil)
+
+	mockFawazFetcher := new(MockFawazFetcher)
+	mockFawazFetcher.On("Fetch").Return(decimal.NewFromFloat(50.0), nil)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher, mockFawazFetcher),
+		WithStrategy(ConsensusStrategy),
+		WithMaxDeviation(0.01),
+	)
+
+	_, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.ErrorIs(t, err, ErrNoConsensus)
+}
@@ -0,0 +1,153 @@
+package rate
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/shopspring/decimal"
+)
+
+// CrossCurrency is a currency amounts are converted through when there is no rate of the pair.
+const CrossCurrency = DefaultQuoteCurrency
+
+var ErrRateNotFound = errors.New("rate is not found")
+
+// RateGetter provides current rates.
+type RateGetter interface {
+	GetRate(ctx context.Context, pair CurrencyPair, rateType RateType) (Rate, error)
+}
+
+// RateArchive provides rates recorded in the past.
+type RateArchive interface {
+	GetLatest(ctx context.Context, base, quote, rateType string, before time.Time) (repositories.RateRecord, error)
+}
+
+// Conversion is a result of converting amount of Pair.Base currency into Pair.Quote currency.
+type Conversion struct {
+	Pair   CurrencyPair
+	Amount decimal.Decimal
+	Result decimal.Decimal
+	// Rate is an effective rate the amount was multiplied by.
+	Rate decimal.Decimal
+	// Via is a cross currency, empty if amount was converted directly.
+	Via  string
+	Legs []ConversionLeg
+}
+
+// ConversionLeg is a rate used for one step of conversion. Inverted leg converts Rate.Pair.Quote
+// into Rate.Pair.Base, so amount is divided by the rate.
+type ConversionLeg struct {
+	Rate     Rate
+	Inverted bool
+}
+
+func (cl ConversionLeg) value() decimal.Decimal {
+	if cl.Inverted {
+		return decimal.NewFromInt(1).Div(cl.Rate.Value)
+	}
+	return cl.Rate.Value
+}
+
+type Converter struct {
+	rates   RateGetter
+	archive RateArchive
+}
+
+func NewConverter(rates RateGetter, archive RateArchive) *Converter {
+	return &Converter{rates: rates, archive: archive}
+}
+
+// Convert converts amount using official rates. Rates of the pair are looked up directly, then inverted,
+// then through the cross currency. Current rates are used if at is zero, otherwise the latest rates
+// recorded before at.
+func (c *Converter) Convert(
+	ctx context.Context,
+	pair CurrencyPair,
+	amount decimal.Decimal,
+	at time.Time,
+) (Conversion, error) {
+	conversion := Conversion{Pair: pair, Amount: amount, Rate: decimal.NewFromInt(1)}
+	if pair.Base == pair.Quote {
+		conversion.Result = amount
+		return conversion, nil
+	}
+
+	leg, err := c.findLeg(ctx, pair, at)
+	switch {
+	case err == nil:
+		conversion.Legs = []ConversionLeg{leg}
+	case isMissingRate(err) && pair.Base != CrossCurrency && pair.Quote != CrossCurrency:
+		conversion.Legs, err = c.findCrossLegs(ctx, pair, at)
+		if err != nil {
+			return Conversion{}, err
+		}
+		conversion.Via = CrossCurrency
+	default:
+		return Conversion{}, err
+	}
+
+	for _, leg := range conversion.Legs {
+		conversion.Rate = conversion.Rate.Mul(leg.value())
+	}
+	conversion.Result = amount.Mul(conversion.Rate)
+	return conversion, nil
+}
+
+func (c *Converter) findCrossLegs(ctx context.Context, pair CurrencyPair, at time.Time) ([]ConversionLeg, error) {
+	first, err := c.findLeg(ctx, NewCurrencyPair(pair.Base, CrossCurrency), at)
+	if err != nil {
+		return nil, err
+	}
+	second, err := c.findLeg(ctx, NewCurrencyPair(CrossCurrency, pair.Quote), at)
+	if err != nil {
+		return nil, err
+	}
+	return []ConversionLeg{first, second}, nil
+}
+
+// findLeg returns rate of the pair, falling back to inverted rate of the reversed pair.
+func (c *Converter) findLeg(ctx context.Context, pair CurrencyPair, at time.Time) (ConversionLeg, error) {
+	rate, err := c.getRate(ctx, pair, at)
+	if err == nil {
+		return ConversionLeg{Rate: rate}, nil
+	}
+	if !isMissingRate(err) {
+		return ConversionLeg{}, err
+	}
+
+	rate, err = c.getRate(ctx, NewCurrencyPair(pair.Quote, pair.Base), at)
+	if err != nil {
+		return ConversionLeg{}, err
+	}
+	return ConversionLeg{Rate: rate, Inverted: true}, nil
+}
+
+func (c *Converter) getRate(ctx context.Context, pair CurrencyPair, at time.Time) (Rate, error) {
+	if at.IsZero() {
+		return c.rates.GetRate(ctx, pair, RateTypeOfficial)
+	}
+
+	record, err := c.archive.GetLatest(ctx, pair.Base, pair.Quote, string(RateTypeOfficial), at)
+	if err != nil {
+		if errors.Is(err, repositories.ErrRateDoesNotExist) {
+			return Rate{}, ErrRateNotFound
+		}
+		return Rate{}, err
+	}
+	return Rate{
+		Pair:      pair,
+		Type:      RateTypeOfficial,
+		Value:     record.Rate,
+		Quote:     Quote{Official: record.Rate, Timestamp: record.FetchedAt},
+		Providers: []string{record.Provider},
+		FetchedAt: record.FetchedAt,
+	}, nil
+}
+
+// isMissingRate reports whether error means the rate does not exist, so it may be obtained another way.
+func isMissingRate(err error) bool {
+	return errors.Is(err, ErrUnsupportedPair) || errors.Is(err, ErrInvalidCurrencyCode) ||
+		errors.Is(err, ErrRateNotFound)
+}
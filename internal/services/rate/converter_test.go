@@ -0,0 +1,119 @@
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type RateGetterStub map[CurrencyPair]decimal.Decimal
+
+func (rgs RateGetterStub) GetRate(_ context.Context, pair CurrencyPair, rateType RateType) (Rate, error) {
+	value, ok := rgs[pair]
+	if !ok {
+		return Rate{}, ErrUnsupportedPair
+	}
+	return Rate{Pair: pair, Type: rateType, Value: value, Providers: []string{"stub"}, FetchedAt: time.Now()}, nil
+}
+
+type RateArchiveStub []repositories.RateRecord
+
+func (ras RateArchiveStub) GetLatest(
+	_ context.Context,
+	base, quote, rateType string,
+	before time.Time,
+) (repositories.RateRecord, error) {
+	var latest *repositories.RateRecord
+	for i, record := range ras {
+		if record.Base != base || record.Quote != quote || record.Type != rateType || !record.FetchedAt.Before(before) {
+			continue
+		}
+		if latest == nil || record.FetchedAt.After(latest.FetchedAt) {
+			latest = &ras[i]
+		}
+	}
+	if latest == nil {
+		return repositories.RateRecord{}, repositories.ErrRateDoesNotExist
+	}
+	return *latest, nil
+}
+
+func TestConverter_Direct(t *testing.T) {
+	converter := NewConverter(RateGetterStub{
+		NewCurrencyPair("USD", "UAH"): decimal.RequireFromString("41.25"),
+	}, nil)
+
+	conversion, err := converter.Convert(context.Background(), NewCurrencyPair("USD", "UAH"),
+		decimal.RequireFromString("125.50"), time.Time{})
+	assert.NoError(t, err)
+	assertDecimalEqual(t, decimal.RequireFromString("5176.875"), conversion.Result)
+	assertDecimalEqual(t, decimal.RequireFromString("41.25"), conversion.Rate)
+	assert.Empty(t, conversion.Via)
+	assert.Len(t, conversion.Legs, 1)
+	assert.False(t, conversion.Legs[0].Inverted)
+}
+
+func TestConverter_Inverse(t *testing.T) {
+	converter := NewConverter(RateGetterStub{
+		NewCurrencyPair("USD", "UAH"): decimal.NewFromInt(40),
+	}, nil)
+
+	conversion, err := converter.Convert(context.Background(), NewCurrencyPair("UAH", "USD"),
+		decimal.NewFromInt(1000), time.Time{})
+	assert.NoError(t, err)
+	assertDecimalEqual(t, decimal.NewFromInt(25), conversion.Result)
+	assert.True(t, conversion.Legs[0].Inverted)
+}
+
+func TestConverter_CrossRate(t *testing.T) {
+	converter := NewConverter(RateGetterStub{
+		NewCurrencyPair("USD", "UAH"): decimal.NewFromInt(40),
+		NewCurrencyPair("EUR", "UAH"): decimal.NewFromInt(44),
+	}, nil)
+
+	conversion, err := converter.Convert(context.Background(), NewCurrencyPair("EUR", "USD"),
+		decimal.NewFromInt(100), time.Time{})
+	assert.NoError(t, err)
+	assertDecimalEqual(t, decimal.NewFromInt(110), conversion.Result)
+	assert.Equal(t, CrossCurrency, conversion.Via)
+	assert.Len(t, conversion.Legs, 2)
+	assert.False(t, conversion.Legs[0].Inverted)
+	assert.True(t, conversion.Legs[1].Inverted)
+}
+
+func TestConverter_Unsupported(t *testing.T) {
+	converter := NewConverter(RateGetterStub{
+		NewCurrencyPair("USD", "UAH"): decimal.NewFromInt(40),
+	}, nil)
+
+	_, err := converter.Convert(context.Background(), NewCurrencyPair("GBP", "USD"),
+		decimal.NewFromInt(100), time.Time{})
+	assert.ErrorIs(t, err, ErrUnsupportedPair)
+}
+
+func TestConverter_Historical(t *testing.T) {
+	day := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	archive := RateArchiveStub{
+		{Provider: "nbu", Base: "USD", Quote: "UAH", Type: "official",
+			Rate: decimal.NewFromInt(39), FetchedAt: day.Add(-time.Hour)},
+		{Provider: "privat", Base: "USD", Quote: "UAH", Type: "official",
+			Rate: decimal.NewFromInt(40), FetchedAt: day.Add(10 * time.Hour)},
+		{Provider: "nbu", Base: "USD", Quote: "UAH", Type: "official",
+			Rate: decimal.NewFromInt(41), FetchedAt: day.Add(30 * time.Hour)},
+	}
+	converter := NewConverter(RateGetterStub{}, archive)
+
+	conversion, err := converter.Convert(context.Background(), NewCurrencyPair("USD", "UAH"),
+		decimal.NewFromInt(2), day.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assertDecimalEqual(t, decimal.NewFromInt(80), conversion.Result)
+	assert.Equal(t, []string{"privat"}, conversion.Legs[0].Rate.Providers)
+
+	_, err = converter.Convert(context.Background(), NewCurrencyPair("USD", "UAH"),
+		decimal.NewFromInt(2), day.Add(-24*time.Hour))
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
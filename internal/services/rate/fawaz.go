@@ -6,17 +6,19 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 const (
 	FawazAhmedExchangeRateURL = "https://cdn.jsdelivr.net/npm/@fawazahmed0/currency-api@latest/v1/currencies"
+	FawazDateLayout           = "2006-01-02"
 )
 
-type FawazAhmedResponse struct {
-	Rates struct {
-		UAH float32 `json:"uah"`
-	} `json:"usd"`
-}
+// FawazAhmedResponse maps lowercase base currency code to its rates against every other currency,
+// e.g. {"date": "2024-06-01", "usd": {"uah": 40.5, "eur": 0.92}}.
+type FawazAhmedResponse map[string]json.RawMessage
 
 type FawazRateFetcher struct {
 	name string
@@ -30,29 +32,57 @@ func NewFawazRateFetcher(name string) FawazRateFetcher {
 	return FawazRateFetcher{name: name}
 }
 
-func (frf FawazRateFetcher) Fetch(ctx context.Context, code string, client *http.Client) (float32, error) {
+// Supports reports whether pair can be served, the API provides middle market cross rates
+// for any pair of currencies, but no official ones.
+func (frf FawazRateFetcher) Supports(pair CurrencyPair, rateType RateType) bool {
+	return pair.Base != pair.Quote && rateType == RateTypeMid
+}
+
+func (frf FawazRateFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
 	ctx, cancel := context.WithTimeout(ctx, RateFetchTimeout)
 	defer cancel()
 
-	reqURL := fmt.Sprintf("%s/%s.json", FawazAhmedExchangeRateURL, strings.ToLower(code))
+	base := strings.ToLower(pair.Base)
+	reqURL := fmt.Sprintf("%s/%s.json", FawazAhmedExchangeRateURL, base)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusNotFound {
-		return 0, ErrInvalidCurrencyCode
+		return Quote{}, ErrInvalidCurrencyCode
 	}
 
-	defer resp.Body.Close()
 	var fawazAhmedResponse FawazAhmedResponse
 	err = json.NewDecoder(resp.Body).Decode(&fawazAhmedResponse)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
+	}
+	rawRates, exists := fawazAhmedResponse[base]
+	if !exists {
+		return Quote{}, ErrInvalidCurrencyCode
+	}
+	var rates map[string]decimal.Decimal
+	err = json.Unmarshal(rawRates, &rates)
+	if err != nil {
+		return Quote{}, err
+	}
+	rate, exists := rates[strings.ToLower(pair.Quote)]
+	if !exists {
+		return Quote{}, ErrInvalidCurrencyCode
+	}
+
+	var date string
+	timestamp := time.Now()
+	if json.Unmarshal(fawazAhmedResponse["date"], &date) == nil {
+		if parsedDate, err := time.Parse(FawazDateLayout, date); err == nil {
+			timestamp = parsedDate
+		}
 	}
-	return fawazAhmedResponse.Rates.UAH, nil
+	return Quote{Mid: rate, Timestamp: timestamp}, nil
 }
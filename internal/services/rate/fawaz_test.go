@@ -0,0 +1,17 @@
+package rate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFawazRateFetcher_Supports(t *testing.T) {
+	fetcher := NewFawazRateFetcher("fawaz")
+	pair := NewCurrencyPair("EUR", "PLN")
+
+	assert.True(t, fetcher.Supports(pair, RateTypeMid))
+	assert.False(t, fetcher.Supports(pair, RateTypeOfficial), "middle market rate is not an official one")
+	assert.False(t, fetcher.Supports(pair, RateTypeBuy))
+	assert.False(t, fetcher.Supports(NewCurrencyPair("EUR", "EUR"), RateTypeMid))
+}
@@ -5,15 +5,20 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 const (
 	NBUExchangeRateURL = "https://bank.gov.ua/NBUStatService/v1/statdirectory/exchange?json"
+	NBUDateLayout      = "02.01.2006"
 )
 
 type NBUResponse struct {
-	Rate float32 `json:"rate"`
-	Code string  `json:"cc"`
+	Rate         decimal.Decimal `json:"rate"`
+	Code         string          `json:"cc"`
+	ExchangeDate string          `json:"exchangedate"`
 }
 
 type NBURateFetcher struct {
@@ -28,28 +33,37 @@ func NewNBURateFetcher(name string) NBURateFetcher {
 	return NBURateFetcher{name: name}
 }
 
-func (nrf NBURateFetcher) Fetch(ctx context.Context, code string, client *http.Client) (float32, error) {
+// Supports reports whether pair can be served, NBU publishes official rates against UAH only.
+func (nrf NBURateFetcher) Supports(pair CurrencyPair, rateType RateType) bool {
+	return pair.Quote == "UAH" && pair.Base != pair.Quote && rateType == RateTypeOfficial
+}
+
+func (nrf NBURateFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
 	ctx, cancel := context.WithTimeout(ctx, RateFetchTimeout)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, NBUExchangeRateURL, nil)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 	defer resp.Body.Close()
 	var nbuResponse []NBUResponse
 	err = json.NewDecoder(resp.Body).Decode(&nbuResponse)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 	for _, rate := range nbuResponse {
-		if strings.EqualFold(code, rate.Code) {
-			return rate.Rate, nil
+		if strings.EqualFold(pair.Base, rate.Code) {
+			timestamp, err := time.Parse(NBUDateLayout, rate.ExchangeDate)
+			if err != nil {
+				timestamp = time.Now()
+			}
+			return Quote{Official: rate.Rate, Timestamp: timestamp}, nil
 		}
 	}
-	return 0, ErrInvalidCurrencyCode
+	return Quote{}, ErrInvalidCurrencyCode
 }
@@ -0,0 +1,31 @@
+package rate
+
+import "strings"
+
+const (
+	DefaultBaseCurrency  = "USD"
+	DefaultQuoteCurrency = "UAH"
+)
+
+// CurrencyPair describes how many units of Quote currency one unit of Base currency costs.
+type CurrencyPair struct {
+	Base  string
+	Quote string
+}
+
+func NewCurrencyPair(base, quote string) CurrencyPair {
+	return CurrencyPair{Base: strings.ToUpper(base), Quote: strings.ToUpper(quote)}
+}
+
+func (cp CurrencyPair) String() string {
+	return cp.Base + "-" + cp.Quote
+}
+
+// ParseCurrencyPair parses pair in BASE-QUOTE format, e.g. USD-UAH.
+func ParseCurrencyPair(s string) (CurrencyPair, error) {
+	base, quote, found := strings.Cut(s, "-")
+	if !found || base == "" || quote == "" {
+		return CurrencyPair{}, ErrInvalidCurrencyPair
+	}
+	return NewCurrencyPair(base, quote), nil
+}
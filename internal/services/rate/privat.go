@@ -4,16 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 const (
 	PrivatExchangeRateURL = "https://api.privatbank.ua/p24api/pubinfo?json&exchange&coursid=5"
 )
 
+// PrivatSupportedCurrencies lists currencies PrivatBank quotes against UAH in cash exchange.
+var PrivatSupportedCurrencies = []string{"USD", "EUR"}
+
 type PrivatResponse struct {
-	Base     string `json:"base"`
+	Base     string `json:"base_ccy"`
 	Currency string `json:"ccy"`
 	Buy      string `json:"buy"`
 	Sale     string `json:"sale"`
@@ -31,32 +36,54 @@ func NewPrivatRateFetcher(name string) PrivatRateFetcher {
 	return PrivatRateFetcher{name: name}
 }
 
-func (prf PrivatRateFetcher) Fetch(ctx context.Context, code string, client *http.Client) (float32, error) {
+// Supports reports whether pair can be served, PrivatBank publishes retail rates only, so official rate
+// is left to other providers.
+func (prf PrivatRateFetcher) Supports(pair CurrencyPair, rateType RateType) bool {
+	if pair.Quote != "UAH" || rateType == RateTypeOfficial {
+		return false
+	}
+	for _, currency := range PrivatSupportedCurrencies {
+		if pair.Base == currency {
+			return true
+		}
+	}
+	return false
+}
+
+func (prf PrivatRateFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
 	ctx, cancel := context.WithTimeout(ctx, RateFetchTimeout)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, PrivatExchangeRateURL, nil)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 	defer resp.Body.Close()
 	var privatResponse []PrivatResponse
 	err = json.NewDecoder(resp.Body).Decode(&privatResponse)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 	for _, rate := range privatResponse {
-		if strings.EqualFold(code, rate.Currency) {
-			rateFloat64, err := strconv.ParseFloat(rate.Buy, 32)
+		if strings.EqualFold(pair.Base, rate.Currency) && strings.EqualFold(pair.Quote, rate.Base) {
+			buy, err := decimal.NewFromString(rate.Buy)
+			if err != nil {
+				return Quote{}, err
+			}
+			sale, err := decimal.NewFromString(rate.Sale)
 			if err != nil {
-				return 0, err
+				return Quote{}, err
 			}
-			return float32(rateFloat64), nil
+			return Quote{
+				Bid:       buy,
+				Ask:       sale,
+				Timestamp: time.Now(),
+			}, nil
 		}
 	}
-	return 0, ErrInvalidCurrencyCode
+	return Quote{}, ErrInvalidCurrencyCode
 }
@@ -0,0 +1,17 @@
+package rate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivatRateFetcher_Supports(t *testing.T) {
+	fetcher := NewPrivatRateFetcher("privat")
+	pair := NewCurrencyPair("USD", "UAH")
+
+	assert.True(t, fetcher.Supports(pair, RateTypeBuy))
+	assert.True(t, fetcher.Supports(pair, RateTypeSell))
+	assert.False(t, fetcher.Supports(pair, RateTypeOfficial), "official rate is not published by PrivatBank")
+	assert.False(t, fetcher.Supports(NewCurrencyPair("USD", "EUR"), RateTypeBuy))
+}
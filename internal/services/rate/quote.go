@@ -0,0 +1,57 @@
+package rate
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrInvalidRateType = errors.New("invalid rate type")
+
+// RateType selects which price of the quote is used as exchange rate.
+type RateType string
+
+const (
+	// RateTypeBuy is a price provider buys base currency at.
+	RateTypeBuy RateType = "buy"
+	// RateTypeSell is a price provider sells base currency at.
+	RateTypeSell RateType = "sell"
+	// RateTypeOfficial is a rate set by central bank.
+	RateTypeOfficial RateType = "official"
+	// RateTypeMid is a middle market rate, aggregators publish it for pairs without official rate as well.
+	RateTypeMid RateType = "mid"
+)
+
+func ParseRateType(s string) (RateType, error) {
+	switch rateType := RateType(s); rateType {
+	case RateTypeBuy, RateTypeSell, RateTypeOfficial, RateTypeMid:
+		return rateType, nil
+	}
+	return "", ErrInvalidRateType
+}
+
+// Quote holds prices of base currency in quote currency published by a provider,
+// zero price means provider does not publish it.
+type Quote struct {
+	Bid       decimal.Decimal
+	Ask       decimal.Decimal
+	Official  decimal.Decimal
+	Mid       decimal.Decimal
+	Timestamp time.Time
+}
+
+func (q Quote) Value(rateType RateType) (decimal.Decimal, bool) {
+	var value decimal.Decimal
+	switch rateType {
+	case RateTypeBuy:
+		value = q.Bid
+	case RateTypeSell:
+		value = q.Ask
+	case RateTypeOfficial:
+		value = q.Official
+	case RateTypeMid:
+		value = q.Mid
+	}
+	return value, !value.IsZero()
+}
@@ -3,31 +3,94 @@ package rate
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/fdemchenko/exchanger/internal/cache"
+	"github.com/fdemchenko/exchanger/internal/repositories"
 	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	DefaultCachingDuration = 15 * time.Minute
+	DefaultMaxStaleness    = time.Hour
 	RateFetchTimeout       = 10 * time.Second
+	// refresh ahead window is a tenth of update interval unless configured explicitly.
+	defaultRefreshAheadDivisor = 10
 )
 
-var ErrInvalidCurrencyCode = errors.New("invalid currency code")
+var (
+	ErrInvalidCurrencyCode = errors.New("invalid currency code")
+	ErrUnsupportedPair     = errors.New("currency pair is not supported by any provider")
+	ErrInvalidCurrencyPair = errors.New("invalid currency pair")
+	ErrRateTypeUnavailable = errors.New("rate type is not published by provider")
+)
+
+// Strategy defines how rate service combines results of several fetchers.
+type Strategy int
+
+const (
+	// FallbackStrategy queries fetchers one by one and returns the first successfully fetched rate.
+	FallbackStrategy Strategy = iota
+	// ConsensusStrategy queries all fetchers concurrently and returns median of the rates,
+	// that do not deviate from the others too much.
+	ConsensusStrategy
+)
+
+// Rate is an exchange rate of the pair along with providers it was obtained from.
+type Rate struct {
+	Pair CurrencyPair
+	Type RateType
+	// Value is a price of the Type taken from Quote.
+	Value     decimal.Decimal
+	Quote     Quote
+	Providers []string
+	FetchedAt time.Time
+	// Stale is set when rate is older than update interval,
+	// but could not be refreshed because all providers failed.
+	Stale bool
+}
+
+func (r Rate) Age() time.Duration {
+	return time.Since(r.FetchedAt)
+}
 
 type RateFetcher interface {
-	Fetch(ctx context.Context, code string, client *http.Client) (float32, error)
+	Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error)
+	// Supports reports whether fetcher is able to provide rate of the type for the pair.
+	Supports(pair CurrencyPair, rateType RateType) bool
 	Name() string
 }
 
+// Listener is notified about every rate freshly fetched from providers, it must not block.
+type Listener func(Rate)
+
+// HistoryRecorder persists every successfully fetched rate.
+type HistoryRecorder interface {
+	Insert(ctx context.Context, record repositories.RateRecord) error
+}
+
 type cachingRateService struct {
-	fetchers       []RateFetcher
-	client         *http.Client
-	updateInterval time.Duration
-	cache          *cache.Cache[string, float32]
+	fetchers        []RateFetcher
+	client          *http.Client
+	updateInterval  time.Duration
+	refreshAhead    time.Duration
+	maxStaleness    time.Duration
+	fetchGroup      singleflight.Group
+	cache           *cache.Cache[string, Rate]
+	historyRecorder HistoryRecorder
+	listeners       []Listener
+	strategy        Strategy
+	maxDeviation    float64
+	breakers        []*circuitBreakerFetcher
+	breakerSettings struct {
+		failureThreshold int
+		coolDown         time.Duration
+	}
 }
 
 type Option func(*cachingRateService)
@@ -44,43 +107,300 @@ func WithUpdateInterval(updateInterval time.Duration) Option {
 	}
 }
 
+// WithRefreshAhead sets how long before update interval elapses cached rate is refreshed in background.
+func WithRefreshAhead(refreshAhead time.Duration) Option {
+	return func(crs *cachingRateService) {
+		crs.refreshAhead = refreshAhead
+	}
+}
+
+// WithMaxStaleness sets for how long after update interval cached rate is served
+// if it cannot be refreshed.
+func WithMaxStaleness(maxStaleness time.Duration) Option {
+	return func(crs *cachingRateService) {
+		crs.maxStaleness = maxStaleness
+	}
+}
+
 func WithFetchers(fetchers ...RateFetcher) Option {
 	return func(crs *cachingRateService) {
 		crs.fetchers = fetchers
 	}
 }
 
+func WithHistoryRecorder(historyRecorder HistoryRecorder) Option {
+	return func(crs *cachingRateService) {
+		crs.historyRecorder = historyRecorder
+	}
+}
+
+// WithListeners registers listeners called with every freshly fetched rate, cached and stale rates are not reported.
+func WithListeners(listeners ...Listener) Option {
+	return func(crs *cachingRateService) {
+		crs.listeners = append(crs.listeners, listeners...)
+	}
+}
+
+func WithStrategy(strategy Strategy) Option {
+	return func(crs *cachingRateService) {
+		crs.strategy = strategy
+	}
+}
+
+// WithMaxDeviation sets relative deviation from the median (e.g. 0.05 for 5%),
+// after which provider's rate is considered an outlier in consensus strategy.
+func WithMaxDeviation(maxDeviation float64) Option {
+	return func(crs *cachingRateService) {
+		crs.maxDeviation = maxDeviation
+	}
+}
+
+// WithCircuitBreaker configures number of consecutive failures after which provider is skipped
+// and cool-down period after which it is tried again.
+func WithCircuitBreaker(failureThreshold int, coolDown time.Duration) Option {
+	return func(crs *cachingRateService) {
+		crs.breakerSettings.failureThreshold = failureThreshold
+		crs.breakerSettings.coolDown = coolDown
+	}
+}
+
 func NewRateService(options ...Option) *cachingRateService {
 	// caching rate service with default values.
 	service := &cachingRateService{
 		client:         http.DefaultClient,
 		updateInterval: DefaultCachingDuration,
+		maxStaleness:   DefaultMaxStaleness,
 		fetchers:       []RateFetcher{NewNBURateFetcher("nbu fetcher")},
-		cache:          cache.New[string, float32](),
+		cache:          cache.New[string, Rate](),
+		strategy:       FallbackStrategy,
+		maxDeviation:   DefaultMaxDeviation,
 	}
+	service.breakerSettings.failureThreshold = DefaultBreakerFailureThreshold
+	service.breakerSettings.coolDown = DefaultBreakerCoolDown
 
 	for _, option := range options {
 		option(service)
 	}
+	if service.refreshAhead == 0 {
+		service.refreshAhead = service.updateInterval / defaultRefreshAheadDivisor
+	}
+
+	// every provider is guarded by its own circuit breaker.
+	guardedFetchers := make([]RateFetcher, 0, len(service.fetchers))
+	for _, fetcher := range service.fetchers {
+		breaker := newCircuitBreakerFetcher(
+			fetcher,
+			service.breakerSettings.failureThreshold,
+			service.breakerSettings.coolDown,
+		)
+		service.breakers = append(service.breakers, breaker)
+		guardedFetchers = append(guardedFetchers, breaker)
+	}
+	service.fetchers = guardedFetchers
 	return service
 }
 
-func (crs *cachingRateService) GetRate(ctx context.Context, currencyCode string) (float32, error) {
-	if rate, exists := crs.cache.Get(strings.ToLower(currencyCode)); exists {
-		return rate, nil
+// Providers returns health status of every configured rate provider.
+func (crs *cachingRateService) Providers() []ProviderStatus {
+	statuses := make([]ProviderStatus, 0, len(crs.breakers))
+	for _, breaker := range crs.breakers {
+		statuses = append(statuses, breaker.Status())
 	}
+	return statuses
+}
 
+// Supports reports whether any provider is able to provide rate of the type for the pair.
+func (crs *cachingRateService) Supports(pair CurrencyPair, rateType RateType) bool {
+	return len(crs.supportingFetchers(pair, rateType)) > 0
+}
+
+// GetRate returns cached rate of the pair, fetching it from providers if cached one is too old.
+// Rates approaching expiration are refreshed in background, expired rates are served as stale
+// for up to max staleness period if providers fail.
+func (crs *cachingRateService) GetRate(ctx context.Context, pair CurrencyPair, rateType RateType) (Rate, error) {
+	cachedRate, exists := crs.cache.Get(cacheKey(pair, rateType))
+	if exists {
+		age := cachedRate.Age()
+		if age < crs.updateInterval {
+			if age >= crs.updateInterval-crs.refreshAhead {
+				crs.refreshInBackground(pair, rateType)
+			}
+			return cachedRate, nil
+		}
+	}
+
+	rate, err := crs.fetch(ctx, pair, rateType)
+	if err != nil {
+		// cache keeps rates for max staleness after update interval, so existing rate is still acceptable.
+		if exists && !errors.Is(err, ErrUnsupportedPair) {
+			log.Warn().Err(err).Stringer("pair", pair).Dur("age", cachedRate.Age()).Msg("Serving stale rate")
+			cachedRate.Stale = true
+			return cachedRate, nil
+		}
+		return Rate{}, err
+	}
+	return rate, nil
+}
+
+func cacheKey(pair CurrencyPair, rateType RateType) string {
+	return pair.String() + "/" + string(rateType)
+}
+
+func (crs *cachingRateService) refreshInBackground(pair CurrencyPair, rateType RateType) {
+	// result is not awaited, fetching runs in goroutine of singleflight group
+	// and joins fetch of the same pair if it is already in flight.
+	crs.fetchGroup.DoChan(cacheKey(pair, rateType), func() (any, error) {
+		rate, err := crs.fetchFromProviders(context.Background(), pair, rateType)
+		if err != nil {
+			log.Warn().Err(err).Stringer("pair", pair).Msg("Background rate refresh failed")
+		}
+		return rate, err
+	})
+}
+
+// fetch makes sure only one fetch per pair is in flight, concurrent callers wait for its result,
+// each of them still can give up on its own context cancellation.
+func (crs *cachingRateService) fetch(ctx context.Context, pair CurrencyPair, rateType RateType) (Rate, error) {
+	results := crs.fetchGroup.DoChan(cacheKey(pair, rateType), func() (any, error) {
+		// shared fetch must not be cancelled if the caller, that started it, has gone.
+		return crs.fetchFromProviders(context.WithoutCancel(ctx), pair, rateType)
+	})
+
+	select {
+	case result := <-results:
+		if result.Shared {
+			metrics.GetOrCreateCounter("rate_fetches_coalesced_total").Inc()
+		}
+		if result.Err != nil {
+			return Rate{}, result.Err
+		}
+		return result.Val.(Rate), nil
+	case <-ctx.Done():
+		return Rate{}, ctx.Err()
+	}
+}
+
+func (crs *cachingRateService) fetchFromProviders(
+	ctx context.Context,
+	pair CurrencyPair,
+	rateType RateType,
+) (Rate, error) {
+	fetchers := crs.supportingFetchers(pair, rateType)
+	if len(fetchers) == 0 {
+		return Rate{}, ErrUnsupportedPair
+	}
+
+	var rate Rate
 	var err error
-	var rate float32
+	switch crs.strategy {
+	case ConsensusStrategy:
+		rate, err = crs.fetchConsensus(ctx, pair, rateType, fetchers)
+	case FallbackStrategy:
+		rate, err = crs.fetchWithFallback(ctx, pair, rateType, fetchers)
+	}
+	if err != nil {
+		return Rate{}, err
+	}
+
+	crs.cache.Set(cacheKey(pair, rateType), rate, crs.updateInterval+crs.maxStaleness)
+	for _, listener := range crs.listeners {
+		listener(rate)
+	}
+	return rate, nil
+}
+
+func (crs *cachingRateService) supportingFetchers(pair CurrencyPair, rateType RateType) []RateFetcher {
+	var fetchers []RateFetcher
 	for _, fetcher := range crs.fetchers {
-		rate, err = fetcher.Fetch(ctx, currencyCode, crs.client)
-		log.Debug().Str("name", fetcher.Name()).Float32("rate", rate).Err(err).Send()
+		if fetcher.Supports(pair, rateType) {
+			fetchers = append(fetchers, fetcher)
+		}
+	}
+	return fetchers
+}
+
+// fetchQuote fetches quote from the provider and makes sure it contains price of requested type.
+func (crs *cachingRateService) fetchQuote(
+	ctx context.Context,
+	fetcher RateFetcher,
+	pair CurrencyPair,
+	rateType RateType,
+) (Quote, decimal.Decimal, error) {
+	quote, err := fetcher.Fetch(ctx, pair, crs.client)
+	if err != nil {
+		return Quote{}, decimal.Zero, err
+	}
+	value, ok := quote.Value(rateType)
+	if !ok {
+		return Quote{}, decimal.Zero, ErrRateTypeUnavailable
+	}
+	crs.recordHistory(ctx, fetcher.Name(), pair, rateType, value)
+	return quote, value, nil
+}
+
+func (crs *cachingRateService) fetchWithFallback(
+	ctx context.Context,
+	pair CurrencyPair,
+	rateType RateType,
+	fetchers []RateFetcher,
+) (Rate, error) {
+	var errs []error
+	for _, fetcher := range fetchers {
+		quote, value, err := crs.fetchQuote(ctx, fetcher, pair, rateType)
+		log.Debug().Str("name", fetcher.Name()).Stringer("pair", pair).Stringer("rate", value).Err(err).Send()
 		if err == nil {
-			crs.cache.Set(strings.ToLower(currencyCode), rate, crs.updateInterval)
-			return rate, nil
+			return Rate{
+				Pair:      pair,
+				Type:      rateType,
+				Value:     value,
+				Quote:     quote,
+				Providers: []string{fetcher.Name()},
+				FetchedAt: time.Now(),
+			}, nil
 		}
 		log.Warn().Str("provider", fetcher.Name()).Err(err).Msg("Fallback to another provider")
+		errs = append(errs, err)
 	}
 
-	return 0, err
+	return Rate{}, allProvidersFailed(errs)
+}
+
+// allProvidersFailed reports pair as unsupported only if every provider has rejected its currencies,
+// otherwise failure is temporary and errors of the providers are wrapped into ErrProviderUnavailable.
+func allProvidersFailed(errs []error) error {
+	var failures []error
+	for _, err := range errs {
+		if !errors.Is(err, ErrUnsupportedPair) && !errors.Is(err, ErrInvalidCurrencyCode) {
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) == 0 {
+		return errors.Join(errs...)
+	}
+	return fmt.Errorf("%w: %w", ErrProviderUnavailable, errors.Join(failures...))
+}
+
+func (crs *cachingRateService) recordHistory(
+	ctx context.Context,
+	provider string,
+	pair CurrencyPair,
+	rateType RateType,
+	rate decimal.Decimal,
+) {
+	if crs.historyRecorder == nil {
+		return
+	}
+	record := repositories.RateRecord{
+		Provider:  provider,
+		Base:      pair.Base,
+		Quote:     pair.Quote,
+		Type:      string(rateType),
+		Rate:      rate,
+		FetchedAt: time.Now(),
+	}
+	// rate must be recorded even if client, that triggered fetching, has gone.
+	err := crs.historyRecorder.Insert(context.WithoutCancel(ctx), record)
+	if err != nil {
+		log.Error().Err(err).Str("provider", provider).Msg("Cannot save rate to history")
+	}
 }
@@ -3,9 +3,12 @@ package rate
 import (
 	"context"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -13,16 +16,37 @@ import (
 const (
 	TestingRateServiceFetchInterval   = time.Second * 1
 	TestingRateServiceWaitingDuration = time.Second * 2
-	ExpectedExchangeRate              = float32(8.0)
 )
 
+var (
+	TestingCurrencyPair  = NewCurrencyPair("usd", "uah")
+	ExpectedExchangeRate = decimal.NewFromInt(8)
+)
+
+// quoteFromArguments allows mocks to return either full quote or just official rate.
+func quoteFromArguments(args mock.Arguments) (Quote, error) {
+	if quote, ok := args.Get(0).(Quote); ok {
+		return quote, args.Error(1)
+	}
+	return Quote{Official: args.Get(0).(decimal.Decimal), Timestamp: time.Now()}, args.Error(1)
+}
+
+func assertDecimalEqual(t *testing.T, expected, actual decimal.Decimal) {
+	t.Helper()
+	assert.Truef(t, expected.Equal(actual), "expected %s, got %s", expected, actual)
+}
+
 type MockNBUFetcher struct {
 	mock.Mock
 }
 
-func (mnf *MockNBUFetcher) Fetch(ctx context.Context, code string, client *http.Client) (float32, error) {
+func (mnf *MockNBUFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
 	args := mnf.Called()
-	return args.Get(0).(float32), args.Error(1)
+	return quoteFromArguments(args)
+}
+
+func (mnf *MockNBUFetcher) Supports(pair CurrencyPair, rateType RateType) bool {
+	return true
 }
 
 func (mnf *MockNBUFetcher) Name() string {
@@ -33,9 +57,13 @@ type MockFawazFetcher struct {
 	mock.Mock
 }
 
-func (mff *MockFawazFetcher) Fetch(ctx context.Context, code string, client *http.Client) (float32, error) {
+func (mff *MockFawazFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
 	args := mff.Called()
-	return args.Get(0).(float32), args.Error(1)
+	return quoteFromArguments(args)
+}
+
+func (mff *MockFawazFetcher) Supports(pair CurrencyPair, rateType RateType) bool {
+	return true
 }
 
 func (mff *MockFawazFetcher) Name() string {
@@ -46,9 +74,13 @@ type MockPrivatFetcher struct {
 	mock.Mock
 }
 
-func (mpf *MockPrivatFetcher) Fetch(ctx context.Context, code string, client *http.Client) (float32, error) {
+func (mpf *MockPrivatFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
 	args := mpf.Called()
-	return args.Get(0).(float32), args.Error(1)
+	return quoteFromArguments(args)
+}
+
+func (mpf *MockPrivatFetcher) Supports(pair CurrencyPair, rateType RateType) bool {
+	return true
 }
 
 func (mpf *MockPrivatFetcher) Name() string {
@@ -61,25 +93,42 @@ func TestRateService_RateIsCorrect(t *testing.T) {
 	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
 
 	rateService := NewRateService(WithFetchers(mockNBUFetcher))
-	rate, err := rateService.GetRate(ctx, "usd")
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
 
-	assert.Equal(t, float32(8.0), rate)
+	assertDecimalEqual(t, decimal.NewFromFloat(8.0), rate.Value)
 	assert.NoError(t, err)
 }
 
 func TestRateService_RateIsCached(t *testing.T) {
 	ctx := context.Background()
 	mockNBUFetcher := new(MockNBUFetcher)
-	mockNBUFetcher.On("Fetch").Return(float32(8.0), nil)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(8.0), nil)
 
 	rateService := NewRateService(WithFetchers(mockNBUFetcher))
-	_, _ = rateService.GetRate(ctx, "usd")
-	_, _ = rateService.GetRate(ctx, "usd")
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
 
 	// Make sure service cached result from previoues calls.
 	mockNBUFetcher.AssertNumberOfCalls(t, "Fetch", 1)
 }
 
+func TestRateService_ListenersGetFreshRatesOnly(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+
+	var notified []Rate
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher),
+		WithListeners(func(rate Rate) { notified = append(notified, rate) }),
+	)
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+
+	assert.Len(t, notified, 1)
+	assertDecimalEqual(t, ExpectedExchangeRate, notified[0].Value)
+}
+
 func TestRateService_RateIsFetchedAfterInterval(t *testing.T) {
 	ctx := context.Background()
 	mockNBUFetcher := new(MockNBUFetcher)
@@ -90,9 +139,9 @@ func TestRateService_RateIsFetchedAfterInterval(t *testing.T) {
 		WithUpdateInterval(TestingRateServiceFetchInterval))
 
 	// Make sure service re-fetch after update interval.
-	_, _ = rateService.GetRate(ctx, "usd")
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
 	time.Sleep(TestingRateServiceWaitingDuration)
-	_, _ = rateService.GetRate(ctx, "usd")
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
 
 	mockNBUFetcher.AssertNumberOfCalls(t, "Fetch", 2)
 }
@@ -100,10 +149,10 @@ func TestRateService_RateIsFetchedAfterInterval(t *testing.T) {
 func TestRateService_FallbackToAnotherFetcher(t *testing.T) {
 	ctx := context.Background()
 	mockNBUFetcher := new(MockNBUFetcher)
-	mockNBUFetcher.On("Fetch").Return(float32(0), ErrInvalidCurrencyCode)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrInvalidCurrencyCode)
 
 	mockFawazFetcher := new(MockFawazFetcher)
-	mockFawazFetcher.On("Fetch").Return(float32(0), ErrInvalidCurrencyCode)
+	mockFawazFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrInvalidCurrencyCode)
 
 	mockPrivatFetcher := new(MockPrivatFetcher)
 	mockPrivatFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
@@ -112,11 +161,247 @@ func TestRateService_FallbackToAnotherFetcher(t *testing.T) {
 		WithFetchers(mockNBUFetcher, mockFawazFetcher, mockPrivatFetcher),
 		WithUpdateInterval(TestingRateServiceFetchInterval))
 
-	rate, err := rateService.GetRate(ctx, "usd")
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
 	assert.NoError(t, err)
-	assert.Equal(t, ExpectedExchangeRate, rate)
+	assertDecimalEqual(t, ExpectedExchangeRate, rate.Value)
 
 	mockNBUFetcher.AssertCalled(t, "Fetch")
 	mockFawazFetcher.AssertCalled(t, "Fetch")
 	mockPrivatFetcher.AssertCalled(t, "Fetch")
 }
+
+func TestRateService_MixedProviderFailures(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrInvalidCurrencyCode)
+	mockFawazFetcher := new(MockFawazFetcher)
+	mockFawazFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrProviderIsDown)
+
+	// pair is served by fawaz once it is up, so it must not be reported as unsupported.
+	rateService := NewRateService(WithFetchers(mockNBUFetcher, mockFawazFetcher))
+	_, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+	assert.ErrorIs(t, err, ErrProviderIsDown)
+	assert.NotErrorIs(t, err, ErrInvalidCurrencyCode)
+
+	mockPrivatFetcher := new(MockPrivatFetcher)
+	mockPrivatFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrInvalidCurrencyCode)
+
+	rateService = NewRateService(WithFetchers(mockNBUFetcher, mockPrivatFetcher))
+	_, err = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.ErrorIs(t, err, ErrInvalidCurrencyCode)
+	assert.NotErrorIs(t, err, ErrProviderUnavailable)
+}
+
+type UnsupportedPairFetcher struct {
+	mock.Mock
+}
+
+func (upf *UnsupportedPairFetcher) Fetch(ctx context.Context, pair CurrencyPair, client *http.Client) (Quote, error) {
+	args := upf.Called()
+	return quoteFromArguments(args)
+}
+
+func (upf *UnsupportedPairFetcher) Supports(pair CurrencyPair, rateType RateType) bool {
+	return false
+}
+
+func (upf *UnsupportedPairFetcher) Name() string {
+	return "unsupported fetcher"
+}
+
+func TestRateService_SkipsFetchersWithoutPairSupport(t *testing.T) {
+	ctx := context.Background()
+	unsupportedFetcher := new(UnsupportedPairFetcher)
+
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+
+	rateService := NewRateService(WithFetchers(unsupportedFetcher, mockNBUFetcher))
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assertDecimalEqual(t, ExpectedExchangeRate, rate.Value)
+
+	unsupportedFetcher.AssertNotCalled(t, "Fetch")
+}
+
+func TestRateService_UnsupportedPair(t *testing.T) {
+	ctx := context.Background()
+	unsupportedFetcher := new(UnsupportedPairFetcher)
+
+	rateService := NewRateService(WithFetchers(unsupportedFetcher))
+	_, err := rateService.GetRate(ctx, NewCurrencyPair("eur", "pln"), RateTypeOfficial)
+	assert.ErrorIs(t, err, ErrUnsupportedPair)
+}
+
+type HistoryRecorderMock struct {
+	records []repositories.RateRecord
+}
+
+func (hrm *HistoryRecorderMock) Insert(_ context.Context, record repositories.RateRecord) error {
+	hrm.records = append(hrm.records, record)
+	return nil
+}
+
+func TestRateService_FetchedRateIsRecorded(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+	recorder := new(HistoryRecorderMock)
+
+	rateService := NewRateService(WithFetchers(mockNBUFetcher), WithHistoryRecorder(recorder))
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+
+	// Cached rates are not recorded twice.
+	assert.Len(t, recorder.records, 1)
+	assert.Equal(t, mockNBUFetcher.Name(), recorder.records[0].Provider)
+	assert.Equal(t, "USD", recorder.records[0].Base)
+	assert.Equal(t, "UAH", recorder.records[0].Quote)
+	assertDecimalEqual(t, ExpectedExchangeRate, recorder.records[0].Rate)
+}
+
+func TestRateService_StaleRateServedOnError(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil).Once()
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrProviderIsDown)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher),
+		WithUpdateInterval(TestingRateServiceFetchInterval),
+		WithMaxStaleness(time.Hour),
+	)
+
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assert.False(t, rate.Stale)
+
+	time.Sleep(TestingRateServiceWaitingDuration)
+	rate, err = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assert.True(t, rate.Stale)
+	assertDecimalEqual(t, ExpectedExchangeRate, rate.Value)
+	assert.GreaterOrEqual(t, rate.Age(), TestingRateServiceWaitingDuration)
+}
+
+func TestRateService_TooStaleRateIsNotServed(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil).Once()
+	mockNBUFetcher.On("Fetch").Return(decimal.NewFromFloat(0), ErrProviderIsDown)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher),
+		WithUpdateInterval(TestingRateServiceFetchInterval),
+		WithMaxStaleness(0),
+	)
+
+	_, _ = rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	time.Sleep(TestingRateServiceWaitingDuration)
+	_, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.ErrorIs(t, err, ErrProviderIsDown)
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+}
+
+func TestRateService_RateIsRefreshedInBackground(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+
+	rateService := NewRateService(
+		WithFetchers(mockNBUFetcher),
+		WithUpdateInterval(TestingRateServiceWaitingDuration),
+		WithRefreshAhead(TestingRateServiceFetchInterval),
+	)
+
+	initialRate, _ := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	time.Sleep(TestingRateServiceFetchInterval)
+	// Rate is close to expiration, so cached one is returned and refreshed in background.
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assert.Equal(t, initialRate.FetchedAt, rate.FetchedAt)
+
+	assert.Eventually(t, func() bool {
+		refreshedRate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+		return err == nil && refreshedRate.FetchedAt.After(initialRate.FetchedAt)
+	}, TestingRateServiceWaitingDuration, TestingBreakerCoolDown)
+}
+
+func TestRateService_ConcurrentCacheMissesAreCoalesced(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil).After(TestingBreakerCoolDown)
+
+	rateService := NewRateService(WithFetchers(mockNBUFetcher))
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+			assert.NoError(t, err)
+			assertDecimalEqual(t, ExpectedExchangeRate, rate.Value)
+		}()
+	}
+	wg.Wait()
+
+	mockNBUFetcher.AssertNumberOfCalls(t, "Fetch", 1)
+}
+
+func TestRateService_WaiterCancellationIsHonoured(t *testing.T) {
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil).After(TestingRateServiceFetchInterval)
+
+	rateService := NewRateService(WithFetchers(mockNBUFetcher))
+
+	ctx, cancel := context.WithTimeout(context.Background(), TestingBreakerCoolDown)
+	defer cancel()
+	_, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeOfficial)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Fetch started by cancelled caller is completed and cached for others.
+	rate, err := rateService.GetRate(context.Background(), TestingCurrencyPair, RateTypeOfficial)
+	assert.NoError(t, err)
+	assertDecimalEqual(t, ExpectedExchangeRate, rate.Value)
+	mockNBUFetcher.AssertNumberOfCalls(t, "Fetch", 1)
+}
+
+func TestRateService_RateTypeIsTakenFromQuote(t *testing.T) {
+	ctx := context.Background()
+	quote := Quote{Bid: decimal.NewFromFloat(40.1), Ask: decimal.NewFromFloat(40.7), Official: decimal.NewFromFloat(40.4), Timestamp: time.Now()}
+	mockPrivatFetcher := new(MockPrivatFetcher)
+	mockPrivatFetcher.On("Fetch").Return(quote, nil)
+
+	rateService := NewRateService(WithFetchers(mockPrivatFetcher))
+
+	expectedValues := map[RateType]decimal.Decimal{
+		RateTypeBuy:      quote.Bid,
+		RateTypeSell:     quote.Ask,
+		RateTypeOfficial: quote.Official,
+	}
+	for rateType, expectedValue := range expectedValues {
+		rate, err := rateService.GetRate(ctx, TestingCurrencyPair, rateType)
+		assert.NoError(t, err)
+		assert.Equal(t, rateType, rate.Type)
+		assertDecimalEqual(t, expectedValue, rate.Value)
+		assert.Equal(t, quote, rate.Quote)
+	}
+}
+
+func TestRateService_FallbackWhenRateTypeIsNotPublished(t *testing.T) {
+	ctx := context.Background()
+	mockNBUFetcher := new(MockNBUFetcher)
+	mockNBUFetcher.On("Fetch").Return(ExpectedExchangeRate, nil)
+
+	mockPrivatFetcher := new(MockPrivatFetcher)
+	mockPrivatFetcher.On("Fetch").Return(Quote{Bid: decimal.NewFromFloat(40.1), Ask: decimal.NewFromFloat(40.7), Official: decimal.NewFromFloat(40.4)}, nil)
+
+	rateService := NewRateService(WithFetchers(mockNBUFetcher, mockPrivatFetcher))
+
+	rate, err := rateService.GetRate(ctx, TestingCurrencyPair, RateTypeSell)
+	assert.NoError(t, err)
+	assertDecimalEqual(t, decimal.NewFromFloat(40.7), rate.Value)
+	assert.Equal(t, []string{"privat fetcher"}, rate.Providers)
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/fdemchenko/exchanger/internal/services/rate"
+)
+
+const (
+	FrequencyHourly = "hourly"
+	FrequencyDaily  = "daily"
+	FrequencyWeekly = "weekly"
+)
+
+const (
+	DefaultDeliveryHour = 10
+	DefaultTimezone     = "UTC"
+	// WeeklyDeliveryDay is a day of the week weekly emails are sent at.
+	WeeklyDeliveryDay = time.Monday
+)
+
+var (
+	ErrInvalidFrequency = errors.New("invalid email frequency")
+	ErrInvalidTimezone  = errors.New("invalid timezone")
+	ErrInvalidHour      = errors.New("invalid delivery hour")
+)
+
+// DefaultPreferences matches the schedule all subscribers had before preferences were introduced.
+func DefaultPreferences() repositories.Preferences {
+	return repositories.Preferences{
+		Pairs:        []string{rate.NewCurrencyPair(rate.DefaultBaseCurrency, rate.DefaultQuoteCurrency).String()},
+		Frequency:    FrequencyDaily,
+		DeliveryHour: DefaultDeliveryHour,
+		Timezone:     DefaultTimezone,
+	}
+}
+
+func IsValidFrequency(frequency string) bool {
+	return frequency == FrequencyHourly || frequency == FrequencyDaily || frequency == FrequencyWeekly
+}
+
+// ValidatePreferences checks frequency, delivery hour and timezone, pairs are validated by caller.
+func ValidatePreferences(preferences repositories.Preferences) error {
+	if !IsValidFrequency(preferences.Frequency) {
+		return ErrInvalidFrequency
+	}
+	if preferences.DeliveryHour < 0 || preferences.DeliveryHour > 23 {
+		return ErrInvalidHour
+	}
+	if _, err := time.LoadLocation(preferences.Timezone); err != nil || preferences.Timezone == "" {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
+
+// isDue reports if rates should be sent to subscriber in the hour now belongs to.
+// Hours are taken in subscriber's timezone, so hourly window is the same for everybody,
+// while daily and weekly emails arrive at the preferred local time.
+func isDue(subscription repositories.Subscription, now time.Time) bool {
+	location, err := time.LoadLocation(subscription.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	// truncation is done on local time, because some timezones are not aligned to hours.
+	windowStart := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, location)
+	if subscription.LastSentAt.Valid && !subscription.LastSentAt.Time.Before(windowStart) {
+		return false
+	}
+
+	switch subscription.Frequency {
+	case FrequencyHourly:
+		return true
+	case FrequencyWeekly:
+		return local.Weekday() == WeeklyDeliveryDay && local.Hour() == subscription.DeliveryHour
+	default:
+		return local.Hour() == subscription.DeliveryHour
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDue(t *testing.T) {
+	// Monday, 07:20 UTC, that is 10:20 in Kyiv and 12:50 in Kolkata.
+	now := time.Date(2024, time.June, 3, 7, 20, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		frequency  string
+		hour       int
+		timezone   string
+		lastSentAt time.Time
+		expected   bool
+	}{
+		{name: "Daily at local hour", frequency: FrequencyDaily, hour: 10, timezone: "Europe/Kyiv", expected: true},
+		{name: "Daily at server hour", frequency: FrequencyDaily, hour: 10, timezone: "UTC"},
+		{name: "Daily in half hour timezone", frequency: FrequencyDaily, hour: 12, timezone: "Asia/Kolkata",
+			expected: true},
+		{name: "Already sent in window", frequency: FrequencyDaily, hour: 10, timezone: "Europe/Kyiv",
+			lastSentAt: now.Add(-10 * time.Minute)},
+		{name: "Sent in previous window", frequency: FrequencyHourly, timezone: "Europe/Kyiv",
+			lastSentAt: now.Add(-30 * time.Minute), expected: true},
+		{name: "Half hour window", frequency: FrequencyHourly, timezone: "Asia/Kolkata",
+			lastSentAt: now.Add(-25 * time.Minute)},
+		{name: "Weekly on Monday", frequency: FrequencyWeekly, hour: 7, timezone: "UTC", expected: true},
+		{name: "Weekly on local Sunday", frequency: FrequencyWeekly, hour: 21, timezone: "Pacific/Honolulu"},
+		{name: "Unknown timezone", frequency: FrequencyDaily, hour: 7, timezone: "Mars/Olympus", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subscription := repositories.Subscription{
+				Preferences: repositories.Preferences{
+					Frequency:    tc.frequency,
+					DeliveryHour: tc.hour,
+					Timezone:     tc.timezone,
+				},
+				LastSentAt: sql.NullTime{Time: tc.lastSentAt, Valid: !tc.lastSentAt.IsZero()},
+			}
+			assert.Equal(t, tc.expected, isDue(subscription, now))
+		})
+	}
+}
+
+func TestValidatePreferences(t *testing.T) {
+	assert.NoError(t, ValidatePreferences(DefaultPreferences()))
+
+	preferences := DefaultPreferences()
+	preferences.Frequency = "monthly"
+	assert.ErrorIs(t, ValidatePreferences(preferences), ErrInvalidFrequency)
+
+	preferences = DefaultPreferences()
+	preferences.DeliveryHour = 24
+	assert.ErrorIs(t, ValidatePreferences(preferences), ErrInvalidHour)
+
+	preferences = DefaultPreferences()
+	preferences.Timezone = "Europe/Atlantis"
+	assert.ErrorIs(t, ValidatePreferences(preferences), ErrInvalidTimezone)
+}
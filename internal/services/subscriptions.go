@@ -1,14 +1,24 @@
 package services
 
 import (
+	"context"
 	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/fdemchenko/exchanger/internal/repositories"
+	"github.com/rs/zerolog/log"
 )
 
 type SubscriptonsRepository interface {
-	Insert(email string) (int, error)
+	Insert(email string, preferences repositories.Preferences, newMessage repositories.OutboxMessageFunc) (int, error)
+	Confirm(id int, newMessage repositories.OutboxMessageFunc) (string, error)
 	GetAll() ([]string, error)
+	GetActive() ([]repositories.Subscription, error)
+	MarkSent(ids []int, sentAt time.Time) error
 	DeleteByEmail(email string) error
 	DeleteByID(id int) error
+	DeleteUnconfirmed(createdBefore time.Time) (int64, error)
 }
 
 type subscriptionServiceImpl struct {
@@ -23,16 +33,69 @@ func NewSubscriptionService(
 	}
 }
 
-func (ss *subscriptionServiceImpl) Create(email string) (int, error) {
+// Create saves pending subscription along with message built by newMessage, which may be nil.
+func (ss *subscriptionServiceImpl) Create(
+	email string,
+	preferences repositories.Preferences,
+	newMessage repositories.OutboxMessageFunc,
+) (int, error) {
+	if err := ValidatePreferences(preferences); err != nil {
+		return 0, err
+	}
 	// email is case insensitive
 	email = strings.ToLower(email)
-	return ss.subscriptionsRepository.Insert(email)
+	return ss.subscriptionsRepository.Insert(email, preferences, newMessage)
+}
+
+// Confirm activates pending subscription and returns its email, message built by newMessage is saved along.
+func (ss *subscriptionServiceImpl) Confirm(id int, newMessage repositories.OutboxMessageFunc) (string, error) {
+	return ss.subscriptionsRepository.Confirm(id, newMessage)
+}
+
+// StartCleanup deletes subscriptions, that are not confirmed within ttl, every interval until ctx is cancelled.
+func (ss *subscriptionServiceImpl) StartCleanup(ctx context.Context, interval, ttl time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				deleted, err := ss.subscriptionsRepository.DeleteUnconfirmed(time.Now().Add(-ttl))
+				if err != nil {
+					log.Error().Err(err).Msg("Cannot delete unconfirmed subscriptions")
+					continue
+				}
+				metrics.GetOrCreateCounter("unconfirmed_subscriptions_deleted_total").Add(int(deleted))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 }
 
 func (ss *subscriptionServiceImpl) GetAll() ([]string, error) {
 	return ss.subscriptionsRepository.GetAll()
 }
 
+// GetDue returns active subscriptions, which should receive rates in the hour now belongs to.
+func (ss *subscriptionServiceImpl) GetDue(now time.Time) ([]repositories.Subscription, error) {
+	subscriptions, err := ss.subscriptionsRepository.GetActive()
+	if err != nil {
+		return nil, err
+	}
+	var due []repositories.Subscription
+	for _, subscription := range subscriptions {
+		if isDue(subscription, now) {
+			due = append(due, subscription)
+		}
+	}
+	return due, nil
+}
+
+func (ss *subscriptionServiceImpl) MarkSent(ids []int, sentAt time.Time) error {
+	return ss.subscriptionsRepository.MarkSent(ids, sentAt)
+}
+
 func (ss *subscriptionServiceImpl) DeleteByEmail(email string) error {
 	// email is case insensitive
 	email = strings.ToLower(email)
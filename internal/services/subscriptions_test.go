@@ -3,6 +3,7 @@ package services
 import (
 	"slices"
 	"testing"
+	"time"
 
 	"github.com/fdemchenko/exchanger/internal/repositories"
 	"github.com/stretchr/testify/assert"
@@ -16,7 +17,19 @@ func (er *SubscriptonsRepositoryMock) GetAll() ([]string, error) {
 	return er.emails, nil
 }
 
-func (er *SubscriptonsRepositoryMock) Insert(email string) (int, error) {
+func (er *SubscriptonsRepositoryMock) GetActive() ([]repositories.Subscription, error) {
+	return nil, nil
+}
+
+func (er *SubscriptonsRepositoryMock) MarkSent(ids []int, sentAt time.Time) error {
+	return nil
+}
+
+func (er *SubscriptonsRepositoryMock) Insert(
+	email string,
+	_ repositories.Preferences,
+	_ repositories.OutboxMessageFunc,
+) (int, error) {
 	if slices.Contains(er.emails, email) {
 		return 0, repositories.ErrDuplicateEmail
 	}
@@ -31,13 +44,22 @@ func (er *SubscriptonsRepositoryMock) DeleteByEmail(email string) error {
 func (er *SubscriptonsRepositoryMock) DeleteByID(id int) error {
 	return nil
 }
+
+func (er *SubscriptonsRepositoryMock) Confirm(id int, _ repositories.OutboxMessageFunc) (string, error) {
+	return "", nil
+}
+
+func (er *SubscriptonsRepositoryMock) DeleteUnconfirmed(createdBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
 func TestEmailService_CreateEmails(t *testing.T) {
 	emailRepo := new(SubscriptonsRepositoryMock)
 	emails := []string{"example@mail.com", "school@edu.ua"}
 
 	emailService := NewSubscriptionService(emailRepo)
 	for _, newEmail := range emails {
-		_, err := emailService.Create(newEmail)
+		_, err := emailService.Create(newEmail, DefaultPreferences(), nil)
 		assert.NoError(t, err)
 	}
 }
@@ -47,10 +69,10 @@ func TestEmailService_CaseInsensitiveness(t *testing.T) {
 	emails := []string{"example@mail.com", "EXamPlE@maIl.Com"}
 
 	emailService := NewSubscriptionService(emailRepo)
-	_, err := emailService.Create(emails[0])
+	_, err := emailService.Create(emails[0], DefaultPreferences(), nil)
 	assert.NoError(t, err)
 
-	_, err = emailService.Create(emails[1])
+	_, err = emailService.Create(emails[1], DefaultPreferences(), nil)
 	assert.ErrorIs(t, err, repositories.ErrDuplicateEmail)
 }
 
@@ -60,7 +82,7 @@ func TestEmailService_GetEmails(t *testing.T) {
 
 	emailService := NewSubscriptionService(emailRepo)
 	for _, newEmail := range emails {
-		_, err := emailService.Create(newEmail)
+		_, err := emailService.Create(newEmail, DefaultPreferences(), nil)
 		assert.NoError(t, err)
 	}
 
@@ -75,10 +97,10 @@ func TestEmailService_CreateDuplicateEmail(t *testing.T) {
 
 	emailService := NewSubscriptionService(emailRepo)
 	for _, newEmail := range emails {
-		_, err := emailService.Create(newEmail)
+		_, err := emailService.Create(newEmail, DefaultPreferences(), nil)
 		assert.NoError(t, err)
 	}
 
-	_, err := emailService.Create(emails[0])
+	_, err := emailService.Create(emails[0], DefaultPreferences(), nil)
 	assert.Equal(t, err, repositories.ErrDuplicateEmail)
 }
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"time"
+
+	"github.com/fdemchenko/exchanger/internal/tokens"
+)
+
+const UnsubscribeTokenPurpose tokens.Purpose = "unsubscribe"
+
+// UnsubscribeLinks builds signed per-recipient unsubscribe links, so only owner of the email can unsubscribe.
+// Links do not expire, because they are kept in old emails.
+type UnsubscribeLinks struct {
+	signer  *tokens.Signer
+	baseURL string
+}
+
+func NewUnsubscribeLinks(signer *tokens.Signer, baseURL string) *UnsubscribeLinks {
+	return &UnsubscribeLinks{signer: signer, baseURL: baseURL}
+}
+
+func (ul *UnsubscribeLinks) URL(email string) string {
+	return ul.baseURL + "/unsubscribe/" + ul.signer.Sign(UnsubscribeTokenPurpose, email, time.Time{})
+}
+
+// Email returns email unsubscribe token was issued for.
+func (ul *UnsubscribeLinks) Email(token string) (string, error) {
+	return ul.signer.Verify(UnsubscribeTokenPurpose, token)
+}
@@ -0,0 +1,75 @@
+// Package tokens issues and verifies HMAC signed tokens, that are sent to users in links.
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Purpose separates tokens issued for different actions, so token of one purpose
+// cannot be used for another.
+type Purpose string
+
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// Sign issues token for the subject, token does not expire if expiresAt is zero.
+func (s *Signer) Sign(purpose Purpose, subject string, expiresAt time.Time) string {
+	var expiry int64
+	if !expiresAt.IsZero() {
+		expiry = expiresAt.Unix()
+	}
+	payload := strings.Join([]string{string(purpose), strconv.FormatInt(expiry, 10), subject}, "|")
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString([]byte(payload)) + "." + encoding.EncodeToString(s.mac(payload))
+}
+
+// Verify checks token signature, purpose and expiration and returns subject token was issued for.
+func (s *Signer) Verify(purpose Purpose, token string) (string, error) {
+	encodedPayload, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, s.mac(string(payload))) {
+		return "", ErrInvalidToken
+	}
+
+	parts := strings.SplitN(string(payload), "|", 3)
+	if len(parts) != 3 || parts[0] != string(purpose) {
+		return "", ErrInvalidToken
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if expiry != 0 && time.Now().Unix() >= expiry {
+		return "", ErrExpiredToken
+	}
+	return parts[2], nil
+}
+
+func (s *Signer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
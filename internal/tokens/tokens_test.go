@@ -0,0 +1,55 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TestingPurpose      Purpose = "testing"
+	OtherTestingPurpose Purpose = "other"
+)
+
+func TestSigner_Verify(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+
+	token := signer.Sign(TestingPurpose, "42", time.Now().Add(time.Hour))
+	subject, err := signer.Verify(TestingPurpose, token)
+	assert.NoError(t, err)
+	assert.Equal(t, "42", subject)
+
+	token = signer.Sign(TestingPurpose, "user|with|separators", time.Time{})
+	subject, err = signer.Verify(TestingPurpose, token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user|with|separators", subject)
+}
+
+func TestSigner_Rejects(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	token := signer.Sign(TestingPurpose, "42", time.Now().Add(time.Hour))
+
+	testCases := []struct {
+		name     string
+		signer   *Signer
+		purpose  Purpose
+		token    string
+		expected error
+	}{
+		{name: "Another purpose", signer: signer, purpose: OtherTestingPurpose, token: token, expected: ErrInvalidToken},
+		{name: "Another secret", signer: NewSigner([]byte("other")), purpose: TestingPurpose, token: token,
+			expected: ErrInvalidToken},
+		{name: "Tampered", signer: signer, purpose: TestingPurpose, token: "x" + token, expected: ErrInvalidToken},
+		{name: "Malformed", signer: signer, purpose: TestingPurpose, token: "token", expected: ErrInvalidToken},
+		{name: "Expired", signer: signer, purpose: TestingPurpose,
+			token: signer.Sign(TestingPurpose, "42", time.Now().Add(-time.Second)), expected: ErrExpiredToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.signer.Verify(tc.purpose, tc.token)
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
+}
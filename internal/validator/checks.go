@@ -8,3 +8,10 @@ var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9
 func IsValidEmail(email string) bool {
 	return EmailRX.MatchString(email)
 }
+
+var CurrencyCodeRX = regexp.MustCompile("^[a-zA-Z]{3}$")
+
+// IsValidCurrencyCode checks that code looks like ISO 4217 alphabetic code.
+func IsValidCurrencyCode(code string) bool {
+	return CurrencyCodeRX.MatchString(code)
+}
@@ -36,3 +36,23 @@ func TestValidator(t *testing.T) {
 		})
 	}
 }
+
+func TestCurrencyCodeValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		code    string
+		isValid bool
+	}{
+		{name: "Upper case", code: "USD", isValid: true},
+		{name: "Lower case", code: "eur", isValid: true},
+		{name: "Too short", code: "US", isValid: false},
+		{name: "Too long", code: "USDT", isValid: false},
+		{name: "Digits", code: "U5D", isValid: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.isValid, IsValidCurrencyCode(tc.code))
+		})
+	}
+}
@@ -0,0 +1,10 @@
+CREATE TABLE rates (
+    id BIGSERIAL PRIMARY KEY,
+    provider TEXT NOT NULL,
+    base_currency CHAR(3) NOT NULL,
+    quote_currency CHAR(3) NOT NULL,
+    rate NUMERIC(20, 10) NOT NULL,
+    fetched_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX rates_pair_fetched_at_idx ON rates (base_currency, quote_currency, fetched_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS rates_pair_type_fetched_at_idx;
+CREATE INDEX rates_pair_fetched_at_idx ON rates (base_currency, quote_currency, fetched_at);
+
+ALTER TABLE rates DROP COLUMN IF EXISTS rate_type;
@@ -0,0 +1,4 @@
+ALTER TABLE rates ADD COLUMN rate_type TEXT NOT NULL DEFAULT 'official';
+
+DROP INDEX IF EXISTS rates_pair_fetched_at_idx;
+CREATE INDEX rates_pair_type_fetched_at_idx ON rates (base_currency, quote_currency, rate_type, fetched_at);
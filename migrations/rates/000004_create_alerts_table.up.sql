@@ -0,0 +1,15 @@
+CREATE TABLE alerts (
+    id SERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions (id) ON DELETE CASCADE,
+    base_currency TEXT NOT NULL,
+    quote_currency TEXT NOT NULL,
+    rate_type TEXT NOT NULL DEFAULT 'official',
+    kind TEXT NOT NULL,
+    threshold NUMERIC(20, 10) NOT NULL,
+    last_rate NUMERIC(20, 10),
+    last_notified_rate NUMERIC(20, 10),
+    last_notified_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX alerts_pair_type_idx ON alerts (base_currency, quote_currency, rate_type);
@@ -0,0 +1,3 @@
+DELETE FROM subscriptions WHERE status = 'pending';
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS confirmed_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS status;
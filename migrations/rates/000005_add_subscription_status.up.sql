@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE subscriptions ADD COLUMN confirmed_at timestamp(0) with time zone;
+
+-- subscriptions created before confirmation was introduced are considered confirmed.
+UPDATE subscriptions SET status = 'active', confirmed_at = created_at;
@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS last_sent_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS timezone;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS delivery_hour;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS frequency;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS pairs;
@@ -0,0 +1,6 @@
+ALTER TABLE subscriptions ADD COLUMN pairs TEXT[] NOT NULL DEFAULT '{USD-UAH}';
+ALTER TABLE subscriptions ADD COLUMN frequency TEXT NOT NULL DEFAULT 'daily';
+ALTER TABLE subscriptions ADD COLUMN delivery_hour SMALLINT NOT NULL DEFAULT 10
+    CHECK (delivery_hour >= 0 AND delivery_hour < 24);
+ALTER TABLE subscriptions ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE subscriptions ADD COLUMN last_sent_at timestamp(0) with time zone;
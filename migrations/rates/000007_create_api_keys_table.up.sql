@@ -0,0 +1,10 @@
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- only SHA-256 hash of the key is stored, keys are shown once when issued.
+    key_hash BYTEA NOT NULL UNIQUE,
+    -- requests per minute, default quota of keys is applied when it is not set.
+    rate_limit INTEGER CHECK (rate_limit > 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    revoked_at timestamp(0) with time zone
+);
@@ -0,0 +1,10 @@
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    queue TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- messages are kept for a while after publishing to investigate delivery issues.
+    sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
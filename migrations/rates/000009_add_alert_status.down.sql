@@ -0,0 +1,3 @@
+DELETE FROM alerts WHERE status = 'pending';
+ALTER TABLE alerts DROP COLUMN IF EXISTS confirmed_at;
+ALTER TABLE alerts DROP COLUMN IF EXISTS status;
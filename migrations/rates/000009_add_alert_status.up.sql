@@ -0,0 +1,5 @@
+ALTER TABLE alerts ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE alerts ADD COLUMN confirmed_at timestamp(0) with time zone;
+
+-- alerts created before confirmation was introduced are considered confirmed.
+UPDATE alerts SET status = 'active', confirmed_at = created_at;
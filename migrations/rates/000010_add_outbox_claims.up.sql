@@ -0,0 +1,2 @@
+-- relay claims messages before publishing them, so other relays skip them until claim expires.
+ALTER TABLE outbox ADD COLUMN claimed_until timestamp(0) with time zone;
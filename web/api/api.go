@@ -0,0 +1,10 @@
+package api
+
+import (
+	_ "embed"
+)
+
+// OpenAPISpec describes the current API version.
+//
+//go:embed "openapi.json"
+var OpenAPISpec []byte
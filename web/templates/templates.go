@@ -6,3 +6,15 @@ import (
 
 //go:embed "rate_update.tmpl"
 var MessageTemplate string
+
+//go:embed "rate_alert.tmpl"
+var AlertTemplate string
+
+//go:embed "confirmation.tmpl"
+var ConfirmationTemplate string
+
+//go:embed "unsubscribe.html"
+var UnsubscribePage string
+
+//go:embed "alert_delete.html"
+var DeleteAlertPage string